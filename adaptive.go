@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// adaptiveLimiter limits requests in flight by -adaptive, adjusting the limit AIMD-style like TCP
+// congestion control: it starts at min, grows by one after as many successes in a row as the limit,
+// and halves on rate limiting or server errors, within [min, max]. It is shared by all request
+// goroutines. A nil *adaptiveLimiter never blocks.
+type adaptiveLimiter struct {
+	mu        sync.Mutex
+	min, max  int
+	limit     int
+	inFlight  int
+	successes int           // in a row since the limit last changed
+	epoch     int           // incremented on every backoff
+	backoffs  int           // times the limit was halved
+	changed   chan struct{} // closed when a slot may have been freed
+}
+
+func newAdaptiveLimiter(min, max int) *adaptiveLimiter {
+	return &adaptiveLimiter{min: min, max: max, limit: min, changed: make(chan struct{})}
+}
+
+// acquire blocks until a request can be sent or ctx is done. Pass the returned epoch to release
+func (l *adaptiveLimiter) acquire(ctx context.Context) (epoch int, err error) {
+	if l == nil {
+		return 0, nil
+	}
+	for {
+		l.mu.Lock()
+		if l.inFlight < l.limit {
+			l.inFlight++
+			epoch = l.epoch
+			l.mu.Unlock()
+			return epoch, nil
+		}
+		changed := l.changed
+		l.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// release frees the slot of a request acquired at epoch, adjusting the limit by its outcome.
+// A statusCode of 0 without err means it wasn't sent, e.g. the circuit is open, which changes nothing
+func (l *adaptiveLimiter) release(epoch, statusCode int, err error) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	switch {
+	case err != nil || statusCode == http.StatusTooManyRequests || statusCode >= 500:
+		// Requests in flight when the limit was halved already saw the congestion, halve once for them all
+		if epoch == l.epoch {
+			l.epoch++
+			l.backoffs++
+			l.successes = 0
+			if l.limit /= 2; l.limit < l.min {
+				l.limit = l.min
+			}
+		}
+	case statusCode == http.StatusCreated:
+		if l.successes++; l.successes >= l.limit && l.limit < l.max {
+			l.limit++
+			l.successes = 0
+		}
+	}
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// stats returns the limit and the number of backoffs so far
+func (l *adaptiveLimiter) stats() (limit, backoffs int) {
+	if l == nil {
+		return 0, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit, l.backoffs
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveLimiter(t *testing.T) {
+	l := newAdaptiveLimiter(1, 4)
+	steps := []struct {
+		status    int
+		err       error
+		wantLimit int
+	}{
+		// Grows by one after as many successes as the limit
+		{http.StatusCreated, nil, 2},
+		{http.StatusCreated, nil, 2},
+		{http.StatusCreated, nil, 3},
+		{http.StatusCreated, nil, 3},
+		{http.StatusBadRequest, nil, 3}, // neither success nor congestion
+		{http.StatusCreated, nil, 3},
+		{http.StatusCreated, nil, 4},
+		{http.StatusCreated, nil, 4}, // at max
+		{http.StatusCreated, nil, 4},
+		{http.StatusCreated, nil, 4},
+		{http.StatusCreated, nil, 4},
+		// Halves on congestion, never below min
+		{http.StatusTooManyRequests, nil, 2},
+		{http.StatusServiceUnavailable, nil, 1},
+		{0, errors.New("connection reset"), 1},
+		{0, nil, 1}, // not sent
+		{http.StatusCreated, nil, 2},
+	}
+	for i, step := range steps {
+		epoch, err := l.acquire(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.release(epoch, step.status, step.err)
+		if limit, _ := l.stats(); limit != step.wantLimit {
+			t.Errorf("step %d(%d, %v): expected the limit %d, got %d", i, step.status, step.err, step.wantLimit, limit)
+		}
+	}
+	if _, backoffs := l.stats(); backoffs != 3 {
+		t.Errorf("expected 3 backoffs, got %d", backoffs)
+	}
+
+	// Requests in flight together when congestion is seen halve the limit once
+	l = newAdaptiveLimiter(1, 8)
+	l.limit = 4
+	var epochs []int
+	for i := 0; i < 4; i++ {
+		epoch, _ := l.acquire(context.Background())
+		epochs = append(epochs, epoch)
+	}
+	for _, epoch := range epochs {
+		l.release(epoch, http.StatusTooManyRequests, nil)
+	}
+	if limit, backoffs := l.stats(); limit != 2 || backoffs != 1 {
+		t.Errorf("expected a single backoff to 2, got the limit %d after %d backoffs", limit, backoffs)
+	}
+
+	// A nil limiter never blocks
+	var disabled *adaptiveLimiter
+	if _, err := disabled.acquire(context.Background()); err != nil {
+		t.Errorf("a nil limiter should never block, got %v", err)
+	}
+	disabled.release(0, http.StatusTooManyRequests, nil)
+}
+
+func TestAdaptiveLimiterBlocks(t *testing.T) {
+	l := newAdaptiveLimiter(1, 1)
+	epoch, _ := l.acquire(context.Background())
+
+	acquired := make(chan struct{})
+	go func() {
+		l.acquire(context.Background())
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatalf("a request should wait while the limit is in flight")
+	case <-time.After(20 * time.Millisecond):
+	}
+	l.release(epoch, http.StatusCreated, nil)
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatalf("a released slot should be taken by a waiting request")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := l.acquire(ctx); err != context.Canceled {
+		t.Errorf("expected cancellation while waiting, got %v", err)
+	}
+}
+
+func TestInvalidationAdaptive(t *testing.T) {
+	// Succeeds a while, then rate limits the rest
+	statuses := []int{http.StatusCreated, http.StatusCreated, http.StatusCreated, http.StatusCreated, http.StatusTooManyRequests}
+	ts, _ := newTestServer(statuses...)
+	defer ts.Close()
+
+	config := newTestConfig(ts)
+	config.maxObjects = 1
+	config.maxDelay = time.Millisecond
+	config.retryOn = statusSet{}
+	config.concurrency = newAdaptiveLimiter(1, 8)
+	in := strings.Repeat("https://example.com/a\n", 8)
+	if err := Invalidation(context.Background(), config, strings.NewReader(in)); err != nil {
+		t.Fatal(err)
+	}
+	limit, backoffs := config.concurrency.stats()
+	if backoffs == 0 || limit >= 3 {
+		t.Errorf("expected the limit to grow on successes and back off on 429, got %d after %d backoffs", limit, backoffs)
+	}
+	summary := Summary{Concurrency: limit, Backoffs: backoffs}
+	if !strings.Contains(summary.String(), "concurrency: ") {
+		t.Errorf("the summary should show -adaptive: %s", summary)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// stringList is a repeatable flag collecting its values in order
+type stringList []string
+
+func (list *stringList) String() string {
+	return strings.Join(*list, ",")
+}
+
+func (list *stringList) Set(value string) error {
+	*list = append(*list, value)
+	return nil
+}
+
+// resolveArgObjects takes objects given by -url, -cpcode or -tag into config.objects, setting the object type.
+// They are purged along with files, or stdin given as "-", and have to be of the type of the subcommand, if any
+func resolveArgObjects(config *Config, fs *flag.FlagSet) error {
+	given := map[string]stringList{"url": config.urls, "cpcode": config.cpcodes, "tag": config.tags}
+	objectType := ""
+	for t, objects := range given {
+		if len(objects) == 0 {
+			continue
+		}
+		if len(objectType) > 0 {
+			return errors.New("you should specify objects by only one of -url, -cpcode and -tag")
+		}
+		objectType = t
+	}
+	if len(objectType) == 0 {
+		return nil
+	}
+	if len(config.objectType) > 0 && config.objectType != objectType {
+		return fmt.Errorf("you should specify objects by -%s with %q subcommand", config.objectType, config.objectType)
+	}
+	if len(config.jsonPointer) > 0 {
+		return fmt.Errorf("objects given by -%s can't be combined with -json-pointer, which reads JSON input", objectType)
+	}
+	// Files are chunked together with the objects as text lists
+	if config.fileType != "text" {
+		return fmt.Errorf("objects given by -%s can't be combined with -t %s, files are read as text lists with them", objectType, config.fileType)
+	}
+	if config.flattenJSON {
+		return fmt.Errorf("objects given by -%s can't be combined with -flatten-json, which reads JSON input", objectType)
+	}
+	config.objectType = objectType
+	config.objects = given[objectType]
+	return nil
+}
+
+// argInput returns objects given by flags as a text list for InvalidateByURLs
+func argInput(config *Config) io.Reader {
+	return strings.NewReader(strings.Join(config.objects, "\n") + "\n")
+}
+
+// combinedInput returns objects given by flags followed by lists of paths as one text list, so that they
+// are chunked together. Every list is opened first, so that a missing one fails before anything is sent
+func combinedInput(ctx context.Context, config *Config, paths []string) (io.ReadCloser, error) {
+	in := &multiInput{readers: []io.Reader{argInput(config)}}
+	for _, p := range paths {
+		fp, err := openInput(ctx, config, p)
+		if err != nil {
+			in.Close()
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+		// A list without a trailing newline would join its last line with the next list
+		in.readers = append(in.readers, fp, strings.NewReader("\n"))
+		in.closers = append(in.closers, fp)
+	}
+	in.Reader = io.MultiReader(in.readers...)
+	return in, nil
+}
+
+// multiInput reads lists one after another, closing all of them at once
+type multiInput struct {
+	io.Reader
+	readers []io.Reader
+	closers []io.Closer
+}
+
+func (in *multiInput) Close() error {
+	for _, c := range in.closers {
+		c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestArgObjects(t *testing.T) {
+	ts, rec := newTestServer(http.StatusCreated)
+	defer ts.Close()
+
+	config := newTestConfig(ts)
+	fs := newFlagSet(config, "test")
+	if err := fs.Parse([]string{"-url", "https://example.com/a", "-url", "https://example.com/b"}); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := resolveArgObjects(config, fs); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := Invalidation(context.Background(), config, argInput(config)); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if rec.count() != 1 {
+		t.Fatalf("expected objects from flags in one request, got %d", rec.count())
+	}
+	want := `{"objects":["https://example.com/a","https://example.com/b"]}`
+	if got := rec.joinedBodies(); got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
+
+func TestResolveArgObjects(t *testing.T) {
+	tests := []struct {
+		objectType string
+		args       []string
+		wantType   string
+		valid      bool
+	}{
+		{"", []string{"-cpcode", "12345", "-cpcode", "67890"}, "cpcode", true},
+		{"tag", []string{"-tag", "product-123"}, "tag", true},
+		{"", nil, "", true},
+		{"", []string{"-url", "https://example.com/a", "-tag", "product-123"}, "", false},
+		{"cpcode", []string{"-url", "https://example.com/a"}, "", false},
+		{"", []string{"-url", "https://example.com/a", "urls.txt"}, "url", true},
+		{"", []string{"-url", "https://example.com/a", "-t", "json", "urls.json"}, "", false},
+		{"", []string{"-url", "https://example.com/a", "-t", "csv"}, "", false},
+		{"", []string{"-url", "https://example.com/a", "-flatten-json"}, "", false},
+	}
+	for _, tt := range tests {
+		config := Config{objectType: tt.objectType}
+		fs := newFlagSet(&config, "test")
+		if err := fs.Parse(tt.args); err != nil {
+			t.Fatalf("%s", err)
+		}
+		err := resolveArgObjects(&config, fs)
+		if tt.valid && err != nil {
+			t.Errorf("%v: should be valid: %s", tt.args, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("%v: should be invalid but passed", tt.args)
+		}
+		if tt.valid && config.objectType != tt.wantType {
+			t.Errorf("%v: expected object type %q, got %q", tt.args, tt.wantType, config.objectType)
+		}
+	}
+}
+
+func TestArgObjectsWithFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "purge-args")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// Without trailing newlines, which shouldn't join lines of the lists
+	a, b := filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")
+	if err := ioutil.WriteFile(a, []byte("https://example.com/c\nhttps://example.com/a"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(b, []byte("https://example.com/d"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		dedupe bool
+		want   string
+	}{
+		{false, `{"objects":["https://example.com/a","https://example.com/b","https://example.com/c","https://example.com/a","https://example.com/d"]}`},
+		{true, `{"objects":["https://example.com/a","https://example.com/b","https://example.com/c","https://example.com/d"]}`},
+	} {
+		ts, rec := newTestServer(http.StatusCreated)
+		config := newTestConfig(ts)
+		fs := newFlagSet(config, "test")
+		if err := fs.Parse([]string{"-url", "https://example.com/a", "-url", "https://example.com/b", a, b}); err != nil {
+			t.Fatal(err)
+		}
+		config.dedupe = tt.dedupe
+		if err := resolveArgObjects(config, fs); err != nil {
+			t.Fatal(err)
+		}
+		if err := InvalidateFiles(context.Background(), config, fs.Args()); err != nil {
+			t.Fatal(err)
+		}
+		ts.Close()
+
+		if n := rec.count(); n != 1 {
+			t.Fatalf("dedupe %t: expected objects of flags and files in one request, got %d", tt.dedupe, n)
+		}
+		if got := rec.joinedBodies(); got != tt.want {
+			t.Errorf("dedupe %t: expected %s, got %s", tt.dedupe, tt.want, got)
+		}
+	}
+}
+
+func TestArgObjectsWithMissingFile(t *testing.T) {
+	ts, rec := newTestServer(http.StatusCreated)
+	defer ts.Close()
+	config := newTestConfig(ts)
+	config.objects = []string{"https://example.com/a"}
+	if err := InvalidateFiles(context.Background(), config, []string{"testdata/missing.txt"}); err == nil {
+		t.Error("a missing list should fail")
+	}
+	if n := rec.count(); n != 0 {
+		t.Errorf("nothing should be sent when a list is missing, got %d requests", n)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error retry with exponential backoff and jitter
+// akamai api limits: https://developer.akamai.com/api/purge/ccu/overview.html#limits
+// exponential backoff: https://www.awsarchitectureblog.com/2015/03/backoff.html
+const baseDuration = 5 * time.Second
+
+// maxBackoff caps delays however many retries there are, even when -max-delay is unlimited, so that
+// neither the shift of exponential nor the jitter arithmetic overflows time.Duration
+const maxBackoff = 24 * time.Hour
+
+// maxBackoffShift is the largest count exponential shifts by, baseDuration<<maxBackoffShift is well
+// beyond maxBackoff while far from overflowing
+const maxBackoffShift = 20
+
+// jitter returns the delay before the retry following attempt count. prev is the previous delay, 0 at first.
+// max caps the delay, 0 means unlimited. rnd picks the randomness
+type jitter func(count int, prev, max time.Duration, rnd randSource) time.Duration
+
+// jitterStrategies are algorithms selectable by -jitter
+var jitterStrategies = map[string]jitter{
+	"full":         fullJitter,
+	"equal":        equalJitter,
+	"decorrelated": decorrelatedJitter,
+	"none":         noJitter,
+}
+
+// nextDelay returns the delay before the retry following attempt count using -jitter and -max-delay,
+// picking randomness from rnd. -no-jitter overrides -jitter
+func (config *Config) nextDelay(count int, prev time.Duration, rnd randSource) time.Duration {
+	strategy, ok := jitterStrategies[config.jitter]
+	switch {
+	case config.noJitter:
+		strategy = noJitter
+	case !ok:
+		strategy = jitterStrategies[defaultJitter]
+	}
+	return strategy(count, prev, config.maxDelay, rnd)
+}
+
+// capDelay returns d, or max when d exceeds it. max 0 means unlimited, which is maxBackoff
+func capDelay(d, max time.Duration) time.Duration {
+	if max <= 0 || max > maxBackoff {
+		max = maxBackoff
+	}
+	if d > max {
+		return max
+	}
+	return d
+}
+
+// randDuration returns a random duration in [0, n)
+func randDuration(rnd randSource, n time.Duration) time.Duration {
+	if n <= 0 {
+		return 0
+	}
+	return time.Duration(rnd.Int63n(int64(n)))
+}
+
+// exponential returns baseDuration * 2^count capped by max. count is clamped, since a shift of 31 or
+// more would overflow into negative or zero delays
+func exponential(count int, max time.Duration) time.Duration {
+	if count < 0 {
+		count = 0
+	}
+	if count > maxBackoffShift {
+		count = maxBackoffShift
+	}
+	return capDelay(baseDuration<<uint32(count), max)
+}
+
+// fullJitter is "Full Jitter" algorithm, a random delay in [0, exponential)
+func fullJitter(count int, prev, max time.Duration, rnd randSource) time.Duration {
+	return randDuration(rnd, exponential(count, max))
+}
+
+// equalJitter is "Equal Jitter" algorithm, a random delay in [exponential/2, exponential).
+// It keeps some backoff for sure, and is the default
+func equalJitter(count int, prev, max time.Duration, rnd randSource) time.Duration {
+	d := exponential(count, max)
+	return d/2 + randDuration(rnd, d/2)
+}
+
+// noJitter is the plain exponential backoff without randomness, so that delays are reproducible
+func noJitter(count int, prev, max time.Duration, rnd randSource) time.Duration {
+	return exponential(count, max)
+}
+
+// decorrelatedJitter is "Decorrelated Jitter" algorithm, a random delay in [baseDuration, prev*3) capped by max
+func decorrelatedJitter(count int, prev, max time.Duration, rnd randSource) time.Duration {
+	if prev < baseDuration {
+		prev = baseDuration
+	}
+	// prev is capped by maxBackoff, so that tripling it can't overflow
+	prev = capDelay(prev, maxBackoff)
+	return capDelay(baseDuration+randDuration(rnd, prev*3-baseDuration), max)
+}
+
+// defaultRetryOn is the default of -retry-on, rate limits and transient server errors
+var defaultRetryOn = statusSet{
+	http.StatusTooManyRequests:     true,
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusGatewayTimeout:      true,
+	http.StatusInsufficientStorage: true,
+}
+
+// statusSet is a set of HTTP status codes given as a comma-separated flag value like "429,503"
+type statusSet map[int]bool
+
+func (set statusSet) String() string {
+	codes := make([]int, 0, len(set))
+	for code := range set {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	strs := make([]string, len(codes))
+	for i, code := range codes {
+		strs[i] = strconv.Itoa(code)
+	}
+	return strings.Join(strs, ",")
+}
+
+// Set replaces the set with the given codes, so that an empty value disables retries on statuses
+func (set *statusSet) Set(value string) error {
+	codes := statusSet{}
+	for _, s := range strings.Split(value, ",") {
+		if s = strings.TrimSpace(s); len(s) == 0 {
+			continue
+		}
+		code, err := strconv.Atoi(s)
+		if err != nil || code < 100 || code > 599 {
+			return fmt.Errorf("%q is not an HTTP status code", s)
+		}
+		codes[code] = true
+	}
+	*set = codes
+	return nil
+}
+
+// retryable reports whether a response with status should be retried according to -retry-on
+func (config *Config) retryable(status int) bool {
+	if config.retryOn == nil {
+		return defaultRetryOn[status]
+	}
+	return config.retryOn[status]
+}
+
+// retryableError reports whether a connection error from client.Do is worth retrying. Timeouts and
+// errors like connection refused or reset may pass, but unknown hosts and certificate errors won't
+func retryableError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return !dnsErr.IsNotFound
+	}
+	var unknownAuthority x509.UnknownAuthorityError
+	var invalid x509.CertificateInvalidError
+	var hostname x509.HostnameError
+	if errors.As(err, &unknownAuthority) || errors.As(err, &invalid) || errors.As(err, &hostname) {
+		return false
+	}
+	return true
+}
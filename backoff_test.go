@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestJitterBounds(t *testing.T) {
+	tests := []struct {
+		jitter   string
+		count    int
+		prev     time.Duration
+		max      time.Duration
+		min, sup time.Duration // the delay should be in [min, sup]
+	}{
+		{"full", 0, 0, 0, 0, 5 * time.Second},
+		{"full", 3, 0, 0, 0, 40 * time.Second},
+		{"full", 3, 0, 10 * time.Second, 0, 10 * time.Second},
+		{"equal", 0, 0, 0, 2500 * time.Millisecond, 5 * time.Second},
+		{"equal", 3, 0, 0, 20 * time.Second, 40 * time.Second},
+		{"equal", 3, 0, 10 * time.Second, 5 * time.Second, 10 * time.Second},
+		{"decorrelated", 0, 0, 0, 5 * time.Second, 15 * time.Second},
+		{"decorrelated", 5, 20 * time.Second, 0, 5 * time.Second, 60 * time.Second},
+		{"decorrelated", 5, 20 * time.Second, 10 * time.Second, 5 * time.Second, 10 * time.Second},
+		// The default is "equal", which the backoff has been since the beginning
+		{"", 0, 0, 0, 2500 * time.Millisecond, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		config := Config{jitter: tt.jitter, maxDelay: tt.max}
+		for i := 0; i < 1000; i++ {
+			if d := config.nextDelay(tt.count, tt.prev, config.randOrDefault()); d < tt.min || d > tt.sup {
+				t.Fatalf("%q count %d prev %s max %s: %s is out of [%s, %s]", tt.jitter, tt.count, tt.prev, tt.max, d, tt.min, tt.sup)
+			}
+		}
+	}
+}
+
+func TestDecorrelatedJitterGrows(t *testing.T) {
+	// Decorrelated jitter grows from the previous delay, not the attempt count
+	config := Config{jitter: "decorrelated"}
+	var delay, longest time.Duration
+	for i := 0; i < 20; i++ {
+		delay = config.nextDelay(0, delay, config.randOrDefault())
+		if delay > longest {
+			longest = delay
+		}
+	}
+	if longest <= 15*time.Second {
+		t.Errorf("expected delays to grow beyond the first range, the longest is %s", longest)
+	}
+}
+
+func TestValidationJitter(t *testing.T) {
+	for jitter, valid := range map[string]bool{"full": true, "equal": true, "decorrelated": true, "none": true, "random": false} {
+		config := Config{method: "invalidate", network: "staging", fileType: "text", jitter: jitter, edgeConf: validTestEdgeConfig}
+		if err := Validation(&config); (err == nil) != valid {
+			t.Errorf("-jitter %s: expected valid %v, got %v", jitter, valid, err)
+		}
+	}
+	config := Config{method: "invalidate", network: "staging", fileType: "text", maxDelay: -time.Second, edgeConf: validTestEdgeConfig}
+	if err := Validation(&config); err == nil {
+		t.Errorf("negative -max-delay should be invalid")
+	}
+}
+
+func TestNoJitter(t *testing.T) {
+	// -no-jitter wins over -jitter
+	config := Config{jitter: "full", noJitter: true, maxDelay: 30 * time.Second}
+	want := []time.Duration{5 * time.Second, 10 * time.Second, 20 * time.Second, 30 * time.Second, 30 * time.Second}
+	var delay time.Duration
+	for i, w := range want {
+		if delay = config.nextDelay(i, delay, config.randOrDefault()); delay != w {
+			t.Errorf("attempt %d: expected %s, got %s", i, w, delay)
+		}
+	}
+	config = Config{jitter: "none"}
+	if d := config.nextDelay(2, 0, config.randOrDefault()); d != 20*time.Second {
+		t.Errorf("-jitter none: expected 20s, got %s", d)
+	}
+}
+
+func TestSeededJitter(t *testing.T) {
+	delays := func(seed int64) []time.Duration {
+		config := Config{jitter: "full", rand: newSeededRand(seed)}
+		var delays []time.Duration
+		var delay time.Duration
+		for i := 0; i < 5; i++ {
+			delay = config.nextDelay(i, delay, config.randOrDefault())
+			delays = append(delays, delay)
+		}
+		return delays
+	}
+	first, second := delays(42), delays(42)
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("the same seed should give the same delays, got %v and %v", first, second)
+	}
+	if other := delays(43); reflect.DeepEqual(first, other) {
+		t.Errorf("another seed should give other delays, got %v twice", first)
+	}
+}
+
+func TestSeededJitterConcurrentRequests(t *testing.T) {
+	// Delays of each request, keyed by its object, while requests retry concurrently
+	delays := func(seed int64) map[string][]interface{} {
+		ts, _ := newTestServer(http.StatusServiceUnavailable)
+		defer ts.Close()
+		hook, restore := captureLog()
+		defer restore()
+
+		var mu sync.Mutex
+		objects := map[string]string{}
+		config := newTestConfig(ts)
+		config.clock = &fakeClock{}
+		config.seed, config.rand, config.randPerRequest = seed, newSeededRand(seed), true
+		config.onResult = func(result PurgeResult) {
+			mu.Lock()
+			defer mu.Unlock()
+			objects[result.RequestID] = result.FailedObjects[0]
+		}
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go invalidationRequest(context.Background(), config, []byte(`{"objects":["https://example.com/`+strconv.Itoa(i)+`"]}`), &wg)
+		}
+		wg.Wait()
+
+		byObject := map[string][]interface{}{}
+		for _, entry := range hook.AllEntries() {
+			if delay, ok := entry.Data["next_delay"]; ok {
+				object := objects[entry.Data["request_id"].(string)]
+				byObject[object] = append(byObject[object], delay)
+			}
+		}
+		if len(byObject) != 8 {
+			t.Fatalf("expected delays of 8 requests, got %v", byObject)
+		}
+		return byObject
+	}
+	first, second := delays(42), delays(42)
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("the same seed should give the same delays per request, got %v and %v", first, second)
+	}
+	if other := delays(43); reflect.DeepEqual(first, other) {
+		t.Errorf("another seed should give other delays, got %v twice", first)
+	}
+	if reflect.DeepEqual(first["https://example.com/0"], first["https://example.com/1"]) {
+		t.Errorf("requests should have delays of their own, got %v", first)
+	}
+}
+
+func TestSeededJitterTargets(t *testing.T) {
+	body := []byte(`{"objects":["https://example.com/"]}`)
+	first := func(config Config) int64 {
+		config.seed, config.randPerRequest = 42, true
+		return config.requestRand(body).Int63n(1 << 62)
+	}
+	base := Config{section: "prod", method: "invalidate", network: "staging"}
+	if first(base) != first(base) {
+		t.Error("the same request should get the same source every run")
+	}
+	// The same body sent to several targets, or twice by one, shouldn't retry in sync
+	others := map[string]Config{
+		"section": {section: "stage", method: "invalidate", network: "staging"},
+		"method":  {section: "prod", method: "delete", network: "staging"},
+		"network": {section: "prod", method: "invalidate", network: "production"},
+		"chunk":   {section: "prod", method: "invalidate", network: "staging", chunkIndex: 2},
+	}
+	for name, config := range others {
+		if first(config) == first(base) {
+			t.Errorf("another %s should get another source", name)
+		}
+	}
+
+	config := base
+	config.chunkSeq = new(int64)
+	if a, b := config.withChunkIndex(), config.withChunkIndex(); a.chunkIndex != 1 || b.chunkIndex != 2 {
+		t.Errorf("chunks should be numbered in order of submission, got %d and %d", a.chunkIndex, b.chunkIndex)
+	}
+}
+
+func TestBackoffHighCounts(t *testing.T) {
+	// A shift of baseDuration by 31 or more would overflow into negative or zero delays
+	counts := []int{0, 10, 30, 62}
+	for _, max := range []time.Duration{0, time.Hour} {
+		for _, jitter := range []string{"none", "full", "equal", "decorrelated"} {
+			config := Config{jitter: jitter, maxDelay: max, rand: fixedRand{}}
+			ceiling := maxBackoff
+			if max > 0 {
+				ceiling = max
+			}
+			var prev time.Duration
+			for _, count := range counts {
+				d := config.nextDelay(count, prev, config.randOrDefault())
+				if d <= 0 || d > ceiling {
+					t.Errorf("%s max %s count %d: %s is out of (0, %s]", jitter, max, count, d, ceiling)
+				}
+				if d < prev {
+					t.Errorf("%s max %s count %d: %s is shorter than %s of the count before", jitter, max, count, d, prev)
+				}
+				prev = d
+			}
+		}
+	}
+	if d := exponential(62, 0); d != maxBackoff {
+		t.Errorf("expected %s at count 62, got %s", maxBackoff, d)
+	}
+	if d := exponential(10, 0); d != baseDuration<<10 {
+		t.Errorf("expected %s at count 10, got %s", baseDuration<<10, d)
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Body is a request body given as JSON input. "hostname" and "objects" are serialized first in this order,
+// then other top-level fields are forwarded as they are, sorted by key. So the same input always results
+// in byte-identical request bodies
+type Body struct {
+	Hostname string
+	// Objects are JSON strings (URLs, tags) or numbers (CP codes), which may be mixed in a body
+	Objects []json.RawMessage
+	Extra   map[string]json.RawMessage
+}
+
+// parseBody builds a Body from decoded top-level fields, checking "objects" is a non-empty array of
+// strings or CP code numbers
+func parseBody(fields map[string]json.RawMessage) (Body, error) {
+	var body Body
+	raw, ok := fields["objects"]
+	if !ok {
+		return body, errors.New("body does not have \"objects\" field")
+	}
+	var objects []interface{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&objects); err != nil {
+		return body, fmt.Errorf("\"objects\" should be an array, but got %s", raw)
+	}
+	if len(objects) == 0 {
+		return body, errors.New("\"objects\" is empty")
+	}
+	for i, object := range objects {
+		var o []byte
+		switch object := object.(type) {
+		case string:
+			o, _ = json.Marshal(object)
+		case json.Number:
+			if err := validateObject("cpcode", object.String()); err != nil {
+				return body, fmt.Errorf("\"objects\"[%d] should be a string or a CP code, but got %v", i, object)
+			}
+			o = []byte(object)
+		default:
+			return body, fmt.Errorf("\"objects\"[%d] should be a string or a CP code, but got %v", i, object)
+		}
+		body.Objects = append(body.Objects, o)
+	}
+
+	if raw, ok := fields["hostname"]; ok {
+		if err := json.Unmarshal(raw, &body.Hostname); err != nil {
+			return body, fmt.Errorf("\"hostname\" should be a string, but got %s", raw)
+		}
+	}
+
+	for k, v := range fields {
+		if k == "objects" || k == "hostname" {
+			continue
+		}
+		if body.Extra == nil {
+			body.Extra = map[string]json.RawMessage{}
+		}
+		body.Extra[k] = v
+	}
+	return body, nil
+}
+
+// sortObjects sorts objects by their JSON encodings, which keeps CP code numbers apart from strings
+func (body Body) sortObjects() {
+	sort.Slice(body.Objects, func(i, j int) bool {
+		return bytes.Compare(body.Objects[i], body.Objects[j]) < 0
+	})
+}
+
+// MarshalJSON serializes fields in a stable order, see Body
+func (body Body) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	if len(body.Hostname) > 0 {
+		hostname, err := json.Marshal(body.Hostname)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString(`"hostname":`)
+		buf.Write(hostname)
+		buf.WriteByte(',')
+	}
+	objects := body.Objects
+	if objects == nil {
+		objects = []json.RawMessage{}
+	}
+	o, err := json.Marshal(objects)
+	if err != nil {
+		return nil, err
+	}
+	buf.WriteString(`"objects":`)
+	buf.Write(o)
+
+	keys := make([]string, 0, len(body.Extra))
+	for k := range body.Extra {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		key, err := json.Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteByte(',')
+		buf.Write(key)
+		buf.WriteByte(':')
+		// Compact so that formatting of the input doesn't leak into request bodies
+		if err := json.Compact(&buf, body.Extra[k]); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// splitBody marshals a request body, splitting its "objects" into several bodies when it exceeds limit bytes.
+// Other top-level fields are copied to every split body
+func splitBody(body Body, limit int) ([][]byte, error) {
+	whole, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	if len(whole) <= limit {
+		return [][]byte{whole}, nil
+	}
+
+	chunkBody := body
+	chunkBody.Objects = nil
+	empty, err := json.Marshal(chunkBody)
+	if err != nil {
+		return nil, err
+	}
+
+	var bodies [][]byte
+	size := len(empty)
+	flush := func() error {
+		b, err := json.Marshal(chunkBody)
+		if err != nil {
+			return err
+		}
+		bodies = append(bodies, b)
+		chunkBody.Objects, size = nil, len(empty)
+		return nil
+	}
+	for _, object := range body.Objects {
+		o := []byte(object)
+		// Every object but the first one in a chunk needs a comma
+		objectSize := len(o)
+		if len(chunkBody.Objects) > 0 {
+			objectSize++
+		}
+		if size+objectSize > limit && len(chunkBody.Objects) > 0 {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			objectSize = len(o)
+		}
+		if size+objectSize > limit {
+			return nil, fmt.Errorf("object %s doesn't fit in %d bytes by itself", o, limit)
+		}
+		chunkBody.Objects = append(chunkBody.Objects, object)
+		size += objectSize
+	}
+	if len(chunkBody.Objects) > 0 {
+		if err := flush(); err != nil {
+			return nil, err
+		}
+	}
+	return bodies, nil
+}
+
+// bodyMerger merges objects of consecutive bodies of -flatten-json into bodies of up to limit bytes and
+// maxObjects objects. Only bodies of the same hostname and other fields are merged, a body differing in
+// them starts a new one
+type bodyMerger struct {
+	limit      int
+	maxObjects int
+	pending    Body
+	key        []byte // pending marshaled without objects, nil when nothing is pending
+	size       int    // of pending marshaled
+}
+
+func newBodyMerger(limit, maxObjects int) *bodyMerger {
+	return &bodyMerger{limit: limit, maxObjects: maxObjects}
+}
+
+// add merges objects of body, returning merged bodies which got full or can't take body
+func (m *bodyMerger) add(body Body) ([][]byte, error) {
+	empty := body
+	empty.Objects = nil
+	key, err := json.Marshal(empty)
+	if err != nil {
+		return nil, err
+	}
+	var full [][]byte
+	take := func() error {
+		b, err := m.flush()
+		if err == nil && b != nil {
+			full = append(full, b)
+		}
+		return err
+	}
+	if m.key != nil && !bytes.Equal(m.key, key) {
+		if err := take(); err != nil {
+			return nil, err
+		}
+	}
+	for _, object := range body.Objects {
+		if m.key == nil {
+			m.pending, m.key, m.size = empty, key, len(key)
+		}
+		// Every object but the first one needs a comma
+		objectSize := len(object)
+		if len(m.pending.Objects) > 0 {
+			objectSize++
+		}
+		if len(m.pending.Objects) > 0 && (len(m.pending.Objects) >= m.maxObjects || m.size+objectSize > m.limit) {
+			if err := take(); err != nil {
+				return nil, err
+			}
+			m.pending, m.key, m.size = empty, key, len(key)
+			objectSize = len(object)
+		}
+		if m.size+objectSize > m.limit {
+			return nil, fmt.Errorf("object %s doesn't fit in %d bytes by itself", object, m.limit)
+		}
+		m.pending.Objects = append(m.pending.Objects, object)
+		m.size += objectSize
+	}
+	return full, nil
+}
+
+// flush returns the pending merged body, nil when there is none
+func (m *bodyMerger) flush() ([]byte, error) {
+	if m == nil || m.key == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(m.pending)
+	m.pending, m.key, m.size = Body{}, nil, 0
+	return b, err
+}
+
+// halveBody splits a request body into two with halves of its objects, keeping other top-level fields.
+// It returns nil for a body which can't be split, e.g. of a single object
+func halveBody(data []byte) [][]byte {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil
+	}
+	body, err := parseBody(fields)
+	if err != nil || len(body.Objects) < 2 {
+		return nil
+	}
+	objects := body.Objects
+	halves := make([][]byte, 2)
+	for i, part := range [][]json.RawMessage{objects[:len(objects)/2], objects[len(objects)/2:]} {
+		body.Objects = part
+		if halves[i], err = json.Marshal(body); err != nil {
+			return nil
+		}
+	}
+	return halves
+}
+
+// bodyDecoder reads bodies of JSON input, either concatenated objects or a top-level array of them
+type bodyDecoder struct {
+	dec     *json.Decoder
+	array   bool
+	started bool // the opening [ is read
+}
+
+// newBodyDecoder reads bodies from r as -input-format says, detecting an array by a leading [ for auto
+func newBodyDecoder(r io.Reader, format string) *bodyDecoder {
+	br := bufio.NewReader(r)
+	array := format == "jsonarray"
+	if format == "" || format == defaultInputFormat {
+		array = firstNonSpace(br) == '['
+	}
+	return &bodyDecoder{dec: json.NewDecoder(br), array: array}
+}
+
+// next returns the top-level fields of the next body, or io.EOF after the last one
+func (d *bodyDecoder) next() (fields map[string]json.RawMessage, err error) {
+	if d.array {
+		if !d.started {
+			tok, err := d.dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+				return nil, fmt.Errorf("expected a JSON array of bodies, got %v", tok)
+			}
+			d.started = true
+		}
+		if !d.dec.More() {
+			// Consume the closing ], nothing may follow it
+			if _, err := d.dec.Token(); err != nil {
+				return nil, err
+			}
+			if _, err := d.dec.Token(); err != io.EOF {
+				return nil, errors.New("unexpected data after the JSON array of bodies")
+			}
+			return nil, io.EOF
+		}
+	}
+	err = d.dec.Decode(&fields)
+	return fields, err
+}
+
+// offset returns the input offset of the decoder, to locate errors
+func (d *bodyDecoder) offset() int64 {
+	return d.dec.InputOffset()
+}
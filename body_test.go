@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestBodyMarshalDeterministic(t *testing.T) {
+	inputs := []string{
+		`{"objects":["http://example.com/a"],"hostname":"example.com","zeta":1,"alpha":{"b": 2, "a": 1}}`,
+		`{"alpha":{"b":2,"a":1},"zeta":1,"hostname":"example.com","objects":["http://example.com/a"]}`,
+		`{
+  "zeta": 1,
+  "objects": ["http://example.com/a"],
+  "alpha": {"b": 2, "a": 1},
+  "hostname": "example.com"
+}`,
+	}
+	want := `{"hostname":"example.com","objects":["http://example.com/a"],"alpha":{"b":2,"a":1},"zeta":1}`
+
+	for _, input := range inputs {
+		// Run several times since map iteration order is random
+		for i := 0; i < 10; i++ {
+			var fields map[string]json.RawMessage
+			if err := json.Unmarshal([]byte(input), &fields); err != nil {
+				t.Fatalf("%s", err)
+			}
+			body, err := parseBody(fields)
+			if err != nil {
+				t.Fatalf("%s", err)
+			}
+			got, err := json.Marshal(body)
+			if err != nil {
+				t.Fatalf("%s", err)
+			}
+			if string(got) != want {
+				t.Fatalf("expected %s, got %s", want, got)
+			}
+		}
+	}
+}
+
+func TestInvalidateByBodiesForwardsExtraFields(t *testing.T) {
+	ts, rec := newTestServer(http.StatusCreated)
+	defer ts.Close()
+
+	input := `{"objects":["http://example.com/a"],"custom":{"key":"value"},"flag":true}`
+	config := newTestConfig(ts)
+	var wg sync.WaitGroup
+	if err := InvalidateByBodies(context.Background(), config, strings.NewReader(input), &wg); err != nil {
+		t.Fatalf("%s", err)
+	}
+	wg.Wait()
+
+	want := `{"objects":["http://example.com/a"],"custom":{"key":"value"},"flag":true}`
+	if got := rec.joinedBodies(); got != want {
+		t.Errorf("expected extra fields to be forwarded as %s, got %s", want, got)
+	}
+}
+
+func TestInvalidateByBodiesInputFormats(t *testing.T) {
+	concatenated := `{"objects":["http://example.com/a"]}
+{"objects":["http://example.com/b"],"hostname":"example.com"}`
+	array := ` [{"objects":["http://example.com/a"]},
+ {"objects":["http://example.com/b"],"hostname":"example.com"}]
+`
+	tests := []struct {
+		format, input string
+	}{
+		{"ndjson", concatenated},
+		{"auto", concatenated},
+		{"jsonarray", array},
+		{"auto", array},
+	}
+	var want string
+	for _, tt := range tests {
+		ts, rec := newTestServer(http.StatusCreated)
+		config := newTestConfig(ts)
+		config.inputFormat = tt.format
+		var wg sync.WaitGroup
+		if err := InvalidateByBodies(context.Background(), config, strings.NewReader(tt.input), &wg); err != nil {
+			t.Fatalf("%s: %s", tt.format, err)
+		}
+		wg.Wait()
+		ts.Close()
+
+		// Requests are sent concurrently, compare them in order
+		bodies := strings.Split(rec.joinedBodies(), "\n")
+		sort.Strings(bodies)
+		got := strings.Join(bodies, "\n")
+		if len(want) == 0 {
+			want = got
+		}
+		if len(bodies) != 2 || got != want {
+			t.Errorf("%s: expected the same requests for both layouts, got %s", tt.format, got)
+		}
+	}
+
+	for _, input := range []string{`[{"objects":["http://example.com/a"]}`, `[{"objects":["http://example.com/a"]}] {}`, `{"objects":["http://example.com/a"]}`} {
+		ts, _ := newTestServer(http.StatusCreated)
+		config := newTestConfig(ts)
+		config.inputFormat = "jsonarray"
+		var wg sync.WaitGroup
+		if err := InvalidateByBodies(context.Background(), config, strings.NewReader(input), &wg); err == nil {
+			t.Errorf("%s: expected an error as a JSON array of bodies", input)
+		}
+		wg.Wait()
+		ts.Close()
+	}
+}
+
+func TestInvalidateByBodiesMixedObjects(t *testing.T) {
+	ts, rec := newTestServer(http.StatusCreated)
+	defer ts.Close()
+
+	// URLs and CP codes can share a body, even under -strict
+	input := `{"objects":["https://example.com/a&b",12345,"https://example.com/c"]}`
+	config := newTestConfig(ts)
+	config.strict = true
+	config.tally = &Results{}
+	var wg sync.WaitGroup
+	if err := InvalidateByBodies(context.Background(), config, strings.NewReader(input), &wg); err != nil {
+		t.Fatalf("%s", err)
+	}
+	wg.Wait()
+	want := `{"objects":["https://example.com/a\u0026b",12345,"https://example.com/c"]}`
+	if got := rec.joinedBodies(); got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+	if summary := config.tally.Summary(); summary.PurgedObjects != 3 {
+		t.Errorf("expected every object to be counted: %s", summary)
+	}
+
+	// Split bodies keep CP codes as numbers
+	var fields map[string]json.RawMessage
+	json.Unmarshal([]byte(input), &fields)
+	body, err := parseBody(fields)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	bodies, err := splitBody(body, 45)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if got := string(bytes.Join(bodies, []byte("\n"))); got != `{"objects":["https://example.com/a\u0026b"]}
+{"objects":[12345,"https://example.com/c"]}` {
+		t.Errorf("unexpected split bodies: %s", got)
+	}
+
+	for _, input := range []string{`{"objects":[true]}`, `{"objects":[1.5]}`, `{"objects":[-1]}`, `{"objects":[{"url":"https://example.com/"}]}`} {
+		var fields map[string]json.RawMessage
+		json.Unmarshal([]byte(input), &fields)
+		if _, err := parseBody(fields); err == nil {
+			t.Errorf("%s: objects other than strings and CP codes should be rejected", input)
+		}
+	}
+}
+
+func TestInvalidationAutoSplit(t *testing.T) {
+	// Bodies of more than 2 objects are "too large"
+	var mu sync.Mutex
+	var accepted []string
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		if len(bodyObjects(body)) > 2 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"httpStatus":400,"title":"Bad request","detail":"Request body too large","supportId":"` + testSupportID + `"}`))
+			return
+		}
+		mu.Lock()
+		accepted = append(accepted, string(body))
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+
+	in := "https://example.com/a\nhttps://example.com/b\nhttps://example.com/c\nhttps://example.com/d\n"
+	config := newTestConfig(ts)
+	config.tally = &Results{}
+	if err := Invalidation(context.Background(), config, strings.NewReader(in)); err != nil {
+		t.Fatal(err)
+	}
+	if summary := config.tally.Summary(); summary.Failed != 1 {
+		t.Errorf("without -auto-split the body should fail: %s", summary)
+	}
+
+	config.autoSplit = true
+	config.tally = &Results{}
+	if err := Invalidation(context.Background(), config, strings.NewReader(in)); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(accepted)
+	want := []string{`{"objects":["https://example.com/a","https://example.com/b"]}`, `{"objects":["https://example.com/c","https://example.com/d"]}`}
+	if !reflect.DeepEqual(accepted, want) {
+		t.Errorf("expected the body to be sent in halves %q, got %q", want, accepted)
+	}
+	if summary := config.tally.Summary(); summary.Requests != 2 || summary.Failed != 0 || summary.PurgedObjects != 4 {
+		t.Errorf("the halves should replace the rejected request in the summary: %s", summary)
+	}
+
+	// Halving stops at the bound, a body still too large fails
+	accepted = nil
+	config.tally = &Results{}
+	config.splits = maxAutoSplits
+	if err := Invalidation(context.Background(), config, strings.NewReader(in)); err != nil {
+		t.Fatal(err)
+	}
+	if summary := config.tally.Summary(); summary.Failed != 1 || len(accepted) != 0 {
+		t.Errorf("a body should not be halved beyond the bound: %s", summary)
+	}
+}
+
+func TestInvalidateByBodiesFlatten(t *testing.T) {
+	ts, rec := newTestServer(http.StatusCreated)
+	defer ts.Close()
+
+	var input bytes.Buffer
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&input, "{\"objects\":[\"https://example.com/%d\"]}\n", i)
+	}
+	config := newTestConfig(ts)
+	config.fileType = "json"
+	config.flattenJSON = true
+	if err := Invalidation(context.Background(), config, &input); err != nil {
+		t.Fatal(err)
+	}
+
+	// 1000 objects of up to 27 bytes each fit in 50000 bytes, so the count limit splits them
+	if n := rec.count(); n != 10 {
+		t.Errorf("expected 10000 one-object bodies merged into 10 requests, got %d", n)
+	}
+	objects := 0
+	for _, body := range rec.bodies {
+		if len(body) > defaultMaxBodySize || countObjects(body) > defaultMaxObjects {
+			t.Errorf("a merged body is over the limits: %d bytes, %d objects", len(body), countObjects(body))
+		}
+		objects += countObjects(body)
+	}
+	if objects != 10000 {
+		t.Errorf("expected 10000 objects in total, got %d", objects)
+	}
+}
+
+func TestBodyMergerCompatible(t *testing.T) {
+	bodies := []string{
+		`{"objects":["https://example.com/a"]}`,
+		`{"objects":["https://example.com/b"]}`,
+		`{"hostname":"example.com","objects":["/c"]}`,
+		`{"hostname":"example.com","objects":["/d","/e"]}`,
+		`{"objects":["https://example.com/f"]}`,
+	}
+	m := newBodyMerger(defaultMaxBodySize, 2)
+	var got []string
+	for _, s := range bodies {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(s), &fields); err != nil {
+			t.Fatal(err)
+		}
+		body, err := parseBody(fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		full, err := m.add(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, b := range full {
+			got = append(got, string(b))
+		}
+	}
+	last, err := m.flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = append(got, string(last))
+
+	want := []string{
+		`{"objects":["https://example.com/a","https://example.com/b"]}`,
+		`{"hostname":"example.com","objects":["/c","/d"]}`,
+		`{"hostname":"example.com","objects":["/e"]}`,
+		`{"objects":["https://example.com/f"]}`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected only bodies of the same fields merged\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// errCircuitOpen is the error of requests failed fast while the API looks down
+var errCircuitOpen = errors.New("circuit open")
+
+// breaker is a circuit breaker shared by all request goroutines. After threshold consecutive
+// failures it opens, failing requests fast instead of retrying them against an API that is down.
+// Once cooldown has passed, a single request is let through as a probe: its success closes the
+// breaker, its failure opens it for another cooldown. A nil *breaker never opens.
+type breaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int       // consecutive, across goroutines
+	openedAt  time.Time // zero while closed
+	probing   bool      // a half-open probe is in flight
+	now       func() time.Time
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// allow reports errCircuitOpen when a request should not be sent
+func (b *breaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openedAt.IsZero() {
+		return nil
+	}
+	if b.probing || b.now().Sub(b.openedAt) < b.cooldown {
+		return errCircuitOpen
+	}
+	b.probing = true
+	return nil
+}
+
+// succeed closes the breaker, the API answered
+func (b *breaker) succeed() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.openedAt = time.Time{}
+	b.probing = false
+}
+
+// fail counts a failure, opening the breaker at threshold or when a probe failed
+func (b *breaker) fail() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.probing || (b.openedAt.IsZero() && b.failures >= b.threshold) {
+		b.openedAt = b.now()
+	}
+	b.probing = false
+}
+
+// report counts the outcome of an attempt: connection errors and server errors mean the API is
+// failing, any other response including rejections and rate limiting means it is up
+func (b *breaker) report(statusCode int, err error) {
+	if err != nil || statusCode >= 500 && statusCode != http.StatusInsufficientStorage {
+		b.fail()
+		return
+	}
+	b.succeed()
+}
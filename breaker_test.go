@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Now()
+	b := newBreaker(3, time.Minute)
+	b.now = func() time.Time { return now }
+
+	// Any answer in between resets the count of consecutive failures
+	b.report(http.StatusServiceUnavailable, nil)
+	b.report(0, errors.New("connection refused"))
+	b.report(http.StatusBadRequest, nil)
+	b.report(http.StatusInternalServerError, nil)
+	b.report(http.StatusTooManyRequests, nil)
+	if err := b.allow(); err != nil {
+		t.Fatalf("the breaker should be closed before consecutive failures reach the threshold, got %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		b.report(http.StatusServiceUnavailable, nil)
+	}
+	if err := b.allow(); err != errCircuitOpen {
+		t.Fatalf("the breaker should open at the threshold, got %v", err)
+	}
+
+	// After the cooldown only one probe is let through
+	now = now.Add(time.Minute)
+	if err := b.allow(); err != nil {
+		t.Fatalf("a probe should be allowed after the cooldown, got %v", err)
+	}
+	if err := b.allow(); err != errCircuitOpen {
+		t.Errorf("only one probe should be in flight, got %v", err)
+	}
+	b.report(http.StatusBadGateway, nil)
+	if err := b.allow(); err != errCircuitOpen {
+		t.Errorf("a failed probe should open the breaker again, got %v", err)
+	}
+
+	now = now.Add(time.Minute)
+	if err := b.allow(); err != nil {
+		t.Fatalf("a probe should be allowed after the cooldown, got %v", err)
+	}
+	b.report(http.StatusCreated, nil)
+	if err := b.allow(); err != nil {
+		t.Errorf("a succeeded probe should close the breaker, got %v", err)
+	}
+
+	var disabled *breaker
+	disabled.report(http.StatusServiceUnavailable, nil)
+	if err := disabled.allow(); err != nil {
+		t.Errorf("a nil breaker should never open, got %v", err)
+	}
+}
+
+func TestInvalidationRequestCircuitOpen(t *testing.T) {
+	ts, rec := newTestServer(http.StatusServiceUnavailable)
+	defer ts.Close()
+
+	config := newTestConfig(ts)
+	config.maxDelay = time.Millisecond
+	config.breaker = newBreaker(3, time.Hour)
+	config.tally = &Results{}
+
+	// The first request opens the breaker on its third attempt instead of retrying on
+	sendTestRequest(config)
+	if n := rec.count(); n != 3 {
+		t.Errorf("expected requests to stop at the threshold, but %d were sent", n)
+	}
+
+	// Later requests fail fast without being sent
+	sendTestRequest(config)
+	sendTestRequest(config)
+	if n := rec.count(); n != 3 {
+		t.Errorf("requests should fail fast while the circuit is open, but %d were sent", n)
+	}
+	summary := config.tally.Summary()
+	if summary.Failed != 3 || summary.CircuitOpen != 3 {
+		t.Errorf("expected every request to fail by the open circuit: %s", summary)
+	}
+}
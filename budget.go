@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// objectBudget caps objects submitted by a run at -max-total-objects, for input whose size isn't known
+// up front like stdin. A nil *objectBudget is unlimited
+type objectBudget struct {
+	mu    sync.Mutex
+	limit int
+	used  int
+}
+
+func newObjectBudget(limit int) *objectBudget {
+	return &objectBudget{limit: limit}
+}
+
+// take reserves n objects to submit, failing without reserving any when they would exceed the limit
+func (b *objectBudget) take(n int) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.used+n > b.limit {
+		return fmt.Errorf("input exceeds -max-total-objects %d, stopped after submitting %d objects", b.limit, b.used)
+	}
+	b.used += n
+	return nil
+}
+
+// fresh returns an unused budget of the same limit, for another target purging the same input
+func (b *objectBudget) fresh() *objectBudget {
+	if b == nil {
+		return nil
+	}
+	return newObjectBudget(b.limit)
+}
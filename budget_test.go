@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestObjectBudget(t *testing.T) {
+	b := newObjectBudget(3)
+	if err := b.take(2); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := b.take(2); err == nil || !strings.Contains(err.Error(), "stopped after submitting 2 objects") {
+		t.Errorf("exceeding the limit should report objects submitted so far, got %v", err)
+	}
+	if err := b.take(1); err != nil {
+		t.Errorf("a failed take should not use the budget, got %v", err)
+	}
+
+	var unlimited *objectBudget
+	if err := unlimited.take(1 << 20); err != nil {
+		t.Errorf("a nil budget should be unlimited, got %v", err)
+	}
+}
+
+func TestInvalidateByURLsMaxTotalObjects(t *testing.T) {
+	ts, rec := newTestServer(http.StatusCreated)
+	defer ts.Close()
+
+	config := newTestConfig(ts)
+	config.maxObjects = 2
+	config.budget = newObjectBudget(3)
+	config.tally = &Results{}
+	input := strings.Repeat("https://example.com/a\n", 5)
+	var wg sync.WaitGroup
+	err := InvalidateByURLs(context.Background(), config, strings.NewReader(input), &wg)
+	wg.Wait()
+	if err == nil || !strings.Contains(err.Error(), "stopped after submitting 2 objects") {
+		t.Errorf("a stream crossing -max-total-objects should stop, got %v", err)
+	}
+	if rec.count() != 1 {
+		t.Errorf("only chunks within the limit should be submitted, got %d requests", rec.count())
+	}
+	if summary := config.tally.Summary(); summary.PurgedObjects != 2 || summary.UnsubmittedObjects == 0 {
+		t.Errorf("unexpected summary: %s", summary)
+	}
+}
+
+func TestRunMaxTotalObjects(t *testing.T) {
+	ts, rec := newTestServer(http.StatusCreated)
+	defer ts.Close()
+	setEdgegridEnv(t)
+	defer unsetEdgegridEnv()
+	os.Setenv("AKAMAI_HOST", strings.TrimPrefix(ts.URL, "https://"))
+	defer log.SetLevel(log.Level)
+
+	dir, err := ioutil.TempDir("", "purge-max-total")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "urls.txt")
+	if err := ioutil.WriteFile(path, []byte(strings.Repeat("https://example.com/a\n", 3)), 0644); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	// Files over the limit are refused before anything is sent
+	if got := exitCode(run([]string{"-insecure", "-max-total-objects", "2", path}, &bytes.Buffer{})); got != exitConfig {
+		t.Errorf("expected exit code %d, got %d", exitConfig, got)
+	}
+	if rec.count() != 0 {
+		t.Errorf("nothing should be submitted over -max-total-objects, got %d requests", rec.count())
+	}
+	if got := exitCode(run([]string{"-insecure", "-max-total-objects", "3", path}, &bytes.Buffer{})); got != exitOK {
+		t.Errorf("expected exit code %d within the limit, got %d", exitOK, got)
+	}
+}
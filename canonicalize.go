@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// canonicalizations are transformations -canonicalize can apply to URLs and paths, matching how a cache
+// key treats them
+var canonicalizations = []string{"strip-slash", "add-slash", "sort-query"}
+
+// canonicalization is a set of transformations given as a comma-separated flag value like
+// "strip-slash,sort-query"
+type canonicalization map[string]bool
+
+func (c canonicalization) String() string {
+	var names []string
+	for _, name := range canonicalizations {
+		if c[name] {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// Set replaces the set with the given transformations
+func (c *canonicalization) Set(value string) error {
+	set := canonicalization{}
+	for _, s := range strings.Split(value, ",") {
+		if s = strings.TrimSpace(s); len(s) == 0 {
+			continue
+		}
+		known := false
+		for _, name := range canonicalizations {
+			known = known || s == name
+		}
+		if !known {
+			return fmt.Errorf("%q is not one of %s", s, strings.Join(canonicalizations, ", "))
+		}
+		set[s] = true
+	}
+	if set["strip-slash"] && set["add-slash"] {
+		return fmt.Errorf("strip-slash and add-slash contradict each other")
+	}
+	*c = set
+	return nil
+}
+
+// apply canonicalizes an object of kind. CP codes and cache tags are left as they are. Percent-encoding
+// is kept, so that objects are submitted as the cache saw them
+func (c canonicalization) apply(kind, object string) string {
+	if len(c) == 0 || (kind != "url" && kind != "path") {
+		return object
+	}
+	rest, fragment := object, ""
+	if i := strings.IndexByte(rest, '#'); i >= 0 {
+		rest, fragment = rest[:i], rest[i:]
+	}
+	query, hasQuery := "", false
+	if i := strings.IndexByte(rest, '?'); i >= 0 {
+		rest, query, hasQuery = rest[:i], rest[i+1:], true
+	}
+	origin, p := "", rest
+	if kind == "url" {
+		if i := strings.Index(rest, "://"); i >= 0 {
+			end := len(rest)
+			if j := strings.IndexByte(rest[i+3:], '/'); j >= 0 {
+				end = i + 3 + j
+			}
+			origin, p = rest[:end], rest[end:]
+		}
+	}
+
+	switch {
+	case c["strip-slash"]:
+		// The root stays "/", which is the same object as no path at all
+		for len(p) > 1 && strings.HasSuffix(p, "/") {
+			p = p[:len(p)-1]
+		}
+	case c["add-slash"]:
+		// Files like /index.html are left alone, their last segment has an extension
+		if !strings.HasSuffix(p, "/") && len(path.Ext(p)) == 0 {
+			p += "/"
+		}
+	}
+	if c["sort-query"] && len(query) > 0 {
+		query = sortQuery(query)
+	}
+
+	s := origin + p
+	if hasQuery {
+		s += "?" + query
+	}
+	return s + fragment
+}
+
+// sortQuery sorts parameters of a raw query by name, keeping the order of repeated names as it
+// matters to some origins
+func sortQuery(query string) string {
+	params := strings.Split(query, "&")
+	name := func(param string) string {
+		if i := strings.IndexByte(param, '='); i >= 0 {
+			return param[:i]
+		}
+		return param
+	}
+	sort.SliceStable(params, func(i, j int) bool {
+		return name(params[i]) < name(params[j])
+	})
+	return strings.Join(params, "&")
+}
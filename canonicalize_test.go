@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCanonicalizationApply(t *testing.T) {
+	tests := []struct {
+		value, kind, object, want string
+	}{
+		{"sort-query", "url", "https://example.com/a?b=2&a=1&c", "https://example.com/a?a=1&b=2&c"},
+		{"sort-query", "url", "https://example.com/a?b=2&a=1&b=1#top", "https://example.com/a?a=1&b=2&b=1#top"},
+		{"sort-query", "url", "https://example.com/a?z=%2F&y", "https://example.com/a?y&z=%2F"},
+		{"strip-slash", "url", "https://example.com/a/", "https://example.com/a"},
+		{"strip-slash", "url", "https://example.com/a//?x=1", "https://example.com/a?x=1"},
+		{"strip-slash", "url", "https://example.com/", "https://example.com/"},
+		{"strip-slash", "path", "/a/", "/a"},
+		{"add-slash", "url", "https://example.com/a", "https://example.com/a/"},
+		{"add-slash", "url", "https://example.com/a/", "https://example.com/a/"},
+		{"add-slash", "url", "https://example.com/index.html", "https://example.com/index.html"},
+		{"add-slash", "url", "https://example.com", "https://example.com/"},
+		{"add-slash,sort-query", "url", "https://example.com/a?b&a", "https://example.com/a/?a&b"},
+		{"add-slash", "path", "/a?x=1", "/a/?x=1"},
+		{"strip-slash,sort-query", "cpcode", "12345", "12345"},
+		{"strip-slash", "tag", "tag/", "tag/"},
+		{"", "url", "https://example.com/a/?b&a", "https://example.com/a/?b&a"},
+	}
+	for _, tt := range tests {
+		var c canonicalization
+		if err := c.Set(tt.value); err != nil {
+			t.Fatalf("%q: %s", tt.value, err)
+		}
+		if got := c.apply(tt.kind, tt.object); got != tt.want {
+			t.Errorf("-canonicalize %q of %s %q: expected %q, got %q", tt.value, tt.kind, tt.object, tt.want, got)
+		}
+	}
+}
+
+func TestCanonicalizationSet(t *testing.T) {
+	var c canonicalization
+	for _, value := range []string{"strip-slash,add-slash", "lowercase"} {
+		if err := c.Set(value); err == nil {
+			t.Errorf("-canonicalize %q should be refused", value)
+		}
+	}
+	if err := c.Set("sort-query, strip-slash"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if got := c.String(); got != "strip-slash,sort-query" {
+		t.Errorf("expected strip-slash,sort-query, got %q", got)
+	}
+}
+
+func TestInvalidateByURLsCanonicalize(t *testing.T) {
+	ts, rec := newTestServer(http.StatusCreated)
+	defer ts.Close()
+
+	config := newTestConfig(ts)
+	if err := config.canonicalize.Set("strip-slash,sort-query"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	input := "https://example.com/a/?b=2&a=1\nhttps://example.com/\n"
+	var wg sync.WaitGroup
+	if err := InvalidateByURLs(context.Background(), config, strings.NewReader(input), &wg); err != nil {
+		t.Fatalf("%s", err)
+	}
+	wg.Wait()
+	want := `{"objects":["https://example.com/a?a=1\u0026b=2","https://example.com/"]}`
+	if got := rec.joinedBodies(); got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
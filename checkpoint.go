@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// checkpointFile is the -checkpoint file, chunks of the input completed so far
+type checkpointFile struct {
+	UpdatedAt time.Time `json:"updated_at"`
+	InputHash string    `json:"input_hash"`
+	// Completed are ranges of indexes of chunks, both ends included, like [[0,41],[43,99]]
+	Completed [][2]int `json:"completed"`
+}
+
+// checkpoint records chunks of a run accepted by Fast Purge into the -checkpoint file as they complete,
+// numbered in the order the input is chunked. With -resume, chunks completed by the interrupted run are
+// skipped, which needs the same input chunked alike, so the file is of a hash of both. A nil *checkpoint
+// records and skips nothing
+type checkpoint struct {
+	mu        sync.Mutex
+	path      string
+	hash      string
+	previous  map[int]bool // completed by the interrupted run
+	completed map[int]bool // completed or skipped by this run
+	next      int          // index of the next chunk
+	resumed   int          // objects of skipped chunks
+}
+
+// loadCheckpoint starts the -checkpoint file of an input of hash. With resume, chunks completed in the file
+// are skipped, unless it is missing, which is a first run. Without it, the file starts over
+func loadCheckpoint(path, hash string, resume bool, now time.Time) (*checkpoint, error) {
+	path, err := homedir.Expand(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &checkpoint{path: path, hash: hash, previous: map[int]bool{}, completed: map[int]bool{}}
+	if !resume {
+		return c, c.save(now)
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		log.Infof("no checkpoint in %s to resume from, purging everything", path)
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var last checkpointFile
+	if err := json.Unmarshal(data, &last); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if last.InputHash != hash {
+		return nil, fmt.Errorf("the input or how it is chunked changed since %s was written, give -resume with the same input and flags, or purge without it", path)
+	}
+	for _, r := range last.Completed {
+		for i := r[0]; i <= r[1]; i++ {
+			c.previous[i] = true
+		}
+	}
+	return c, nil
+}
+
+// take numbers the next chunk, of objects. It returns the progress of the chunk to send, or done when
+// the interrupted run completed it already
+func (c *checkpoint) take(objects int) (progress *chunkProgress, done bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	index := c.next
+	c.next++
+	if c.previous[index] {
+		// Kept in the file, so that another interruption doesn't lose it
+		c.completed[index] = true
+		c.resumed += objects
+		return nil, true
+	}
+	return &chunkProgress{checkpoint: c, index: index, pending: 1}, false
+}
+
+// complete records the chunk of index and writes the file, so that an interruption right after keeps it
+func (c *checkpoint) complete(index int, now time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.completed[index] = true
+	return c.saveLocked(now)
+}
+
+// save writes the file
+func (c *checkpoint) save(now time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.saveLocked(now)
+}
+
+func (c *checkpoint) saveLocked(now time.Time) error {
+	indexes := make([]int, 0, len(c.completed))
+	for i := range c.completed {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	state := checkpointFile{UpdatedAt: now, InputHash: c.hash, Completed: [][2]int{}}
+	for _, i := range indexes {
+		if n := len(state.Completed); n > 0 && state.Completed[n-1][1] == i-1 {
+			state.Completed[n-1][1] = i
+		} else {
+			state.Completed = append(state.Completed, [2]int{i, i})
+		}
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.path, append(data, '\n'))
+}
+
+// resumedObjects returns the number of objects of chunks skipped by -resume
+func (c *checkpoint) resumedObjects() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.resumed
+}
+
+// chunkProgress tracks requests of a chunk, several when -auto-split halves it, which completes once
+// all of them succeed. A nil *chunkProgress tracks nothing
+type chunkProgress struct {
+	checkpoint *checkpoint
+	index      int
+	pending    int32 // requests of the chunk not succeeded yet
+}
+
+// split replaces a request of the chunk with n requests
+func (p *chunkProgress) split(n int) {
+	if p != nil {
+		atomic.AddInt32(&p.pending, int32(n-1))
+	}
+}
+
+// succeeded records a request of the chunk accepted, completing the chunk with the last one
+func (p *chunkProgress) succeeded(now time.Time) {
+	if p == nil || atomic.AddInt32(&p.pending, -1) != 0 {
+		return
+	}
+	if err := p.checkpoint.complete(p.index, now); err != nil {
+		log.WithError(err).Warn("failed to write -checkpoint")
+	}
+}
+
+// withChunk returns config sending requests of the chunk of progress
+func (config *Config) withChunk(progress *chunkProgress) *Config {
+	if progress == nil {
+		return config
+	}
+	chunked := *config
+	chunked.chunk = progress
+	return &chunked
+}
+
+// hashInput returns a hash of input files and objects given by flags, and of flags deciding how they are
+// chunked, so that -resume can tell chunks are numbered alike
+func hashInput(ctx context.Context, config *Config, paths []string) (string, error) {
+	h := sha256.New()
+	template, _ := Body{Extra: config.bodyTemplate}.MarshalJSON()
+	fmt.Fprintf(h, "%s %s %s %s %s %s %d %d %t %t %t %s %s %s %s %s %s %t %d %t %s %t %t\n", config.method, config.network,
+		config.section, config.fileType, config.objectTypeOrDefault(), config.hostname, config.bodySizeLimit(),
+		config.objectLimit(), config.groupByHost, config.sort, config.flattenJSON, config.encode,
+		config.canonicalize.String(), config.replaceHosts.String(), config.inputFormat, config.jsonPointer, template,
+		config.expand, config.maxLineBytes, config.normalize, config.csvColumn, config.csvHeader, config.dedupe)
+	for _, object := range config.objects {
+		fmt.Fprintf(h, "%s\n", object)
+	}
+	for _, p := range paths {
+		fp, err := openInput(ctx, config, p)
+		if err != nil {
+			return "", err
+		}
+		n, err := io.Copy(h, fp)
+		fp.Close()
+		// Lengths keep files apart
+		fmt.Fprintf(h, "\n%d\n", n)
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckpointResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "purge-checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoint.json")
+	in := "http://example.com/1\nhttp://example.com/2\nhttp://example.com/3\nhttp://example.com/4\nhttp://example.com/5\n"
+
+	// The run is interrupted after two chunks, the others fail
+	ts, rec := newTestServer(http.StatusCreated, http.StatusCreated, http.StatusForbidden, http.StatusForbidden, http.StatusForbidden)
+	config := newTestConfig(ts)
+	config.maxObjects = 1
+	if config.checkpoint, err = loadCheckpoint(path, "hash", false, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	Invalidation(context.Background(), config, strings.NewReader(in))
+	ts.Close()
+	purged := []string{string(rec.bodies[0]), string(rec.bodies[1])}
+
+	ts, rec = newTestServer(http.StatusCreated)
+	defer ts.Close()
+	config = newTestConfig(ts)
+	config.maxObjects = 1
+	if config.checkpoint, err = loadCheckpoint(path, "hash", true, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := Invalidation(context.Background(), config, strings.NewReader(in)); err != nil {
+		t.Fatal(err)
+	}
+	if n := rec.count(); n != 3 {
+		t.Fatalf("a resumed run should send the 3 chunks left, got %d requests", n)
+	}
+	if n := config.checkpoint.resumedObjects(); n != 2 {
+		t.Errorf("expected 2 resumed objects, got %d", n)
+	}
+	bodies := purged
+	for _, body := range rec.bodies {
+		bodies = append(bodies, string(body))
+	}
+	sort.Strings(bodies)
+	var want []string
+	for _, object := range strings.Fields(in) {
+		want = append(want, `{"objects":["`+object+`"]}`)
+	}
+	if !reflect.DeepEqual(bodies, want) {
+		t.Errorf("the runs together should purge every chunk once, expected %q, got %q", want, bodies)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file checkpointFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(file.Completed, [][2]int{{0, 4}}) {
+		t.Errorf("expected every chunk completed, got %v", file.Completed)
+	}
+}
+
+func TestLoadCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "purge-checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoint.json")
+
+	// Resuming without a checkpoint purges everything
+	c, err := loadCheckpoint(path, "hash", true, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range []int{0, 1, 3} {
+		c.complete(i, time.Now())
+	}
+	data, _ := ioutil.ReadFile(path)
+	if want := `"completed":[[0,1],[3,3]]`; !strings.Contains(string(data), want) {
+		t.Errorf("expected completed chunks as ranges %s, got %s", want, data)
+	}
+
+	if _, err := loadCheckpoint(path, "other", true, time.Now()); err == nil {
+		t.Error("a checkpoint of another input should be rejected")
+	}
+	c, err = loadCheckpoint(path, "hash", true, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var done []bool
+	for i := 0; i < 5; i++ {
+		_, skipped := c.take(1)
+		done = append(done, skipped)
+	}
+	if want := []bool{true, true, false, true, false}; !reflect.DeepEqual(done, want) {
+		t.Errorf("expected chunks skipped as %v, got %v", want, done)
+	}
+
+	// Without -resume, the checkpoint starts over
+	if _, err := loadCheckpoint(path, "hash", false, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	c, _ = loadCheckpoint(path, "hash", true, time.Now())
+	if _, skipped := c.take(1); skipped {
+		t.Error("a checkpoint started over shouldn't skip anything")
+	}
+}
+
+func TestChunkProgressSplit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "purge-checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c, err := loadCheckpoint(filepath.Join(dir, "checkpoint.json"), "hash", false, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	progress, _ := c.take(2)
+	progress.split(2)
+	progress.succeeded(time.Now())
+	if c.completed[progress.index] {
+		t.Error("a chunk shouldn't complete before both of its halves succeed")
+	}
+	progress.succeeded(time.Now())
+	if !c.completed[progress.index] {
+		t.Error("a chunk should complete once both of its halves succeed")
+	}
+}
+
+func TestHashInput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "purge-checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "list.txt")
+	hash := func(content string, maxObjects int) string {
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		h, err := hashInput(context.Background(), &Config{fileType: "text", maxObjects: maxObjects}, []string{path})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	base := hash("http://example.com/a\n", 0)
+	if hash("http://example.com/a\n", 0) != base {
+		t.Error("the same input should hash alike")
+	}
+	if hash("http://example.com/b\n", 0) == base {
+		t.Error("another input should hash differently")
+	}
+	if hash("http://example.com/a\n", 1) == base {
+		t.Error("the input chunked differently should hash differently")
+	}
+}
+
+func TestHashInputResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "purge-checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "list.txt")
+	if err := ioutil.WriteFile(path, []byte("http://example.com/{1..3}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	checkpointPath := filepath.Join(dir, "checkpoint.json")
+	hash := func(config *Config) string {
+		h, err := hashInput(context.Background(), config, []string{path})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+
+	c, err := loadCheckpoint(checkpointPath, hash(&Config{fileType: "text"}), false, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.complete(0, time.Now())
+
+	// Flags changing the objects read from the same files number chunks differently
+	for name, config := range map[string]*Config{
+		"-expand":         {fileType: "text", expand: true},
+		"-max-line-bytes": {fileType: "text", maxLineBytes: 8},
+		"-normalize":      {fileType: "text", normalize: true},
+		"-dedupe":         {fileType: "text", dedupe: true},
+	} {
+		if _, err := loadCheckpoint(checkpointPath, hash(config), true, time.Now()); err == nil {
+			t.Errorf("%s: resuming a checkpoint of the input read differently should fail", name)
+		}
+	}
+	if _, err := loadCheckpoint(checkpointPath, hash(&Config{fileType: "text"}), true, time.Now()); err != nil {
+		t.Errorf("resuming the same input should succeed, got %s", err)
+	}
+}
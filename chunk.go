@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// chunker groups objects into request bodies under a body size and an object count limit, whichever
+// is hit first. Sizes are of objects marshaled as JSON strings, so that escapes count.
+// reference: https://developer.akamai.com/api/purge/ccu/overview.html#limits
+type chunker struct {
+	maxBody    int
+	maxObjects int
+	overHead   int // of the body around objects
+	objects    []string
+	size       int
+}
+
+func newChunker(maxBody, maxObjects, overHead int) *chunker {
+	return &chunker{maxBody: maxBody, maxObjects: maxObjects, overHead: overHead, size: overHead}
+}
+
+// full reports whether object doesn't fit into the current chunk, which should be taken first. byCount
+// tells the count limit is hit while the object would fit in size. An object too large by itself fits
+// into an empty chunk, it is sent alone then
+func (c *chunker) full(object string) (full, byCount bool) {
+	if len(c.objects) == 0 {
+		return false, false
+	}
+	// Objects but the first one need a comma
+	tooLarge := c.size+jsonStringLen(object)+len(",") > c.maxBody
+	byCount = len(c.objects) >= c.maxObjects
+	return byCount || tooLarge, byCount && !tooLarge
+}
+
+// add appends object to the current chunk
+func (c *chunker) add(object string) {
+	size := jsonStringLen(object)
+	if len(c.objects) > 0 {
+		size += len(",")
+	}
+	c.objects = append(c.objects, object)
+	c.size += size
+}
+
+// reset empties the current chunk, reusing its slice
+func (c *chunker) reset() {
+	c.objects, c.size = c.objects[:0], c.overHead
+}
+
+// hostChunkers keeps a chunker per host of URLs for -group-by-host, so that objects of a request share
+// a host however they are interleaved in input. Each host holds one chunk at most, which is taken when
+// full. Without grouping, every object goes to the same chunker
+type hostChunkers struct {
+	newChunker func() *chunker
+	group      bool
+	byHost     map[string]*chunker
+	hosts      []string // in the order seen first
+}
+
+func newHostChunkers(group bool, newChunker func() *chunker) *hostChunkers {
+	return &hostChunkers{newChunker: newChunker, group: group, byHost: map[string]*chunker{}}
+}
+
+// of returns the chunker object goes to
+func (h *hostChunkers) of(object string) *chunker {
+	host := ""
+	if h.group {
+		if start, end, ok := urlHostSpan(object); ok {
+			host = strings.ToLower(object[start:end])
+		}
+	}
+	c, ok := h.byHost[host]
+	if !ok {
+		c = h.newChunker()
+		h.byHost[host] = c
+		h.hosts = append(h.hosts, host)
+	}
+	return c
+}
+
+// pending returns chunkers with objects left, in the order their hosts were seen first
+func (h *hostChunkers) pending() []*chunker {
+	var chunkers []*chunker
+	for _, host := range h.hosts {
+		if c := h.byHost[host]; len(c.objects) > 0 {
+			chunkers = append(chunkers, c)
+		}
+	}
+	return chunkers
+}
+
+// Chunk groups objects into request bodies of at most maxBodyBytes bytes like {"objects":[...]} and
+// maxObjects objects, in order. An object too large for a body by itself gets one of its own
+func Chunk(objects []string, maxBodyBytes, maxObjects int) ([][]string, error) {
+	if maxBodyBytes <= jsonOverHead {
+		return nil, fmt.Errorf("a max body size of %d bytes can't fit any object", maxBodyBytes)
+	}
+	if maxObjects < 1 {
+		return nil, fmt.Errorf("a max number of %d objects can't fit any object", maxObjects)
+	}
+	var chunks [][]string
+	c := newChunker(maxBodyBytes, maxObjects, jsonOverHead)
+	for _, object := range objects {
+		if full, _ := c.full(object); full {
+			chunks = append(chunks, append([]string(nil), c.objects...))
+			c.reset()
+		}
+		c.add(object)
+	}
+	if len(c.objects) > 0 {
+		chunks = append(chunks, c.objects)
+	}
+	return chunks, nil
+}
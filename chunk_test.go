@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestChunk(t *testing.T) {
+	long := strings.Repeat("a", 20)
+	tests := []struct {
+		name       string
+		objects    []string
+		maxBody    int
+		maxObjects int
+		want       [][]string
+	}{
+		// {"objects":["aaaa","bbbb"]} is 27 bytes
+		{"exact fit", []string{"aaaa", "bbbb"}, 27, 10, [][]string{{"aaaa", "bbbb"}}},
+		{"one byte over", []string{"aaaa", "bbbb"}, 26, 10, [][]string{{"aaaa"}, {"bbbb"}}},
+		{"exact count", []string{"a", "b", "c"}, 1000, 3, [][]string{{"a", "b", "c"}}},
+		{"one over the count", []string{"a", "b", "c", "d"}, 1000, 3, [][]string{{"a", "b", "c"}, {"d"}}},
+		{"single oversized object", []string{"a", long, "b"}, 30, 10, [][]string{{"a"}, {long}, {"b"}}},
+		{"escapes count", []string{`a"b`, `c"d`}, 26, 10, [][]string{{`a"b`}, {`c"d`}}},
+		{"escapes fit", []string{`a"b`, `c"d`}, 27, 10, [][]string{{`a"b`, `c"d`}}},
+		{"no objects", nil, 100, 10, nil},
+	}
+	for _, tt := range tests {
+		got, err := Chunk(tt.objects, tt.maxBody, tt.maxObjects)
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+
+	for _, limits := range [][2]int{{len(`{"objects":[]}`), 10}, {100, 0}} {
+		if _, err := Chunk([]string{"a"}, limits[0], limits[1]); err == nil {
+			t.Errorf("limits %v should be refused", limits)
+		}
+	}
+}
+
+func TestInvalidateByURLsGroupByHost(t *testing.T) {
+	in := "https://a.example.com/1\nhttps://b.example.com/1\nhttps://A.example.com/2\nhttps://c.example.com/1\n" +
+		"https://b.example.com/2\nhttps://a.example.com/3\n"
+	for _, group := range []bool{false, true} {
+		ts, rec := newTestServer(http.StatusCreated)
+		config := newTestConfig(ts)
+		config.maxObjects = 2
+		config.groupByHost = group
+		if err := Invalidation(context.Background(), config, strings.NewReader(in)); err != nil {
+			t.Fatal(err)
+		}
+		ts.Close()
+
+		var chunks []string
+		for _, body := range rec.bodies {
+			var b struct{ Objects []string }
+			if err := json.Unmarshal(body, &b); err != nil {
+				t.Fatal(err)
+			}
+			chunks = append(chunks, strings.Join(b.Objects, " "))
+		}
+		// Requests are sent concurrently, so they arrive in any order
+		sort.Strings(chunks)
+		var want []string
+		if group {
+			want = []string{
+				"https://a.example.com/1 https://A.example.com/2",
+				"https://a.example.com/3",
+				"https://b.example.com/1 https://b.example.com/2",
+				"https://c.example.com/1",
+			}
+		} else {
+			want = []string{
+				"https://A.example.com/2 https://c.example.com/1",
+				"https://a.example.com/1 https://b.example.com/1",
+				"https://b.example.com/2 https://a.example.com/3",
+			}
+		}
+		if !reflect.DeepEqual(chunks, want) {
+			t.Errorf("group %t: expected chunks %q, got %q", group, want, chunks)
+		}
+	}
+}
+
+func TestInvalidateByURLsShortReads(t *testing.T) {
+	var objects []string
+	for i := 0; i < 20; i++ {
+		objects = append(objects, fmt.Sprintf("https://example.com/%03d/%s", i, strings.Repeat("x", 100)))
+	}
+	in := strings.Join(objects, "\n")
+	for name, short := range map[string]func(io.Reader) io.Reader{"one byte": iotest.OneByteReader, "half": iotest.HalfReader} {
+		ts, rec := newTestServer(http.StatusCreated)
+		config := newTestConfig(ts)
+		config.maxBody = minBodySize
+		if err := Invalidation(context.Background(), config, short(strings.NewReader(in))); err != nil {
+			t.Fatal(err)
+		}
+		ts.Close()
+
+		if rec.count() < 2 {
+			t.Fatalf("%s: expected the list in several chunks, got %d", name, rec.count())
+		}
+		var got []string
+		for _, body := range rec.bodies {
+			if len(body) > config.maxBody {
+				t.Errorf("%s: a body of %d bytes exceeds %d", name, len(body), config.maxBody)
+			}
+			var b struct{ Objects []string }
+			if err := json.Unmarshal(body, &b); err != nil {
+				t.Fatalf("%s: %s", name, err)
+			}
+			got = append(got, b.Objects...)
+		}
+		// Requests are sent concurrently, so they arrive in any order
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, objects) {
+			t.Errorf("%s: expected every object complete once, got %q", name, got)
+		}
+	}
+}
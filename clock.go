@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// clock tells the time and waits between retries. Tests swap in a fake one so that retries
+// take no real time
+type clock interface {
+	Now() time.Time
+	// Sleep waits d unless ctx is done first
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+// realClock is the clock of the wall
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) Sleep(ctx context.Context, d time.Duration) error { return sleepContext(ctx, d) }
+
+// randSource picks random jitter. It is shared by request goroutines, so must be safe for concurrent use
+type randSource interface {
+	// Int63n returns a random number in [0, n)
+	Int63n(n int64) int64
+}
+
+// globalRand is the math/rand global source, seeded at startup
+type globalRand struct{}
+
+func (globalRand) Int63n(n int64) int64 { return rand.Int63n(n) }
+
+// seededRand is a source of its own seeded by -seed, so that jitter is reproducible. *rand.Rand isn't
+// safe for concurrent use, so it is guarded by mu
+type seededRand struct {
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+func newSeededRand(seed int64) *seededRand {
+	return &seededRand{rnd: rand.New(rand.NewSource(seed))}
+}
+
+func (r *seededRand) Int63n(n int64) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rnd.Int63n(n)
+}
+
+// requestRand returns the source of jitter of the request of body data. Each request has a source of
+// its own seeded by the run seed, its target, the index of its chunk and its body, so that requests
+// don't contend for a shared one, the same body sent to several targets or twice by one doesn't retry
+// in sync, and delays of a request are the same every run of the same -seed however requests
+// interleave. Tests setting config.rand get it as it is
+func (config *Config) requestRand(data []byte) randSource {
+	if !config.randPerRequest {
+		return config.randOrDefault()
+	}
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s %s %s %d\n", config.section, config.method, config.network, config.chunkIndex)
+	h.Write(data)
+	// Used by the goroutine of the request alone, so it needs no lock
+	return rand.New(rand.NewSource(config.seed ^ int64(h.Sum64())))
+}
+
+// withChunkIndex returns config numbering the next chunk submitted by its target, config itself
+// when the target doesn't number them
+func (config *Config) withChunkIndex() *Config {
+	if config.chunkSeq == nil {
+		return config
+	}
+	indexed := *config
+	indexed.chunkIndex = atomic.AddInt64(config.chunkSeq, 1)
+	return &indexed
+}
+
+// clockOrDefault returns the clock of retries, the real one unless tests set it
+func (config *Config) clockOrDefault() clock {
+	if config.clock == nil {
+		return realClock{}
+	}
+	return config.clock
+}
+
+// randOrDefault returns the source of jitter, the math/rand global one unless tests set it
+func (config *Config) randOrDefault() randSource {
+	if config.rand == nil {
+		return globalRand{}
+	}
+	return config.rand
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// fakeClock advances only when slept on, recording the delays
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	delays []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.delays = append(c.delays, d)
+	c.now = c.now.Add(d)
+	return ctx.Err()
+}
+
+func (c *fakeClock) sleeps() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]time.Duration(nil), c.delays...)
+}
+
+// fixedRand always picks n-1, the longest delay of every jitter
+type fixedRand struct{}
+
+func (fixedRand) Int63n(n int64) int64 { return n - 1 }
+
+func TestInvalidationRequestBackoff(t *testing.T) {
+	ts, rec := newTestServer(http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusCreated)
+	defer ts.Close()
+
+	config := newTestConfig(ts)
+	clock := &fakeClock{}
+	config.clock = clock
+	config.rand = fixedRand{}
+	config.tally = &Results{}
+	wall := time.Now()
+	sendTestRequest(config)
+
+	if rec.count() != 4 {
+		t.Fatalf("expected 3 retries, got %d requests", rec.count())
+	}
+	// Equal jitter doubles from baseDuration
+	want := []time.Duration{5*time.Second - 1, 10*time.Second - 1, 20*time.Second - 1}
+	if got := clock.sleeps(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected delays %v, got %v", want, got)
+	}
+	if elapsed := time.Since(wall); elapsed > 5*time.Second {
+		t.Errorf("retries should take no real time, took %s", elapsed)
+	}
+
+	// Cancellation stops retrying at the next wait
+	ts, rec = newTestServer(http.StatusServiceUnavailable)
+	defer ts.Close()
+	config = newTestConfig(ts)
+	config.clock = &fakeClock{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	invalidationRequest(ctx, config, []byte(`{"objects":["http://example.com/"]}`), &wg)
+	wg.Wait()
+	if rec.count() != 1 {
+		t.Errorf("a cancelled context should stop retrying, but %d requests were sent", rec.count())
+	}
+}
+
+func TestInvalidationRequestStartupJitter(t *testing.T) {
+	ts, rec := newTestServer(http.StatusCreated)
+	defer ts.Close()
+
+	// Off by default
+	config := newTestConfig(ts)
+	clock := &fakeClock{}
+	config.clock = clock
+	sendTestRequest(config)
+	if sleeps := clock.sleeps(); len(sleeps) != 0 {
+		t.Errorf("no delay is expected without -startup-jitter, got %v", sleeps)
+	}
+
+	config.startupJitter = time.Second
+	config.rand = fixedRand{}
+	sendTestRequest(config)
+	if sleeps := clock.sleeps(); len(sleeps) != 1 || sleeps[0] != time.Second-1 {
+		t.Errorf("expected a delay under -startup-jitter, got %v", sleeps)
+	}
+
+	// Cancellation skips the request during the delay
+	config = newTestConfig(ts)
+	config.clock = &fakeClock{}
+	config.startupJitter = time.Second
+	config.tally = &Results{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	invalidationRequest(ctx, config, []byte(`{"objects":["http://example.com/"]}`), &wg)
+	wg.Wait()
+	if rec.count() != 2 {
+		t.Errorf("a cancelled request should not be sent, got %d requests", rec.count())
+	}
+	if summary := config.tally.Summary(); summary.Failed != 1 {
+		t.Errorf("a cancelled request should be counted as failed: %s", summary)
+	}
+}
+
+func TestInvalidationRequestAttemptLog(t *testing.T) {
+	ts, _ := newTestServer(http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusCreated)
+	defer ts.Close()
+
+	config := newTestConfig(ts)
+	clock := &fakeClock{}
+	config.clock = clock
+	config.rand = fixedRand{}
+	hook, restore := captureLog()
+	sendTestRequest(config)
+	restore()
+
+	want := []struct {
+		message   string
+		attempt   int
+		nextDelay interface{}
+	}{
+		{"[Rate limited]", 1, 5*time.Second - 1},
+		{"[Retrying]", 2, 10*time.Second - 1},
+		{"[Succeed]", 3, nil},
+	}
+	var got []*logrus.Entry
+	for _, entry := range hook.AllEntries() {
+		if entry.Level == logrus.InfoLevel {
+			got = append(got, entry)
+		}
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d events, got %d", len(want), len(got))
+	}
+	for i, w := range want {
+		entry := got[i]
+		if entry.Message != w.message || entry.Data["attempt"] != w.attempt || entry.Data["max_attempts"] != retryThreshold || entry.Data["next_delay"] != w.nextDelay {
+			t.Errorf("expected %s of attempt %d/%d with next_delay %v, got %s %v", w.message, w.attempt, retryThreshold, w.nextDelay, entry.Message, entry.Data)
+		}
+	}
+	// The logged delays are the ones slept
+	if sleeps := clock.sleeps(); !reflect.DeepEqual(sleeps, []time.Duration{5*time.Second - 1, 10*time.Second - 1}) {
+		t.Errorf("expected the logged delays to be slept, got %v", sleeps)
+	}
+}
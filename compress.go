@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"sync/atomic"
+)
+
+// compression gzips request bodies for -compress until the gateway rejects one with 415, then falls
+// back to plain bodies for the rest of the run. A nil *compression never compresses
+type compression struct {
+	rejected int32
+}
+
+func newCompression() *compression {
+	return &compression{}
+}
+
+// enabled reports whether bodies should still be compressed
+func (c *compression) enabled() bool {
+	return c != nil && atomic.LoadInt32(&c.rejected) == 0
+}
+
+// reject stops compressing. It reports true only for the first rejection, which is worth a warning
+func (c *compression) reject() bool {
+	return c != nil && atomic.CompareAndSwapInt32(&c.rejected, 0, 1)
+}
+
+// gzipBody compresses a marshaled request body
+func gzipBody(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestInvalidationRequestCompress(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Content-Encoding") != "gzip" {
+			t.Errorf("expected a gzip-encoded body, got Content-Encoding %q", req.Header.Get("Content-Encoding"))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r, err := gzip.NewReader(req.Body)
+		if err != nil {
+			t.Errorf("the body should be gzip: %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := ioutil.ReadAll(r)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+
+	config := newTestConfig(ts)
+	config.compression = newCompression()
+	config.tally = &Results{}
+	sendTestRequest(config)
+	if summary := config.tally.Summary(); summary.Succeeded != 1 {
+		t.Errorf("expected the compressed request to succeed: %s", summary)
+	}
+	if want := []string{`{"objects":["http://example.com/"]}`}; !reflect.DeepEqual(bodies, want) {
+		t.Errorf("expected the body %q decompressed, got %q", want, bodies)
+	}
+}
+
+func TestInvalidationRequestCompressRejected(t *testing.T) {
+	var mu sync.Mutex
+	var encodings []string
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		encodings = append(encodings, req.Header.Get("Content-Encoding"))
+		mu.Unlock()
+		if req.Header.Get("Content-Encoding") == "gzip" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+
+	config := newTestConfig(ts)
+	config.compression = newCompression()
+	config.tally = &Results{}
+	sendTestRequest(config)
+	sendTestRequest(config)
+	if summary := config.tally.Summary(); summary.Succeeded != 2 {
+		t.Errorf("requests should fall back to plain bodies: %s", summary)
+	}
+	if want := []string{"gzip", "", ""}; !reflect.DeepEqual(encodings, want) {
+		t.Errorf("expected only the first attempt to be compressed, got %q", encodings)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// applyConfigFile sets flags from the -config file unless they are given on the command line, so that
+// flags win over the file, which wins over built-in defaults. Keys are flag names like "rps" or "retry-on"
+func applyConfigFile(config *Config, fs *flag.FlagSet) error {
+	if len(config.configFile) == 0 {
+		return nil
+	}
+	path, err := homedir.Expand(config.configFile)
+	if err != nil {
+		return err
+	}
+	fp, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	var entries []configEntry
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		entries, err = parseConfigFile(fp, "=")
+	case ".yaml", ".yml":
+		entries, err = parseConfigFile(fp, ":")
+	default:
+		return fmt.Errorf("you should specify a config file of .toml, .yaml or .yml, got %s", path)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	for _, entry := range entries {
+		f := fs.Lookup(entry.key)
+		if f == nil || entry.key == "config" {
+			return fmt.Errorf("%s:%d: unknown key %q, keys are flag names", path, entry.line, entry.key)
+		}
+		if given[entry.key] {
+			continue
+		}
+		values := entry.values
+		switch f.Value.(type) {
+		case *stringList, *headerList, *hostRewrites:
+			// Repeatable flags take list items one by one
+		default:
+			// Others take a list as a comma-separated value, like -retry-on
+			values = []string{strings.Join(values, ",")}
+		}
+		for _, value := range values {
+			if err := fs.Set(entry.key, value); err != nil {
+				return fmt.Errorf("%s:%d: %s: %s", path, entry.line, entry.key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// configEntry is a key of a config file with its values, several of them for repeatable flags
+type configEntry struct {
+	key    string
+	values []string
+	line   int
+}
+
+// parseConfigFile reads flat "key = value" TOML or "key: value" YAML, whichever sep says. Values are
+// strings, numbers, booleans or lists of them: [a, b] in both, or "- item" lines under a key in YAML.
+// Tables and nested mappings aren't supported, since flags are flat
+func parseConfigFile(r io.Reader, sep string) (entries []configEntry, err error) {
+	scanner := bufio.NewScanner(r)
+	seen := map[string]bool{}
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if len(line) == 0 || line == "---" {
+			continue
+		}
+		if strings.HasPrefix(line, "- ") && sep == ":" && len(entries) > 0 {
+			// An item of the block list under the last key
+			last := &entries[len(entries)-1]
+			value, err := unquoteConfigValue(strings.TrimSpace(line[2:]))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", n, err)
+			}
+			last.values = append(last.values, value)
+			continue
+		}
+		i := strings.Index(line, sep)
+		if i <= 0 {
+			return nil, fmt.Errorf("line %d: expected \"key %s value\", got %q", n, sep, line)
+		}
+		key, raw := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		if seen[key] {
+			return nil, fmt.Errorf("line %d: duplicate key %q", n, key)
+		}
+		seen[key] = true
+		entry := configEntry{key: key, line: n}
+		if strings.HasPrefix(raw, "[") && strings.HasSuffix(raw, "]") {
+			for _, item := range splitConfigList(raw[1 : len(raw)-1]) {
+				value, err := unquoteConfigValue(item)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %s", n, err)
+				}
+				entry.values = append(entry.values, value)
+			}
+		} else if len(raw) > 0 || sep == "=" {
+			value, err := unquoteConfigValue(raw)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", n, err)
+			}
+			entry.values = []string{value}
+		}
+		entries = append(entries, entry)
+	}
+	for _, entry := range entries {
+		if len(entry.values) == 0 {
+			return nil, fmt.Errorf("line %d: a value of %q is missing", entry.line, entry.key)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// stripComment removes a # comment outside of quotes
+func stripComment(line string) string {
+	var quote rune
+	for i, c := range line {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// splitConfigList splits items of a flow list by commas outside of quotes
+func splitConfigList(s string) (items []string) {
+	var quote rune
+	start := 0
+	for i, c := range s {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			items = append(items, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(s[start:]); len(last) > 0 {
+		items = append(items, last)
+	}
+	return items
+}
+
+// unquoteConfigValue returns a scalar as a flag value, unquoting "double" and 'single' quoted strings
+func unquoteConfigValue(raw string) (string, error) {
+	switch {
+	case len(raw) >= 2 && raw[0] == '"' && raw[len(raw)-1] == '"':
+		return strconv.Unquote(raw)
+	case len(raw) >= 2 && raw[0] == '\'' && raw[len(raw)-1] == '\'':
+		return raw[1 : len(raw)-1], nil
+	case len(raw) == 0:
+		return "", fmt.Errorf("a value is missing")
+	}
+	return raw, nil
+}
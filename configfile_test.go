@@ -0,0 +1,107 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("%s", err)
+	}
+	return path
+}
+
+func TestApplyConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "purge-config")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"purge.toml": `# team defaults
+n = "production"
+rps = 5
+max-delay = "30s"
+retry-on = [429, 503]
+header = ["X-Team: cdn", "X-Env: prod # not a comment"]
+sort = true
+`,
+		"purge.yaml": `---
+n: production # team defaults
+rps: 5
+max-delay: 30s
+retry-on: [429, 503]
+header:
+  - "X-Team: cdn"
+  - 'X-Env: prod # not a comment'
+sort: true
+`,
+	}
+	for name, content := range files {
+		path := writeConfigFile(t, dir, name, content)
+
+		// Flags on the command line win over the file, which wins over defaults
+		var config Config
+		fs := newFlagSet(&config, "akamai-fast-purge-client")
+		if err := fs.Parse([]string{"-config", path, "-rps", "10"}); err != nil {
+			t.Fatalf("%s", err)
+		}
+		if err := applyConfigFile(&config, fs); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if config.network != "production" || config.rps != 10 || config.maxDelay != 30*time.Second || !config.sort {
+			t.Errorf("%s: unexpected config: network %s, rps %v, max delay %s, sort %v", name, config.network, config.rps, config.maxDelay, config.sort)
+		}
+		if config.method != defaultMethod {
+			t.Errorf("%s: keys not in the file should keep defaults, got method %s", name, config.method)
+		}
+		if got := config.retryOn.String(); got != "429,503" {
+			t.Errorf("%s: expected retry-on 429,503, got %s", name, got)
+		}
+		if got := config.headers.String(); got != "X-Env: prod # not a comment, X-Team: cdn" {
+			t.Errorf("%s: unexpected headers %q", name, got)
+		}
+	}
+
+	invalid := map[string]string{
+		"unknown.toml":   "rsp = 5\n",
+		"nested.yaml":    "config: other.yaml\n",
+		"bad-value.toml": "rps = \"fast\"\n",
+		"syntax.yaml":    "just a line\n",
+		"missing.yaml":   "n:\n",
+		"duplicate.toml": "n = \"staging\"\nn = \"production\"\n",
+		"purge.ini":      "n = staging\n",
+	}
+	for name, content := range invalid {
+		path := writeConfigFile(t, dir, name, content)
+		var config Config
+		fs := newFlagSet(&config, "akamai-fast-purge-client")
+		if err := fs.Parse([]string{"-config", path}); err != nil {
+			t.Fatalf("%s", err)
+		}
+		if err := applyConfigFile(&config, fs); err == nil {
+			t.Errorf("%s should be invalid", name)
+		} else if !strings.Contains(err.Error(), path) {
+			t.Errorf("%s: the error should tell the file, got %s", name, err)
+		}
+	}
+}
+
+func TestRunConfigFileError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "purge-config")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := writeConfigFile(t, dir, "purge.toml", "no-such-flag = 1\n")
+	if got := exitCode(run([]string{"-config", path, "-url", "https://example.com/"}, ioutil.Discard)); got != exitConfig {
+		t.Errorf("expected exit code %d for an invalid config file, got %d", exitConfig, got)
+	}
+}
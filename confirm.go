@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+var errAborted = errors.New("aborted")
+
+// needsConfirmation reports whether the run permanently removes objects from production cache with any
+// of its sections
+func needsConfirmation(config *Config) bool {
+	for _, d := range targetDefaults(config) {
+		if (d.method == "delete" || d.method == "both") && (d.network == "production" || d.network == "both") {
+			return true
+		}
+	}
+	return false
+}
+
+// purgesProduction reports whether any section of the run purges the production network
+func purgesProduction(config *Config) bool {
+	for _, d := range targetDefaults(config) {
+		if d.network == "production" || d.network == "both" {
+			return true
+		}
+	}
+	return false
+}
+
+// targetDefaults returns the method and network of each section, which may differ by their edgerc keys
+func targetDefaults(config *Config) []edgercDefaults {
+	if len(config.sectionDefaults) > 0 {
+		return config.sectionDefaults
+	}
+	return []edgercDefaults{{method: config.method, network: config.network}}
+}
+
+// isTerminal reports whether f is a character device, i.e. an interactive terminal
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// countInputObjects counts purge objects in r without sending anything
+func countInputObjects(config *Config, r io.Reader) (int, error) {
+	if config.fileType == "csv" {
+		var urls bytes.Buffer
+		if err := writeCSVColumn(config, r, &urls); err != nil {
+			return 0, err
+		}
+		r = &urls
+	}
+	if len(config.jsonPointer) > 0 && config.fileType == "text" {
+		var objects bytes.Buffer
+		if err := writeJSONPath(config, r, &objects); err != nil {
+			return 0, err
+		}
+		r = &objects
+	}
+
+	count := 0
+	if config.fileType == "json" {
+		dec := newBodyDecoder(r, config.inputFormat)
+		for {
+			fields, err := dec.next()
+			if err != nil {
+				if err == io.EOF {
+					return count, nil
+				}
+				return count, err
+			}
+			if body, err := parseBody(fields); err == nil {
+				count += len(body.Objects)
+			}
+		}
+	}
+
+	scanner := newLineScanner(r, config.maxLineBytes)
+	for scanner.Scan() {
+		if scanner.lineErr() != nil {
+			continue
+		}
+		// -hostname isn't given with -t mixed, so that the type of a line is the kind of its object
+		kind, line := config.objectKind(), scanner.Text()
+		if config.fileType == "mixed" {
+			var objectType string
+			if objectType, line = splitTypedLine(line); len(objectType) > 0 {
+				kind = objectType
+			}
+		}
+		lines, err := config.expandLine(strings.TrimSpace(line))
+		if err != nil {
+			continue
+		}
+		for _, line := range lines {
+			if config.checkObject(kind, line) == nil {
+				count++
+			}
+		}
+	}
+	return count, scanner.Err()
+}
+
+// countPaths counts valid objects in paths and given by flags
+func countPaths(ctx context.Context, config *Config, paths []string) (int, error) {
+	// Objects given by flags are purged along with files
+	total := len(config.objects)
+	for _, p := range paths {
+		if p == stdinPath {
+			// Reading stdin would leave nothing to purge, it is only capped as it is read
+			continue
+		}
+		fp, err := openInput(ctx, config, p)
+		if err != nil {
+			return total, err
+		}
+		count, err := countInputObjects(config, fp)
+		fp.Close()
+		if err != nil {
+			return total, err
+		}
+		total += count
+	}
+	return total, nil
+}
+
+// confirm asks on out whether to delete the objects in paths from production, reading the answer from in
+func confirm(ctx context.Context, config *Config, paths []string, in io.Reader, out io.Writer) error {
+	total, err := countPaths(ctx, config, paths)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Delete %d object(s) from production network? This removes them from cache permanently [y/N]: ", total)
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return errAborted
+}
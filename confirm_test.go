@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNeedsConfirmation(t *testing.T) {
+	tests := []struct {
+		method, network string
+		want            bool
+	}{
+		{"delete", "production", true},
+		{"delete", "staging", false},
+		{"delete", "both", true},
+		{"both", "production", true},
+		{"both", "staging", false},
+		{"invalidate", "production", false},
+		{"invalidate", "staging", false},
+	}
+	for _, tt := range tests {
+		if got := needsConfirmation(&Config{method: tt.method, network: tt.network}); got != tt.want {
+			t.Errorf("%s on %s: expected %v, got %v", tt.method, tt.network, tt.want, got)
+		}
+	}
+}
+
+func TestNeedsConfirmationSections(t *testing.T) {
+	// The first section alone would look safe
+	config := &Config{method: "invalidate", network: "staging",
+		sectionDefaults: []edgercDefaults{{"invalidate", "staging"}, {"delete", "production"}}}
+	if !needsConfirmation(config) {
+		t.Error("deleting from production with any section should need confirmation")
+	}
+	if !purgesProduction(config) {
+		t.Error("purging production with any section should be told")
+	}
+	config.sectionDefaults = []edgercDefaults{{"invalidate", "staging"}, {"delete", "staging"}}
+	if needsConfirmation(config) || purgesProduction(config) {
+		t.Error("sections of staging only shouldn't need confirmation")
+	}
+}
+
+func TestRunSectionsDeleteRequiresYes(t *testing.T) {
+	unsetEdgegridEnv()
+	dir, err := ioutil.TempDir("", "purge-confirm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "list.txt")
+	if err := ioutil.WriteFile(path, []byte("https://example.com/a\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"-c", defaultsEdgercFile, "-s", "stage,prod", path},
+		{"-c", defaultsEdgercFile, "-s", "stage,prod", "-m", "invalidate", "-wildcard", path},
+	} {
+		// Unanswered on a terminal, the prompt aborts
+		err := run(args, &bytes.Buffer{})
+		if err == nil || !strings.Contains(err.Error(), "-yes") && err.Error() != errAborted.Error() {
+			t.Errorf("%v: the prod section should require -yes, got %v", args, err)
+		}
+	}
+}
+
+func TestCountInputObjects(t *testing.T) {
+	tests := []struct {
+		fileType, input string
+		want            int
+	}{
+		{"text", "https://example.com/a\n\nhttps://example.com/b\nnot a url\n", 2},
+		{"json", `{"objects":["https://example.com/a","https://example.com/b"]}{"objects":["https://example.com/c"]}`, 3},
+		{"csv", "url\nhttps://example.com/a\nhttps://example.com/b\n", 2},
+	}
+	for _, tt := range tests {
+		got, err := countInputObjects(&Config{fileType: tt.fileType}, strings.NewReader(tt.input))
+		if err != nil {
+			t.Errorf("%s: %s", tt.fileType, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: expected %d objects, got %d", tt.fileType, tt.want, got)
+		}
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	dir, err := ioutil.TempDir("", "purge-confirm")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+	list := filepath.Join(dir, "list.txt")
+	if err := ioutil.WriteFile(list, []byte("https://example.com/a\nhttps://example.com/b\n"), 0644); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	config := &Config{method: "delete", network: "production", fileType: "text"}
+	for answer, want := range map[string]error{"y\n": nil, "yes\n": nil, "n\n": errAborted, "\n": errAborted, "": errAborted} {
+		var out bytes.Buffer
+		if err := confirm(context.Background(), config, []string{list}, strings.NewReader(answer), &out); err != want {
+			t.Errorf("answer %q: expected %v, got %v", answer, want, err)
+		}
+		if !strings.Contains(out.String(), "Delete 2 object(s)") {
+			t.Errorf("prompt should show the object count: %q", out.String())
+		}
+	}
+}
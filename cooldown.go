@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cooldown pauses sending of every request goroutine after a 429 of any of them, for
+// -throttle-on-429-global, instead of each one backing off alone while the others keep rate limiting
+// the account. A nil *cooldown never pauses.
+type cooldown struct {
+	mu    sync.Mutex
+	until time.Time // when sending resumes
+}
+
+func newCooldown() *cooldown {
+	return &cooldown{}
+}
+
+// pause stops sending for d from now, extending a pause in effect if it ends earlier. It reports
+// whether a new pause started, so that a burst of 429s is logged once
+func (c *cooldown) pause(now time.Time, d time.Duration) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	started := !c.until.After(now)
+	if until := now.Add(d); until.After(c.until) {
+		c.until = until
+	}
+	return started
+}
+
+// wait blocks until no pause is in effect or ctx is done. A pause extended while waiting is waited out too
+func (c *cooldown) wait(ctx context.Context, clock clock) error {
+	if c == nil {
+		return nil
+	}
+	for {
+		c.mu.Lock()
+		d := c.until.Sub(clock.Now())
+		c.mu.Unlock()
+		if d <= 0 {
+			return ctx.Err()
+		}
+		if err := clock.Sleep(ctx, d); err != nil {
+			return err
+		}
+	}
+}
+
+// retryAfter returns the wait of the Retry-After header, given in seconds or as an HTTP date
+func retryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	value := strings.TrimSpace(header.Get("Retry-After"))
+	if len(value) == 0 {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	at, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if d := at.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
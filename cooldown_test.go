@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"3", 3 * time.Second, true},
+		{" 0 ", 0, true},
+		{"-1", 0, false},
+		{"Wed, 01 Apr 2020 00:00:05 GMT", 5 * time.Second, true},
+		{"Tue, 31 Mar 2020 23:59:00 GMT", 0, true},
+		{"soon", 0, false},
+	} {
+		header := http.Header{}
+		if len(tt.value) > 0 {
+			header.Set("Retry-After", tt.value)
+		}
+		if got, ok := retryAfter(header, now); got != tt.want || ok != tt.ok {
+			t.Errorf("%q: expected %s, %t, got %s, %t", tt.value, tt.want, tt.ok, got, ok)
+		}
+	}
+}
+
+func TestCooldownPause(t *testing.T) {
+	var c *cooldown
+	if c.pause(time.Now(), time.Hour) || c.wait(context.Background(), realClock{}) != nil {
+		t.Errorf("a nil cooldown should never pause")
+	}
+
+	c = newCooldown()
+	now := time.Now()
+	if !c.pause(now, 2*time.Second) {
+		t.Errorf("the first 429 should start a pause")
+	}
+	// Another 429 during the pause only extends it
+	if c.pause(now.Add(time.Second), 3*time.Second) {
+		t.Errorf("a 429 during a pause shouldn't start another one")
+	}
+	if want := now.Add(4 * time.Second); !c.until.Equal(want) {
+		t.Errorf("expected the pause extended until %s, got %s", want, c.until)
+	}
+	if c.pause(now.Add(2*time.Second), time.Second) || !c.until.Equal(now.Add(4*time.Second)) {
+		t.Errorf("a shorter pause shouldn't shorten the one in effect, got %s", c.until)
+	}
+
+	clock := &fakeClock{now: now}
+	if err := c.wait(context.Background(), clock); err != nil || !clock.Now().Equal(now.Add(4*time.Second)) {
+		t.Errorf("expected waiting until the end of the pause, got %s, %v", clock.Now(), err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.pause(clock.Now(), time.Second)
+	if err := c.wait(ctx, clock); err != context.Canceled {
+		t.Errorf("expected waiting to stop by cancellation, got %v", err)
+	}
+}
+
+func TestInvalidationRequestThrottleGlobal(t *testing.T) {
+	// The first request is rate limited for a second, the rest succeed
+	var mu sync.Mutex
+	var sent []time.Time
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		sent = append(sent, time.Now())
+		first := len(sent) == 1
+		mu.Unlock()
+		if first {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"httpStatus":201,"purgeId":"` + testPurgeID + `"}`))
+	}))
+	defer ts.Close()
+
+	config := newTestConfig(ts)
+	config.cooldown = newCooldown()
+	config.tally = &Results{}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go invalidationRequest(context.Background(), config, []byte(`{"objects":["http://example.com/0"]}`), &wg)
+
+	// Wait for the 429 to pause sending
+	var until time.Time
+	for deadline := time.Now().Add(5 * time.Second); until.IsZero(); {
+		if time.Now().After(deadline) {
+			t.Fatal("a 429 should pause sending")
+		}
+		time.Sleep(time.Millisecond)
+		config.cooldown.mu.Lock()
+		until = config.cooldown.until
+		config.cooldown.mu.Unlock()
+	}
+
+	// Workers started during the pause wait it out, then resume
+	for i := 1; i <= 4; i++ {
+		wg.Add(1)
+		go invalidationRequest(context.Background(), config, []byte(`{"objects":["http://example.com/`+string(rune('0'+i))+`"]}`), &wg)
+	}
+	wg.Wait()
+
+	if len(sent) != 6 {
+		t.Fatalf("expected the rate limited request retried and 4 more sent, got %d requests", len(sent))
+	}
+	if pause := until.Sub(sent[0]); pause < 900*time.Millisecond {
+		t.Errorf("expected a pause of Retry-After, got %s", pause)
+	}
+	for i, at := range sent[1:] {
+		if at.Before(until) {
+			t.Errorf("request #%d was sent %s before the pause ended", i+2, until.Sub(at))
+		}
+	}
+	if s := config.tally.Summary(); s.Succeeded != 5 || s.Failed != 0 {
+		t.Errorf("expected all requests to succeed after the pause, got %s", s)
+	}
+}
+
+func TestInvalidationRequestThrottleGlobalMaxDelay(t *testing.T) {
+	// A Retry-After of an hour is capped by -max-delay
+	var mu sync.Mutex
+	var sent []time.Time
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		sent = append(sent, time.Now())
+		first := len(sent) == 1
+		mu.Unlock()
+		if first {
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"httpStatus":201,"purgeId":"` + testPurgeID + `"}`))
+	}))
+	defer ts.Close()
+
+	config := newTestConfig(ts)
+	config.cooldown = newCooldown()
+	config.maxDelay = 100 * time.Millisecond
+	config.tally = &Results{}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	done := make(chan struct{})
+	go func() {
+		invalidationRequest(context.Background(), config, []byte(`{"objects":["http://example.com/0"]}`), &wg)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the pause should be capped by -max-delay")
+	}
+
+	config.cooldown.mu.Lock()
+	until := config.cooldown.until
+	config.cooldown.mu.Unlock()
+	if pause := until.Sub(sent[0]); pause > time.Second {
+		t.Errorf("expected a pause of -max-delay, got %s", pause)
+	}
+	if s := config.tally.Summary(); s.Succeeded != 1 {
+		t.Errorf("expected the request to succeed after the pause, got %s", s)
+	}
+}
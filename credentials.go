@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// credentialsTestBody has no objects, so Fast Purge rejects it with 400 once the request is authenticated.
+// Authentication failures are 401, or 403 when the API client has no access to Fast Purge
+var credentialsTestBody = []byte(`{"objects":[]}`)
+
+// authHint tells what to check when Fast Purge rejects the signature or the API client, "" for other
+// statuses. These don't fix themselves, so they are never retried
+func authHint(status int) string {
+	switch status {
+	case http.StatusUnauthorized:
+		return "check the system clock is in sync, signatures are rejected after a few minutes of skew, and client_secret and access_token of the edgerc section are current"
+	case http.StatusForbidden:
+		return "confirm the API client has access to Fast Purge (CCU APIs) for the objects, and client_secret and access_token of the edgerc section are current"
+	}
+	return ""
+}
+
+// testCredentials sends an authenticated request purging nothing to staging network, and reports whether
+// Fast Purge accepted the credentials
+func testCredentials(ctx context.Context, config *Config) error {
+	target := *config
+	target.method, target.network = defaultMethod, defaultNetwork
+	req, err := http.NewRequestWithContext(ctx, cachePurgeRequestMethohd, buildRequestURL(&target).String(), bytes.NewReader(credentialsTestBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", purgeContentType)
+	req.Header.Set("User-Agent", config.userAgentOrDefault())
+	req = config.sign(req)
+
+	resp, err := config.httpClient().Do(req)
+	if err != nil {
+		return withTLSHint(err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusBadRequest, http.StatusCreated:
+		return nil
+	}
+	var rb ResponseBody
+	json.Unmarshal(respBody, &rb)
+	err = fmt.Errorf("credentials are rejected by %s: %d %s: %s (supportId: %s)",
+		config.edgeConf.Host, resp.StatusCode, rb.Title, rb.Detail, rb.SupportID)
+	if hint := authHint(resp.StatusCode); len(hint) > 0 {
+		err = fmt.Errorf("%s, %s", err, hint)
+	}
+	return err
+}
+
+// runTestCredentials tests credentials of every section given by -s without purging anything
+func runTestCredentials(config *Config, fs *flag.FlagSet, stdout io.Writer) error {
+	if err := setup(config, fs); err != nil {
+		return configError(err)
+	}
+	defer config.closeLog()
+	if err := loadSections(config); err != nil {
+		return configError(err)
+	}
+	client, err := newHTTPClient(config)
+	if err != nil {
+		return configError(err)
+	}
+	config.client = client
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopSignal := cancelOnInterrupt(cancel)
+	defer stopSignal()
+
+	var failed error
+	for _, target := range sectionTargets(config) {
+		if err := testCredentials(ctx, target); err != nil {
+			if ctx.Err() != nil {
+				return errInterrupted
+			}
+			fmt.Fprintf(stdout, "[Credentials] section %s: NG: %s\n", target.section, err)
+			failed = err
+			continue
+		}
+		fmt.Fprintf(stdout, "[Credentials] section %s: OK, authenticated to %s\n", target.section, target.edgeConf.Host)
+	}
+	return failed
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestTestCredentials(t *testing.T) {
+	ts, rec := newTestServer(http.StatusBadRequest)
+	defer ts.Close()
+
+	// Even with delete on production, nothing is purged and it goes to staging
+	config := newTestConfig(ts)
+	config.method, config.network = "delete", "production"
+	if err := testCredentials(context.Background(), config); err != nil {
+		t.Errorf("400 for an empty request means authenticated, got %s", err)
+	}
+	if got := rec.requests[0].URL.Path; got != "/ccu/v3/invalidate/url/staging" {
+		t.Errorf("expected a request to staging, got %s", got)
+	}
+	if got := rec.joinedBodies(); got != `{"objects":[]}` {
+		t.Errorf("expected no objects to be sent, got %s", got)
+	}
+
+	for _, status := range []int{http.StatusUnauthorized, http.StatusForbidden} {
+		ts, _ := newTestServer(status)
+		err := testCredentials(context.Background(), newTestConfig(ts))
+		ts.Close()
+		if err == nil || !strings.Contains(err.Error(), testSupportID) {
+			t.Errorf("%d: expected an error with the support ID, got %v", status, err)
+		}
+	}
+}
+
+func TestRunTestCredentials(t *testing.T) {
+	setEdgegridEnv(t)
+	defer unsetEdgegridEnv()
+	defer log.SetLevel(log.Level)
+
+	for status, want := range map[int]int{http.StatusBadRequest: exitOK, http.StatusUnauthorized: exitFailed} {
+		ts, rec := newTestServer(status)
+		os.Setenv("AKAMAI_HOST", strings.TrimPrefix(ts.URL, "https://"))
+		var out bytes.Buffer
+		got := exitCode(run([]string{"-insecure", "-test-credentials"}, &out))
+		ts.Close()
+		if got != want {
+			t.Errorf("%d: expected exit code %d, got %d: %s", status, want, got, out.String())
+		}
+		if rec.count() != 1 || !strings.HasPrefix(out.String(), "[Credentials] section default: ") {
+			t.Errorf("%d: expected one request reported, got %d: %q", status, rec.count(), out.String())
+		}
+	}
+}
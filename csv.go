@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// csvColumnIndex resolves -csv-column, a 1-origin index or a name looked up in header, to a 0-origin index.
+// named reports whether column is a name, which means the first record is a header
+func csvColumnIndex(column string, header []string) (index int, named bool, err error) {
+	if n, err := strconv.Atoi(column); err == nil {
+		if n < 1 {
+			return 0, false, fmt.Errorf("CSV column index should be 1 or more, but got %d", n)
+		}
+		return n - 1, false, nil
+	}
+	for i, name := range header {
+		if name == column {
+			return i, true, nil
+		}
+	}
+	return 0, true, fmt.Errorf("CSV header does not have %q column", column)
+}
+
+// writeCSVColumn writes the selected column of each CSV record in r to w, one per line
+func writeCSVColumn(config *Config, r io.Reader, w io.Writer) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	column := config.csvColumn
+	if len(column) == 0 {
+		column = defaultCSVColumn
+	}
+	index := -1
+	for n := 1; ; n++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if index < 0 {
+			var named bool
+			if index, named, err = csvColumnIndex(column, record); err != nil {
+				return err
+			}
+			// A named column implies a header, otherwise skip the first record unless it is a URL
+			if named || config.csvHeader || (index < len(record) && validateURL(record[index]) != nil) {
+				continue
+			}
+		}
+
+		if index >= len(record) {
+			err := fmt.Errorf("CSV record #%d does not have column %s", n, column)
+			if config.strict {
+				return err
+			}
+			log.Warnf("skip invalid record: %s", err)
+			continue
+		}
+		if _, err := io.WriteString(w, record[index]+"\n"); err != nil {
+			return err
+		}
+	}
+}
+
+// InvalidateByCSV purges URLs in a column of CSV through the same chunking as text input
+func InvalidateByCSV(ctx context.Context, config *Config, fp io.Reader, wg *sync.WaitGroup) error {
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := writeCSVColumn(config, fp, pw)
+		pw.Close()
+		done <- err
+	}()
+
+	err := InvalidateByURLs(ctx, config, pr, wg)
+	// Unblock the writer when InvalidateByURLs stopped reading early
+	pr.Close()
+	if csvErr := <-done; csvErr != nil && csvErr != io.ErrClosedPipe {
+		return csvErr
+	}
+	return err
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWriteCSVColumn(t *testing.T) {
+	tests := []struct {
+		name   string
+		column string
+		header bool
+		input  string
+		want   string
+	}{
+		{
+			name:   "index without header",
+			column: "2",
+			input:  "a,https://example.com/a\nb,https://example.com/b\n",
+			want:   "https://example.com/a\nhttps://example.com/b\n",
+		},
+		{
+			name:   "index with detected header",
+			column: "2",
+			input:  "name,url\na,https://example.com/a\n",
+			want:   "https://example.com/a\n",
+		},
+		{
+			name:   "named column",
+			column: "url",
+			input:  "url,name\nhttps://example.com/a,a\n",
+			want:   "https://example.com/a\n",
+		},
+		{
+			name:   "explicit header",
+			column: "1",
+			header: true,
+			input:  "https://example.com/header\nhttps://example.com/a\n",
+			want:   "https://example.com/a\n",
+		},
+		{
+			name:   "quoted fields",
+			column: "url",
+			input:  "title,url\n\"Hello, \"\"world\"\"\",\"https://example.com/a?b=1,2\"\n",
+			want:   "https://example.com/a?b=1,2\n",
+		},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		config := &Config{csvColumn: tt.column, csvHeader: tt.header}
+		if err := writeCSVColumn(config, strings.NewReader(tt.input), &out); err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if out.String() != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, out.String())
+		}
+	}
+
+	if err := writeCSVColumn(&Config{csvColumn: "link"}, strings.NewReader("url\nhttps://example.com/a\n"), &bytes.Buffer{}); err == nil {
+		t.Errorf("something went wrong, a missing named column should be failed but succeeded")
+	}
+}
+
+func TestInvalidateByCSV(t *testing.T) {
+	ts, rec := newTestServer(http.StatusCreated)
+	defer ts.Close()
+
+	config := newTestConfig(ts)
+	config.fileType = "csv"
+	config.csvColumn = "url"
+	input := "id,url\n1,https://example.com/a\n2,\"https://example.com/b\"\n"
+	if err := Invalidation(context.Background(), config, strings.NewReader(input)); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if body := rec.joinedBodies(); body != `{"objects":["https://example.com/a","https://example.com/b"]}` {
+		t.Errorf("unexpected request body: %s", body)
+	}
+
+	// Strict mode stops reading CSV on an invalid URL
+	config.strict = true
+	rec.requests, rec.bodies = nil, nil
+	var wg sync.WaitGroup
+	if err := InvalidateByCSV(context.Background(), config, strings.NewReader("url\nnot a url\nhttps://example.com/a\n"), &wg); err == nil {
+		t.Errorf("something went wrong, invalid URL in strict mode should be failed but succeeded")
+	}
+	wg.Wait()
+}
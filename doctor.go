@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const defaultDoctorTimeout = 30 * time.Second
+
+// doctorStages are what a request to the purge host goes through, in order
+var doctorStages = []string{"dns", "tcp", "tls", "auth"}
+
+// doctorStage is the outcome of a stage of the doctor check. A stage after a failed one is skipped
+type doctorStage struct {
+	name    string
+	ran     bool
+	took    time.Duration
+	detail  string
+	err     error
+	started time.Time
+}
+
+func (s doctorStage) result() string {
+	switch {
+	case !s.ran:
+		return "SKIP"
+	case s.err != nil:
+		return "NG"
+	}
+	return "OK"
+}
+
+// doctorTrace records stages of a request by httptrace. Hooks may be called from other goroutines,
+// e.g. of the resolver, so it is guarded by mu
+type doctorTrace struct {
+	mu     sync.Mutex
+	now    func() time.Time
+	stages map[string]*doctorStage
+}
+
+func newDoctorTrace(now func() time.Time) *doctorTrace {
+	t := &doctorTrace{now: now, stages: map[string]*doctorStage{}}
+	for _, name := range doctorStages {
+		t.stages[name] = &doctorStage{name: name}
+	}
+	return t
+}
+
+func (t *doctorTrace) start(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.stages[name]
+	s.ran, s.started, s.err = true, t.now(), nil
+}
+
+func (t *doctorTrace) done(name, detail string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.stages[name]
+	if !s.ran {
+		s.ran, s.started = true, t.now()
+	}
+	s.took, s.detail, s.err = t.now().Sub(s.started), detail, err
+}
+
+func (t *doctorTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { t.start("dns") },
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			var addrs []string
+			for _, addr := range info.Addrs {
+				addrs = append(addrs, addr.String())
+			}
+			t.done("dns", strings.Join(addrs, ", "), info.Err)
+		},
+		ConnectStart: func(network, addr string) { t.start("tcp") },
+		ConnectDone: func(network, addr string, err error) {
+			t.done("tcp", addr, err)
+		},
+		TLSHandshakeStart: func() { t.start("tls") },
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			t.done("tls", tlsVersionName(state.Version), err)
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) { t.start("auth") },
+	}
+}
+
+// finish sets the outcome of the authenticated request, err of which belongs to the stage it failed in.
+// With an IP address host, there is no DNS lookup to trace
+func (t *doctorTrace) finish(host string, err error) []doctorStage {
+	t.mu.Lock()
+	dns := t.stages["dns"]
+	if !dns.ran && net.ParseIP(hostname(host)) != nil {
+		dns.ran, dns.detail = true, "an IP address, no lookup"
+	}
+	t.mu.Unlock()
+
+	failed := false
+	stages := make([]doctorStage, len(doctorStages))
+	for i, name := range doctorStages {
+		s := *t.stages[name]
+		switch {
+		case failed:
+			s.ran = false
+		case name == "auth":
+			s.ran, s.err = true, err
+			if err == nil {
+				s.detail = "authenticated"
+			}
+			if !s.started.IsZero() {
+				s.took = t.now().Sub(s.started)
+			}
+		case !s.ran && err != nil:
+			// The request failed before the stage, e.g. by a timeout of the one before
+			s.ran, s.err = true, err
+		}
+		failed = failed || s.err != nil
+		stages[i] = s
+	}
+	return stages
+}
+
+// hostname strips the port of a host of the edgerc
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+	return ""
+}
+
+// checkPurgeHost sends the authenticated request of -test-credentials purging nothing, tracing DNS, TCP
+// and TLS of it on a fresh connection
+func checkPurgeHost(ctx context.Context, config *Config) []doctorStage {
+	clock := config.clockOrDefault()
+	trace := newDoctorTrace(clock.Now)
+	err := testCredentials(httptrace.WithClientTrace(ctx, trace.clientTrace()), config)
+	return trace.finish(config.edgeConf.Host, err)
+}
+
+// printDoctorStages prints stages as a table, and returns the error of the failed one
+func printDoctorStages(w io.Writer, stages []doctorStage) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STAGE\tRESULT\tTIME\tDETAIL")
+	var failed error
+	for _, s := range stages {
+		took, detail := "-", s.detail
+		if s.ran {
+			took = s.took.Round(time.Microsecond).String()
+		}
+		if s.err != nil {
+			detail = s.err.Error()
+			failed = fmt.Errorf("%s check failed: %s", s.name, s.err)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", s.name, s.result(), took, detail)
+	}
+	tw.Flush()
+	return failed
+}
+
+// newDoctorFlagSet defines command line flags of the doctor subcommand bound to config
+func newDoctorFlagSet(config *Config, name string, timeout *time.Duration) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	addCommonFlags(fs, config)
+	fs.DurationVar(timeout, "timeout", defaultDoctorTimeout, "specify a time limit of the check")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags]\n\nFlags:\n", name)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// runDoctor checks DNS, TCP, TLS and credentials against the purge host of the section given by -s,
+// printing a table of stages with their timings. Nothing is purged
+func runDoctor(name string, args []string, stdout io.Writer) error {
+	var config Config
+	var timeout time.Duration
+	fs := newDoctorFlagSet(&config, name, &timeout)
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return configError(err)
+	}
+	if config.showVersion {
+		fmt.Fprintln(stdout, versionString())
+		return nil
+	}
+	if fs.NArg() > 0 {
+		return configError(errors.New("you should specify no arguments, doctor purges nothing"))
+	}
+
+	if err := setup(&config, fs); err != nil {
+		return configError(err)
+	}
+	defer config.closeLog()
+	if err := loadEdgeConfig(&config); err != nil {
+		return configError(err)
+	}
+	if err := validateCredentials(&config); err != nil {
+		return configError(err)
+	}
+	client, err := newHTTPClient(&config)
+	if err != nil {
+		return configError(err)
+	}
+	// Every check dials afresh, so that DNS, TCP and TLS are traced instead of reusing a connection
+	client.Transport.(*http.Transport).DisableKeepAlives = true
+	config.client = client
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopSignal := cancelOnInterrupt(cancel)
+	defer stopSignal()
+
+	checkCtx, cancelCheck := context.WithTimeout(ctx, timeout)
+	defer cancelCheck()
+	stages := checkPurgeHost(checkCtx, &config)
+	if ctx.Err() != nil {
+		return errInterrupted
+	}
+	fmt.Fprintf(stdout, "[Doctor] section %s: %s\n", config.section, config.edgeConf.Host)
+	return printDoctorStages(stdout, stages)
+}
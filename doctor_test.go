@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// traceDoer walks a request through the stages of its trace, taking a second each, and fails it at
+// failAt with err. It answers status after all stages
+type traceDoer struct {
+	clock  *fakeClock
+	failAt string
+	err    error
+	status int
+}
+
+func (d *traceDoer) Do(req *http.Request) (*http.Response, error) {
+	trace := httptrace.ContextClientTrace(req.Context())
+	tick := func() { d.clock.Sleep(req.Context(), time.Second) }
+	fail := func(stage string) error {
+		if d.failAt == stage {
+			return d.err
+		}
+		return nil
+	}
+
+	trace.DNSStart(httptrace.DNSStartInfo{Host: req.URL.Hostname()})
+	tick()
+	if err := fail("dns"); err != nil {
+		trace.DNSDone(httptrace.DNSDoneInfo{Err: err})
+		return nil, err
+	}
+	trace.DNSDone(httptrace.DNSDoneInfo{Addrs: []net.IPAddr{{IP: net.IPv4(192, 0, 2, 1)}}})
+	trace.ConnectStart("tcp", "192.0.2.1:443")
+	tick()
+	if err := fail("tcp"); err != nil {
+		trace.ConnectDone("tcp", "192.0.2.1:443", err)
+		return nil, err
+	}
+	trace.ConnectDone("tcp", "192.0.2.1:443", nil)
+	trace.TLSHandshakeStart()
+	tick()
+	if err := fail("tls"); err != nil {
+		trace.TLSHandshakeDone(tls.ConnectionState{}, err)
+		return nil, err
+	}
+	trace.TLSHandshakeDone(tls.ConnectionState{Version: tls.VersionTLS13}, nil)
+	trace.WroteRequest(httptrace.WroteRequestInfo{})
+	tick()
+	return &http.Response{StatusCode: d.status, Body: ioutil.NopCloser(strings.NewReader("{}"))}, nil
+}
+
+func TestCheckPurgeHost(t *testing.T) {
+	refused := errors.New("connection refused")
+	tests := []struct {
+		name    string
+		doer    *traceDoer
+		results string
+	}{
+		{"authenticated", &traceDoer{status: http.StatusBadRequest}, "OK OK OK OK"},
+		{"dns", &traceDoer{failAt: "dns", err: errors.New("no such host")}, "NG SKIP SKIP SKIP"},
+		{"tcp", &traceDoer{failAt: "tcp", err: refused}, "OK NG SKIP SKIP"},
+		{"tls", &traceDoer{failAt: "tls", err: errors.New("bad certificate")}, "OK OK NG SKIP"},
+		{"auth", &traceDoer{status: http.StatusUnauthorized}, "OK OK OK NG"},
+	}
+	for _, tt := range tests {
+		clock := &fakeClock{now: time.Now()}
+		tt.doer.clock = clock
+		config := &Config{client: tt.doer, clock: clock}
+		config.edgeConf.Host = "akab-xxxxxxxxxxxxxxxx-xxxxxxxxxxxxxxxx.purge.akamaiapis.net"
+		stages := checkPurgeHost(context.Background(), config)
+
+		var results []string
+		for _, s := range stages {
+			results = append(results, s.result())
+			if s.ran && s.err == nil && s.took != time.Second {
+				t.Errorf("%s: %s should take a second, got %s", tt.name, s.name, s.took)
+			}
+		}
+		if got := strings.Join(results, " "); got != tt.results {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.results, got)
+		}
+
+		var out bytes.Buffer
+		err := printDoctorStages(&out, stages)
+		if (err == nil) != (tt.name == "authenticated") {
+			t.Errorf("%s: only a failed stage should fail the check, got %v", tt.name, err)
+		}
+		if err != nil && !strings.HasPrefix(err.Error(), tt.name+" check failed") {
+			t.Errorf("%s: the error should tell the failed stage, got %v", tt.name, err)
+		}
+		if !strings.HasPrefix(out.String(), "STAGE") || strings.Count(out.String(), "\n") != len(doctorStages)+1 {
+			t.Errorf("%s: expected a table of stages, got\n%s", tt.name, out.String())
+		}
+	}
+}
+
+func TestRunDoctor(t *testing.T) {
+	ts, rec := newTestServer(http.StatusBadRequest)
+	defer ts.Close()
+	setEdgegridEnv(t)
+	defer unsetEdgegridEnv()
+	os.Setenv("AKAMAI_HOST", strings.TrimPrefix(ts.URL, "https://"))
+	defer log.SetLevel(log.Level)
+
+	var out bytes.Buffer
+	if err := run([]string{"doctor", "-insecure"}, &out); err != nil {
+		t.Fatalf("%s\n%s", err, out.String())
+	}
+	// The test server is an IP address, so there is no lookup
+	results := map[string]string{}
+	for _, line := range strings.Split(out.String(), "\n") {
+		if fields := strings.Fields(line); len(fields) >= 2 {
+			results[fields[0]] = fields[1]
+		}
+	}
+	for _, stage := range doctorStages {
+		if results[stage] != "OK" {
+			t.Errorf("expected %s to pass in\n%s", stage, out.String())
+		}
+	}
+	if got := rec.joinedBodies(); got != `{"objects":[]}` {
+		t.Errorf("expected no objects to be sent, got %s", got)
+	}
+
+	if got := exitCode(run([]string{"doctor", "extra"}, &bytes.Buffer{})); got != exitConfig {
+		t.Errorf("arguments should be a config error, got exit code %d", got)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// edgercDefaults are the method and network a section of the edgerc purges with unless -m or -n is given
+type edgercDefaults struct {
+	method  string
+	network string
+}
+
+// readEdgercSection reads raw keys of an edgerc section, including ones the edgegrid library ignores.
+// A missing section has no keys
+func readEdgercSection(path, section string) (map[string]string, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	keys := map[string]string{}
+	current := ""
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case len(line) == 0 || line[0] == '#' || line[0] == ';':
+		case line[0] == '[' && line[len(line)-1] == ']':
+			current = strings.TrimSpace(line[1 : len(line)-1])
+		case current == section:
+			i := strings.IndexAny(line, "=:")
+			if i <= 0 {
+				continue
+			}
+			key, value := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+			if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+				value = value[1 : len(value)-1]
+			}
+			keys[strings.ToLower(key)] = value
+		}
+	}
+	return keys, scanner.Err()
+}
+
+// applyEdgercDefaults takes the method and network from optional "method" and "network" keys of the
+// edgerc section, unless -m or -n is given explicitly
+func applyEdgercDefaults(config *Config) error {
+	if !config.sectionMethod && !config.sectionNetwork {
+		return nil
+	}
+	keys, err := readEdgercSection(config.edgerc, config.section)
+	if err != nil {
+		return fmt.Errorf("failed to read section %q from %s: %s", config.section, config.edgerc, err)
+	}
+	if method, ok := keys["method"]; ok && config.sectionMethod {
+		config.method = method
+	}
+	if network, ok := keys["network"]; ok && config.sectionNetwork {
+		config.network = network
+	}
+	return nil
+}
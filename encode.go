@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const defaultEncoding = "passthrough"
+
+// encodings are forms -encode can bring URLs and paths to before submitting. The cache key is of the
+// form the client requested, so objects of the other form purge nothing
+var encodings = []string{"passthrough", "encode", "decode"}
+
+// unsafeChars are printable ASCII characters which aren't allowed in URLs as they are, but for "%"
+// which starts an escape
+const unsafeChars = "\"<>\\^`{|}"
+
+// reservedChars delimit parts of URLs, so that "decode" keeps them escaped, e.g. "%2F" in a path
+// segment isn't a "/"
+const reservedChars = "!#$%&'()*+,/:;=?@[]"
+
+// encodeObject brings an object of kind to the form of mode: "passthrough" leaves it as it is, "encode"
+// percent-encodes unsafe and non-ASCII characters, keeping escapes already there, and "decode"
+// unescapes what needn't be escaped. Hosts of URLs are left alone, as are CP codes and cache tags
+func encodeObject(mode, kind, object string) (string, error) {
+	if mode != "encode" && mode != "decode" || (kind != "url" && kind != "path") {
+		return object, nil
+	}
+	prefix, rest := "", object
+	if kind == "url" {
+		if _, end, ok := urlHostSpan(object); ok {
+			prefix, rest = object[:end], object[end:]
+		}
+	}
+	if mode == "encode" {
+		return prefix + percentEncode(rest), nil
+	}
+	decoded, err := percentDecode(rest)
+	if err != nil {
+		return "", fmt.Errorf("%q %s", object, err)
+	}
+	return prefix + decoded, nil
+}
+
+// percentEncode escapes unsafe, control and non-ASCII bytes of s. A "%" starting a valid escape is kept,
+// any other one is escaped as "%25"
+func percentEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]):
+			b.WriteByte(c)
+		case c == '%' || c <= 0x20 || c >= 0x7f || strings.IndexByte(unsafeChars, c) >= 0:
+			fmt.Fprintf(&b, "%%%02X", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// percentDecode unescapes s but for escapes of reserved and control characters, which would change
+// what the URL means once unescaped
+func percentDecode(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+			end := i + 3
+			if end > len(s) {
+				end = len(s)
+			}
+			return "", fmt.Errorf("has an invalid escape %q", s[i:end])
+		}
+		c := unhex(s[i+1])<<4 | unhex(s[i+2])
+		if c < 0x20 || c == 0x7f || strings.IndexByte(reservedChars, c) >= 0 {
+			b.WriteString(strings.ToUpper(s[i : i+3]))
+		} else {
+			b.WriteByte(c)
+		}
+		i += 2
+	}
+	return b.String(), nil
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	}
+	return c - 'A' + 10
+}
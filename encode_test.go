@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestEncodeObject(t *testing.T) {
+	for _, tt := range []struct {
+		mode, kind, object, want string
+	}{
+		{"passthrough", "url", "https://example.com/a b%20c.html", "https://example.com/a b%20c.html"},
+		{"encode", "url", "https://example.com/a b%20c.html", "https://example.com/a%20b%20c.html"},
+		{"decode", "url", "https://example.com/a b%20c.html", "https://example.com/a b c.html"},
+
+		// A "%" not starting an escape is escaped itself, reserved characters are kept as they are
+		{"encode", "url", "https://example.com/100%.html?q=x y&r=1#top", "https://example.com/100%25.html?q=x%20y&r=1#top"},
+		{"encode", "path", "/日本.html", "/%E6%97%A5%E6%9C%AC.html"},
+		{"encode", "url", "https://例え.jp/{a}", "https://例え.jp/%7Ba%7D"},
+		{"decode", "path", "/%E6%97%A5%E6%9C%AC.html", "/日本.html"},
+		// Escapes of delimiters mean something else unescaped
+		{"decode", "url", "https://example.com/a%2fb%3Fc%25d%41", "https://example.com/a%2Fb%3Fc%25dA"},
+
+		{"encode", "tag", "a b", "a b"},
+		{"decode", "cpcode", "12%33", "12%33"},
+	} {
+		got, err := encodeObject(tt.mode, tt.kind, tt.object)
+		if err != nil || got != tt.want {
+			t.Errorf("%s %s %q: expected %q, got %q, %v", tt.mode, tt.kind, tt.object, tt.want, got, err)
+		}
+	}
+
+	if _, err := encodeObject("decode", "url", "https://example.com/100%.html"); err == nil || !strings.Contains(err.Error(), `"%.h"`) {
+		t.Errorf("expected an invalid escape to fail decoding, got %v", err)
+	}
+}
+
+func TestInvalidateByURLsEncode(t *testing.T) {
+	for mode, want := range map[string]string{
+		"passthrough": `"https://example.com/a b%20c"`,
+		"encode":      `"https://example.com/a%20b%20c"`,
+		"decode":      `"https://example.com/a b c"`,
+	} {
+		ts, rec := newTestServer(http.StatusCreated)
+		config := newTestConfig(ts)
+		config.encode = mode
+		if err := Invalidation(context.Background(), config, bytes.NewBufferString("https://example.com/a b%20c\n")); err != nil {
+			t.Fatalf("%s: %s", mode, err)
+		}
+		if body := rec.joinedBodies(); !strings.Contains(body, want) {
+			t.Errorf("%s: expected %s submitted, got %s", mode, want, body)
+		}
+		ts.Close()
+	}
+}
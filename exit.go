@@ -0,0 +1,48 @@
+package main
+
+import "errors"
+
+// Exit codes of the process
+const (
+	exitOK          = 0
+	exitFailed      = 2   // some purge requests failed
+	exitConfig      = 3   // invalid flags, credentials or input, nothing was submitted
+	exitInterrupted = 130 // stopped by SIGINT/SIGTERM
+)
+
+var (
+	errInterrupted = errors.New("interrupted")
+	errPurgeFailed = errors.New("some purge requests failed")
+	errDeadline    = errors.New("-deadline exceeded, some objects may not be purged")
+
+	errNothingToPurge = errors.New("nothing to purge, the input has no valid objects")
+)
+
+// exitError is an error carrying the exit code it should end the process with
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+// configError marks err as a configuration error, returned before anything is submitted
+func configError(err error) error {
+	return &exitError{code: exitConfig, err: err}
+}
+
+// exitCode maps an error returned by run to the exit code of the process
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	if err == errInterrupted {
+		return exitInterrupted
+	}
+	if e, ok := err.(*exitError); ok {
+		return e.code
+	}
+	return exitFailed
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{nil, exitOK},
+		{errPurgeFailed, exitFailed},
+		{errDeadline, exitFailed},
+		{errors.New("reading input failed"), exitFailed},
+		{configError(errors.New("invalid method")), exitConfig},
+		{errInterrupted, exitInterrupted},
+	}
+	for _, tt := range tests {
+		if got := exitCode(tt.err); got != tt.want {
+			t.Errorf("exitCode(%v): expected %d, got %d", tt.err, tt.want, got)
+		}
+	}
+}
+
+func TestRunConfigError(t *testing.T) {
+	setEdgegridEnv(t)
+	defer unsetEdgegridEnv()
+	defer log.SetLevel(log.Level)
+
+	for _, args := range [][]string{
+		{"-no-such-flag"},
+		{"-m", "foo"},
+		{"-l", "verbose"},
+		{"-c", invalidInvalidationRequestFile},
+	} {
+		if args[0] == "-c" {
+			unsetEdgegridEnv()
+		}
+		if got := exitCode(run(args, &bytes.Buffer{})); got != exitConfig {
+			t.Errorf("%v: expected exit code %d, got %d", args, exitConfig, got)
+		}
+	}
+}
+
+func TestRunNothingToPurge(t *testing.T) {
+	ts, rec := newTestServer(http.StatusCreated)
+	defer ts.Close()
+	setEdgegridEnv(t)
+	defer unsetEdgegridEnv()
+	os.Setenv("AKAMAI_HOST", strings.TrimPrefix(ts.URL, "https://"))
+	defer log.SetLevel(log.Level)
+
+	dir, err := ioutil.TempDir("", "purge-empty")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		input string
+		want  int
+	}{
+		{"", exitConfig},
+		{"\n\n  \n", exitConfig},
+		{"not a url\n/relative/path\n", exitConfig},
+		{"https://example.com/a\n", exitOK},
+	}
+	for i, tt := range tests {
+		path := filepath.Join(dir, strconv.Itoa(i)+".txt")
+		if err := ioutil.WriteFile(path, []byte(tt.input), 0644); err != nil {
+			t.Fatalf("%s", err)
+		}
+		if got := exitCode(run([]string{"-insecure", path}, &bytes.Buffer{})); got != tt.want {
+			t.Errorf("%q: expected exit code %d, got %d", tt.input, tt.want, got)
+		}
+	}
+	if got := exitCode(run([]string{"-insecure", "-url", ""}, &bytes.Buffer{})); got != exitConfig {
+		t.Errorf("an empty -url: expected exit code %d, got %d", exitConfig, got)
+	}
+	if n := rec.count(); n != 1 {
+		t.Errorf("only the valid input should be submitted, got %d requests", n)
+	}
+}
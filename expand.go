@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// expandLine returns objects of a line of the list, which is the line itself unless -expand is given
+func (config *Config) expandLine(line string) ([]string, error) {
+	if !config.expand {
+		return []string{line}, nil
+	}
+	objects, err := expandBraces(line, config.objectLimit())
+	if err != nil {
+		return nil, fmt.Errorf("%q %s", line, err)
+	}
+	return objects, nil
+}
+
+// expandBraces expands numeric ranges like {1..100} and sets like {a,b,c} of s as a shell does, e.g.
+// "/img/{1..2}.{jpg,png}" into "/img/1.jpg", "/img/1.png", "/img/2.jpg" and "/img/2.png". Sets can nest,
+// and a range keeps zero padding like {01..10}. Braces that are neither stay as they are.
+// It fails rather than generating more than limit objects
+func expandBraces(s string, limit int) ([]string, error) {
+	for i := strings.IndexByte(s, '{'); i >= 0; i = nextBrace(s, i) {
+		j := matchingBrace(s, i)
+		if j < 0 {
+			break
+		}
+		alternatives, ok, err := braceAlternatives(s[i+1:j], limit)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		suffixes, err := expandBraces(s[j+1:], limit)
+		if err != nil {
+			return nil, err
+		}
+		if len(alternatives)*len(suffixes) > limit {
+			return nil, errTooManyObjects(limit)
+		}
+		expanded := make([]string, 0, len(alternatives)*len(suffixes))
+		for _, a := range alternatives {
+			for _, suffix := range suffixes {
+				expanded = append(expanded, s[:i]+a+suffix)
+			}
+		}
+		return expanded, nil
+	}
+	return []string{s}, nil
+}
+
+func errTooManyObjects(limit int) error {
+	return fmt.Errorf("expands to more than %d objects of -max-objects", limit)
+}
+
+// nextBrace returns the index of "{" after i, or -1
+func nextBrace(s string, i int) int {
+	if j := strings.IndexByte(s[i+1:], '{'); j >= 0 {
+		return i + 1 + j
+	}
+	return -1
+}
+
+// matchingBrace returns the index of "}" closing "{" at i, or -1
+func matchingBrace(s string, i int) int {
+	depth := 0
+	for j := i; j < len(s); j++ {
+		switch s[j] {
+		case '{':
+			depth++
+		case '}':
+			if depth--; depth == 0 {
+				return j
+			}
+		}
+	}
+	return -1
+}
+
+// braceAlternatives expands the inside of braces, reporting false when it is neither a range nor a set
+func braceAlternatives(inside string, limit int) (alternatives []string, ok bool, err error) {
+	if i := strings.Index(inside, ".."); i >= 0 {
+		if numbers, ok, err := numericRange(inside[:i], inside[i+2:], limit); ok || err != nil {
+			return numbers, ok, err
+		}
+	}
+
+	var items []string
+	depth, begin := 0, 0
+	for j := 0; j < len(inside); j++ {
+		switch inside[j] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				items = append(items, inside[begin:j])
+				begin = j + 1
+			}
+		}
+	}
+	if len(items) == 0 {
+		return nil, false, nil
+	}
+	items = append(items, inside[begin:])
+	for _, item := range items {
+		expanded, err := expandBraces(item, limit)
+		if err != nil {
+			return nil, false, err
+		}
+		if len(alternatives)+len(expanded) > limit {
+			return nil, false, errTooManyObjects(limit)
+		}
+		alternatives = append(alternatives, expanded...)
+	}
+	return alternatives, true, nil
+}
+
+// numericRange returns numbers from start to end inclusive, descending when end is smaller
+func numericRange(start, end string, limit int) ([]string, bool, error) {
+	from, err := strconv.Atoi(start)
+	if err != nil {
+		return nil, false, nil
+	}
+	to, err := strconv.Atoi(end)
+	if err != nil {
+		return nil, false, nil
+	}
+	// The span is unsigned, so that ranges up to the ends of int don't overflow
+	step, span := 1, uint64(to)-uint64(from)
+	if to < from {
+		step, span = -1, uint64(from)-uint64(to)
+	}
+	if span >= uint64(limit) {
+		return nil, false, errTooManyObjects(limit)
+	}
+	count := int(span) + 1
+	// Zero padding like {01..10} keeps the width of the wider end
+	width := 0
+	if zeroPadded(start) || zeroPadded(end) {
+		width = len(strings.TrimPrefix(start, "-"))
+		if w := len(strings.TrimPrefix(end, "-")); w > width {
+			width = w
+		}
+	}
+	numbers := make([]string, 0, count)
+	for n := from; ; n += step {
+		numbers = append(numbers, fmt.Sprintf("%0*d", width, n))
+		if n == to {
+			break
+		}
+	}
+	return numbers, true, nil
+}
+
+// zeroPadded reports whether a number is written with leading zeros like "01"
+func zeroPadded(number string) bool {
+	number = strings.TrimPrefix(number, "-")
+	return len(number) > 1 && number[0] == '0'
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestExpandBraces(t *testing.T) {
+	tests := map[string][]string{
+		"https://example.com/a":           {"https://example.com/a"},
+		"/img/{1..3}.jpg":                 {"/img/1.jpg", "/img/2.jpg", "/img/3.jpg"},
+		"/img/{3..1}.jpg":                 {"/img/3.jpg", "/img/2.jpg", "/img/1.jpg"},
+		"/img/{08..10}.jpg":               {"/img/08.jpg", "/img/09.jpg", "/img/10.jpg"},
+		"/{a,b,c}":                        {"/a", "/b", "/c"},
+		"/{1..2}.{jpg,png}":               {"/1.jpg", "/1.png", "/2.jpg", "/2.png"},
+		"/{a,b{1..2}}/x":                  {"/a/x", "/b1/x", "/b2/x"},
+		"/{css,js/{app,vendor}}.min":      {"/css.min", "/js/app.min", "/js/vendor.min"},
+		"/{x{1..2}}":                      {"/{x1}", "/{x2}"},
+		"/page?q={literal}":               {"/page?q={literal}"},
+		"/{a..b}/{unclosed":               {"/{a..b}/{unclosed"},
+		"/{,min.}js":                      {"/js", "/min.js"},
+		"https://{www,img}.example.com/a": {"https://www.example.com/a", "https://img.example.com/a"},
+	}
+	for s, want := range tests {
+		got, err := expandBraces(s, 10)
+		if err != nil {
+			t.Errorf("%s: %s", s, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected %q, got %q", s, want, got)
+		}
+	}
+
+	// Ranges at the ends of int
+	if got, err := expandBraces("/{9223372036854775806..9223372036854775807}", 10); err != nil || !reflect.DeepEqual(got, []string{"/9223372036854775806", "/9223372036854775807"}) {
+		t.Errorf("expected the range up to the largest int, got %q and %v", got, err)
+	}
+
+	// Exploding expansions fail before generating them
+	for _, s := range []string{"/{1..11}", "/{1..4}/{1..3}", "/{a,b,c,d,e,f,g,h,i,j,k}", "/{{1..6},{1..6}}", "/{1..1000000000}{1..1000000000}",
+		// Counts of these overflow int
+		"/{-5000000000000000000..5000000000000000000}", "/{5000000000000000000..-5000000000000000000}",
+		"/{-9223372036854775808..9223372036854775807}", "/{9223372036854775807..-9223372036854775808}"} {
+		if got, err := expandBraces(s, 10); err == nil {
+			t.Errorf("%s should exceed the limit, got %d objects", s, len(got))
+		}
+	}
+}
+
+func TestInvalidateByURLsExpand(t *testing.T) {
+	ts, rec := newTestServer(http.StatusCreated)
+	defer ts.Close()
+
+	config := newTestConfig(ts)
+	config.expand = true
+	config.maxObjects = 4
+	in := "https://example.com/{a,b}/{1..2}.jpg\nhttps://example.com/{1..5}\nhttps://example.com/c\n"
+	if err := Invalidation(context.Background(), config, strings.NewReader(in)); err != nil {
+		t.Fatal(err)
+	}
+	// The line exceeding -max-objects is skipped, the others are chunked as usual
+	want := []string{
+		`{"objects":["https://example.com/a/1.jpg","https://example.com/a/2.jpg","https://example.com/b/1.jpg","https://example.com/b/2.jpg"]}`,
+		`{"objects":["https://example.com/c"]}`,
+	}
+	// Requests are sent concurrently, so they arrive in any order
+	got := strings.Split(rec.joinedBodies(), "\n")
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+
+	config.strict = true
+	if err := Invalidation(context.Background(), config, strings.NewReader(in)); err == nil {
+		t.Errorf("an exploding line should fail under -strict")
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// explainer describes request bodies of -explain instead of sending them, showing how the input is
+// split by -max-body-size and -max-objects. A nil *explainer describes nothing
+type explainer struct {
+	mu        sync.Mutex
+	out       io.Writer
+	chunks    int
+	objects   int
+	largest   int      // bytes of the largest body
+	countOnly bool     // of -preview-count, counting chunks of lists without building their bodies
+	sampler   *sampler // of -sample, validating a fraction of objects instead of splitting them
+}
+
+func newExplainer(out io.Writer) *explainer {
+	return &explainer{out: out}
+}
+
+// counting reports whether chunks are only counted, so that lists needn't be marshaled into bodies
+func (e *explainer) counting() bool {
+	return e != nil && e.countOnly
+}
+
+// count adds a chunk of objects, size bytes as a body, to the total
+func (e *explainer) count(objects, size int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.chunks++
+	e.objects += objects
+	if size > e.largest {
+		e.largest = size
+	}
+}
+
+// describe writes the index, size, object count and first and last objects of a request body
+func (e *explainer) describe(body []byte) {
+	if e.countOnly {
+		e.count(countObjects(body), len(body))
+		return
+	}
+	objects := bodyObjects(body)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.chunks++
+	e.objects += len(objects)
+	if len(body) > e.largest {
+		e.largest = len(body)
+	}
+	if len(objects) == 0 {
+		fmt.Fprintf(e.out, "chunk %d: %d bytes, 0 objects\n", e.chunks, len(body))
+		return
+	}
+	fmt.Fprintf(e.out, "chunk %d: %d bytes, %d objects, first: %s, last: %s\n",
+		e.chunks, len(body), len(objects), objects[0], objects[len(objects)-1])
+}
+
+// finish writes the total of the chunks described, or the report of -sample
+func (e *explainer) finish() {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.sampler != nil {
+		fmt.Fprintln(e.out, e.sampler)
+		return
+	}
+	if e.countOnly {
+		fmt.Fprintf(e.out, "requests: %d, objects: %d, largest request: %d bytes, nothing was sent\n", e.chunks, e.objects, e.largest)
+		return
+	}
+	fmt.Fprintf(e.out, "%d chunks, %d objects, nothing was sent\n", e.chunks, e.objects)
+}
+
+// submit sends a request body in a goroutine added to wg, or only describes it under -explain
+func submit(ctx context.Context, config *Config, body []byte, wg *sync.WaitGroup) error {
+	if config.explainer != nil {
+		config.explainer.describe(body)
+		return nil
+	}
+	if err := config.inFlightBytes.acquire(ctx, len(body)); err != nil {
+		return err
+	}
+	config = config.withChunkIndex()
+	wg.Add(1)
+	go func() {
+		defer config.inFlightBytes.release(len(body))
+		invalidationRequest(ctx, config, body, wg)
+	}()
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	ts, rec := newTestServer(http.StatusCreated)
+	defer ts.Close()
+
+	var out bytes.Buffer
+	config := newTestConfig(ts)
+	config.maxObjects = 2
+	config.explainer = newExplainer(&out)
+	in := "https://example.com/a\nhttps://example.com/b\nhttps://example.com/c\nhttps://example.com/d\nhttps://example.com/e\n"
+	if err := Invalidation(context.Background(), config, strings.NewReader(in)); err != nil {
+		t.Fatal(err)
+	}
+	config.explainer.finish()
+
+	want := "chunk 1: 61 bytes, 2 objects, first: https://example.com/a, last: https://example.com/b\n" +
+		"chunk 2: 61 bytes, 2 objects, first: https://example.com/c, last: https://example.com/d\n" +
+		"chunk 3: 37 bytes, 1 objects, first: https://example.com/e, last: https://example.com/e\n" +
+		"3 chunks, 5 objects, nothing was sent\n"
+	if out.String() != want {
+		t.Errorf("expected the breakdown\n%s\ngot\n%s", want, out.String())
+	}
+	if n := rec.count(); n != 0 {
+		t.Errorf("-explain should send nothing, but %d requests were sent", n)
+	}
+}
+
+func TestPreviewCount(t *testing.T) {
+	ts, rec := newTestServer(http.StatusCreated)
+	defer ts.Close()
+
+	var out bytes.Buffer
+	config := newTestConfig(ts)
+	config.maxObjects = 2
+	config.explainer = newExplainer(&out)
+	config.explainer.countOnly = true
+	in := "https://example.com/a\nhttps://example.com/b\nhttps://example.com/c\nhttps://example.com/d\nhttps://example.com/long\n"
+	if err := Invalidation(context.Background(), config, strings.NewReader(in)); err != nil {
+		t.Fatal(err)
+	}
+	config.explainer.finish()
+
+	// The largest is of 2 objects, 61 bytes like in TestExplain
+	want := "requests: 3, objects: 5, largest request: 61 bytes, nothing was sent\n"
+	if out.String() != want {
+		t.Errorf("expected %q, got %q", want, out.String())
+	}
+	if n := rec.count(); n != 0 {
+		t.Errorf("-preview-count should send nothing, but %d requests were sent", n)
+	}
+
+	// JSON bodies are counted as they are built
+	out.Reset()
+	config.fileType = "json"
+	config.explainer = newExplainer(&out)
+	config.explainer.countOnly = true
+	if err := Invalidation(context.Background(), config, strings.NewReader(`{"objects":["https://example.com/a"]}`)); err != nil {
+		t.Fatal(err)
+	}
+	config.explainer.finish()
+	if want := "requests: 1, objects: 1, largest request: 37 bytes, nothing was sent\n"; out.String() != want {
+		t.Errorf("expected %q, got %q", want, out.String())
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	edgegrid "github.com/akamai-open/AkamaiOPEN-edgegrid-golang"
+)
+
+// authDump is what -explain-auth writes of a signed request: inputs of its EdgeGrid signature as
+// https://techdocs.akamai.com/developer/docs/authenticate-with-edgegrid describes, with tokens masked,
+// in data to sign too, and neither the client secret nor the signature itself
+type authDump struct {
+	Timestamp     string `json:"timestamp"`
+	Nonce         string `json:"nonce"`
+	ClientToken   string `json:"client_token"`
+	AccessToken   string `json:"access_token"`
+	Method        string `json:"method"`
+	Scheme        string `json:"scheme"`
+	Host          string `json:"host"`
+	RelativeURL   string `json:"relative_url"`
+	SignedHeaders string `json:"signed_headers"`
+	ContentHash   string `json:"content_hash"`
+	DataToSign    string `json:"data_to_sign"`
+	LocalTime     string `json:"local_time"`
+	ServerTime    string `json:"server_time,omitempty"` // by Date of the response
+	ClockSkew     string `json:"clock_skew,omitempty"`  // of the local clock behind the server, negative when ahead
+	StatusCode    int    `json:"status_code,omitempty"`
+}
+
+// authExplainer writes an authDump of the first request of a run for -explain-auth, so that a rejected
+// signature can be told from clock skew or headers signed differently. A nil *authExplainer writes nothing
+type authExplainer struct {
+	once sync.Once
+	out  io.Writer
+}
+
+func newAuthExplainer(out io.Writer) *authExplainer {
+	return &authExplainer{out: out}
+}
+
+// explain writes the dump of req signed with edgeConf and sent with body, and of resp if one was received
+func (e *authExplainer) explain(edgeConf edgegrid.Config, req *http.Request, body []byte, resp *http.Response, now time.Time) {
+	if e == nil {
+		return
+	}
+	e.once.Do(func() {
+		dump := newAuthDump(edgeConf, req, body, now)
+		if resp != nil {
+			dump.StatusCode = resp.StatusCode
+			if date, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+				dump.ServerTime = date.UTC().Format(time.RFC3339)
+				// Date is of seconds, so is the skew
+				dump.ClockSkew = date.Sub(now).Round(time.Second).String()
+			}
+		}
+		b, err := json.Marshal(dump)
+		if err != nil {
+			log.Errorf("failed to write -explain-auth: %s", err)
+			return
+		}
+		fmt.Fprintf(e.out, "[Auth] %s\n", b)
+	})
+}
+
+// newAuthDump rebuilds inputs of the signature of req from its Authorization header
+func newAuthDump(edgeConf edgegrid.Config, req *http.Request, body []byte, now time.Time) authDump {
+	fields := map[string]string{}
+	auth := req.Header.Get("Authorization")
+	if i := strings.IndexByte(auth, ' '); i >= 0 {
+		for _, field := range strings.Split(auth[i+1:], ";") {
+			if j := strings.IndexByte(field, '='); j > 0 {
+				fields[field[:j]] = field[j+1:]
+			}
+		}
+	}
+	dump := authDump{
+		Timestamp:     fields["timestamp"],
+		Nonce:         fields["nonce"],
+		ClientToken:   maskToken(fields["client_token"]),
+		AccessToken:   maskToken(fields["access_token"]),
+		Method:        req.Method,
+		Scheme:        req.URL.Scheme,
+		Host:          req.URL.Host,
+		RelativeURL:   req.URL.RequestURI(),
+		SignedHeaders: canonicalHeaders(edgeConf.HeaderToSign, req.Header),
+		ContentHash:   contentHash(req.Method, body, edgeConf.MaxBody),
+		LocalTime:     now.UTC().Format(time.RFC3339),
+	}
+	// The auth header is signed up to the signature, with the tokens masked here as above
+	authHeader := fmt.Sprintf("EG1-HMAC-SHA256 client_token=%s;access_token=%s;timestamp=%s;nonce=%s;",
+		dump.ClientToken, dump.AccessToken, dump.Timestamp, dump.Nonce)
+	dump.DataToSign = strings.Join([]string{
+		dump.Method, dump.Scheme, dump.Host, dump.RelativeURL, dump.SignedHeaders, dump.ContentHash, authHeader,
+	}, "\t")
+	return dump
+}
+
+// maskToken keeps the prefix of a client or access token like "akab-xxxx", which tells which API client
+// it is, and masks the rest
+func maskToken(token string) string {
+	const visible = 9
+	if len(token) <= visible {
+		return strings.Repeat("*", len(token))
+	}
+	return token[:visible] + strings.Repeat("*", len(token)-visible)
+}
+
+// canonicalHeaders joins headers of names of headers_to_sign of the edgerc as EdgeGrid signs them:
+// "name:value" with the name lowercased and whitespace of the value collapsed, separated by tabs
+func canonicalHeaders(names []string, header http.Header) string {
+	var canonical []string
+	for _, name := range names {
+		value := strings.Join(strings.Fields(header.Get(name)), " ")
+		if len(value) > 0 {
+			canonical = append(canonical, strings.ToLower(name)+":"+value)
+		}
+	}
+	return strings.Join(canonical, "\t")
+}
+
+// contentHash is the base64 SHA-256 of up to maxBody bytes of the body of a POST, empty for other methods
+func contentHash(method string, body []byte, maxBody int) string {
+	if method != http.MethodPost || len(body) == 0 {
+		return ""
+	}
+	if maxBody <= 0 {
+		maxBody = defaultEdgegridMaxBody
+	}
+	if len(body) > maxBody {
+		body = body[:maxBody]
+	}
+	sum := sha256.Sum256(body)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
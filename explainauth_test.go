@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestExplainAuth(t *testing.T) {
+	ts, _ := newTestServer(http.StatusCreated, http.StatusCreated)
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	config := newTestConfig(ts)
+	config.edgeConf.ClientToken = "akab-clienttoken-0123456789"
+	config.edgeConf.AccessToken = "akab-accesstoken-0123456789"
+	config.edgeConf.ClientSecret = "c2VjcmV0LW9mLXRoZS1jbGllbnQ="
+	config.authExplainer = newAuthExplainer(&buf)
+	sendTestRequest(config)
+	sendTestRequest(config)
+
+	out := buf.String()
+	if n := strings.Count(out, "[Auth] "); n != 1 {
+		t.Fatalf("the first request only should be explained, got %d in %q", n, out)
+	}
+	for _, secret := range []string{config.edgeConf.ClientSecret, config.edgeConf.ClientToken, config.edgeConf.AccessToken, "signature="} {
+		if strings.Contains(out, secret) {
+			t.Errorf("%q shouldn't be written, got %q", secret, out)
+		}
+	}
+
+	var dump map[string]interface{}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(strings.TrimSpace(out), "[Auth] ")), &dump); err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"timestamp", "nonce", "client_token", "access_token", "relative_url", "content_hash", "data_to_sign", "local_time"} {
+		if s, _ := dump[field].(string); len(s) == 0 {
+			t.Errorf("%s should be written, got %q", field, out)
+		}
+	}
+	if got := dump["relative_url"]; got != "/ccu/v3/invalidate/url/staging" {
+		t.Errorf("expected relative_url /ccu/v3/invalidate/url/staging, got %v", got)
+	}
+	if got := dump["client_token"]; got != "akab-clie******************" {
+		t.Errorf("expected the client token masked, got %v", got)
+	}
+	if got, _ := dump["data_to_sign"].(string); !strings.HasPrefix(got, "POST\thttps\t") {
+		t.Errorf("expected data to sign of the POST, got %q", got)
+	}
+	if got := dump["status_code"]; got != float64(http.StatusCreated) {
+		t.Errorf("expected status_code 201, got %v", got)
+	}
+}
+
+func TestCanonicalHeaders(t *testing.T) {
+	header := http.Header{}
+	header.Set("X-A", "  one   two ")
+	header.Set("X-B", "three")
+	if got := canonicalHeaders([]string{"X-A", "X-Missing", "X-B"}, header); got != "x-a:one two\tx-b:three" {
+		t.Errorf("unexpected canonical headers %q", got)
+	}
+}
+
+func TestContentHash(t *testing.T) {
+	if got := contentHash(http.MethodGet, []byte("body"), 0); got != "" {
+		t.Errorf("GET shouldn't have a content hash, got %q", got)
+	}
+	if contentHash(http.MethodPost, []byte("body"), 0) == "" {
+		t.Error("POST should have a content hash")
+	}
+	if contentHash(http.MethodPost, []byte("body"), 2) != contentHash(http.MethodPost, []byte("bo"), 0) {
+		t.Error("the content hash should cover max_body bytes")
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// halter cancels a run on its first failed request under -fail-fast, so that nothing more is queued or
+// retried. Requests already in flight are left to finish. A nil *halter never cancels
+type halter struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	err    error // of the first failure
+}
+
+func newHalter(cancel context.CancelFunc) *halter {
+	return &halter{cancel: cancel}
+}
+
+// fail cancels the run unless a failure did already
+func (h *halter) fail(result PurgeResult) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.err != nil {
+		return
+	}
+	h.err = fmt.Errorf("-fail-fast: request_id: %s failed: %s", result.RequestID, result.Error)
+	h.cancel()
+}
+
+// failure returns the error of the first failure, nil when there is none
+func (h *halter) failure() error {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.err
+}
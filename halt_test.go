@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestFailFast(t *testing.T) {
+	ts, rec := newTestServer(http.StatusCreated, http.StatusBadRequest, http.StatusCreated)
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	config := newTestConfig(ts)
+	config.maxObjects = 1
+	config.retryOn = statusSet{}
+	// Space requests so that the failure is seen before the rest are sent
+	config.limiter = newRateLimiter(10)
+	config.halt = newHalter(cancel)
+	config.tally = &Results{}
+
+	in := strings.Repeat("https://example.com/a\n", 10)
+	Invalidation(ctx, config, strings.NewReader(in))
+	if n := rec.count(); n != 2 {
+		t.Errorf("requests after the first failure should be cancelled, but %d were sent", n)
+	}
+	if err := config.halt.failure(); err == nil || !strings.Contains(err.Error(), "-fail-fast") {
+		t.Errorf("expected the error of the first failure, got %v", err)
+	}
+	if ctx.Err() == nil {
+		t.Errorf("the run should be cancelled")
+	}
+
+	// Best effort without -fail-fast
+	ts2, rec2 := newTestServer(http.StatusCreated, http.StatusBadRequest, http.StatusCreated)
+	defer ts2.Close()
+	config = newTestConfig(ts2)
+	config.maxObjects = 1
+	config.retryOn = statusSet{}
+	if err := Invalidation(context.Background(), config, strings.NewReader(in)); err != nil {
+		t.Fatal(err)
+	}
+	if n := rec2.count(); n != 10 {
+		t.Errorf("every request should be sent without -fail-fast, got %d", n)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// reservedHeaders are set by the client itself, -header must not clobber them
+var reservedHeaders = map[string]bool{
+	"Authorization":  true,
+	"Host":           true,
+	"Content-Type":   true,
+	"Content-Length": true,
+}
+
+// headerList is a repeatable flag of extra request headers given as "Key: Value"
+type headerList http.Header
+
+func (list headerList) String() string {
+	var headers []string
+	for key, values := range list {
+		for _, value := range values {
+			headers = append(headers, key+": "+value)
+		}
+	}
+	sort.Strings(headers)
+	return strings.Join(headers, ", ")
+}
+
+func (list *headerList) Set(value string) error {
+	i := strings.Index(value, ":")
+	if i < 0 {
+		return fmt.Errorf("%q is not a header, it should be \"Key: Value\"", value)
+	}
+	key, val := strings.TrimSpace(value[:i]), strings.TrimSpace(value[i+1:])
+	if !validHeaderName(key) {
+		return fmt.Errorf("%q is not a valid header name", key)
+	}
+	if strings.ContainsAny(val, "\r\n") {
+		return fmt.Errorf("the value of header %s should not contain line breaks", key)
+	}
+	key = http.CanonicalHeaderKey(key)
+	if reservedHeaders[key] {
+		return fmt.Errorf("header %s is set by the client, it can't be given by -header", key)
+	}
+	if *list == nil {
+		*list = headerList{}
+	}
+	http.Header(*list).Add(key, val)
+	return nil
+}
+
+// validHeaderName reports whether name is an RFC 7230 token
+func validHeaderName(name string) bool {
+	if len(name) == 0 {
+		return false
+	}
+	for _, c := range name {
+		if c > 0x7e || c <= ' ' || strings.ContainsRune("\"(),/:;<=>?@[\\]{}", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// apply adds the headers to req, keeping headers already set on it
+func (list headerList) apply(req *http.Request) {
+	for key, values := range list {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestHeaderList(t *testing.T) {
+	var list headerList
+	for _, value := range []string{"X-Purge-Correlation-ID: abc-123", "x-team:cdn", "X-Team: web "} {
+		if err := list.Set(value); err != nil {
+			t.Fatalf("%q: %s", value, err)
+		}
+	}
+	if got := list.String(); got != "X-Purge-Correlation-Id: abc-123, X-Team: cdn, X-Team: web" {
+		t.Errorf("unexpected headers: %q", got)
+	}
+
+	for _, value := range []string{"X-No-Colon", ": empty name", "X Space: a", "X-Break: a\r\nHost: evil", "Authorization: EG1-HMAC-SHA256", "host: example.com", "Content-Type: text/plain"} {
+		var list headerList
+		if err := list.Set(value); err == nil {
+			t.Errorf("%q should be rejected", value)
+		}
+	}
+}
+
+func TestInvalidationRequestHeaders(t *testing.T) {
+	ts, rec := newTestServer(http.StatusCreated)
+	defer ts.Close()
+
+	config := newTestConfig(ts)
+	config.headers.Set("X-Purge-Correlation-ID: abc-123")
+	sendTestRequest(config)
+	if rec.count() != 1 {
+		t.Fatalf("expected a request, got %d", rec.count())
+	}
+	req := rec.requests[0]
+	if got := req.Header.Get("X-Purge-Correlation-ID"); got != "abc-123" {
+		t.Errorf("expected the custom header on the request, got %q", got)
+	}
+	if len(req.Header.Get("Authorization")) == 0 {
+		t.Errorf("custom headers should not drop the Authorization header")
+	}
+}
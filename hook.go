@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// shellCommand runs command through the shell, so that hooks can be pipelines like of a CI config
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
+
+// runHook runs -before-cmd or -after-cmd given by flag, reading stdin if any. Its output goes to out
+// and stderr, away from results on stdout
+func runHook(ctx context.Context, flag, command string, stdin io.Reader, out io.Writer) error {
+	cmd := shellCommand(ctx, command)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdin, out, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s %q failed: %s", flag, command, err)
+	}
+	return nil
+}
+
+// runAfterHook runs -after-cmd with the summary as JSON on its stdin. The purge is over by then, so its
+// failure is only warned about
+func runAfterHook(command string, summary Summary, out io.Writer) {
+	if len(command) == 0 {
+		return
+	}
+	data, err := json.Marshal(summary)
+	if err != nil {
+		log.Warn(err)
+		return
+	}
+	if err := runHook(context.Background(), "-after-cmd", command, bytes.NewReader(append(data, '\n')), out); err != nil {
+		log.Warn(err)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestRunHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks of the test are sh scripts")
+	}
+	ts, rec := newTestServer(http.StatusCreated)
+	defer ts.Close()
+	setEdgegridEnv(t)
+	defer unsetEdgegridEnv()
+	os.Setenv("AKAMAI_HOST", strings.TrimPrefix(ts.URL, "https://"))
+	defer log.SetLevel(log.Level)
+
+	dir, err := ioutil.TempDir("", "purge-hook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	before, after := filepath.Join(dir, "before"), filepath.Join(dir, "summary.json")
+
+	args := []string{"-insecure", "-url", "https://example.com/a",
+		"-before-cmd", "touch " + before, "-after-cmd", "cat > " + after}
+	if err := run(args, &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(before); err != nil {
+		t.Errorf("-before-cmd should run: %s", err)
+	}
+	data, err := ioutil.ReadFile(after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var summary Summary
+	if err := json.Unmarshal(data, &summary); err != nil {
+		t.Fatalf("-after-cmd should read the summary as JSON: %s: %q", err, data)
+	}
+	if summary.Requests != 1 || summary.PurgedObjects != 1 {
+		t.Errorf("expected the summary of the purge, got %+v", summary)
+	}
+
+	// A failed -before-cmd aborts the purge
+	err = run([]string{"-insecure", "-url", "https://example.com/a", "-before-cmd", "exit 3"}, &bytes.Buffer{})
+	if exitCode(err) != exitConfig || !strings.Contains(err.Error(), "-before-cmd") {
+		t.Errorf("expected a config error of -before-cmd, got %v", err)
+	}
+	if n := rec.count(); n != 1 {
+		t.Errorf("nothing should be purged after -before-cmd failed, got %d requests", n)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// hostRetries caps retries per API host under -max-retries-per-host, so that a failing host of one
+// section doesn't hold up the run while others purge. Once a host used up its retries, further
+// attempts to it fail fast. Failed requests are counted per host for the summary. It is shared by
+// all targets. A nil *hostRetries caps nothing
+type hostRetries struct {
+	mu       sync.Mutex
+	limit    int // 0 means unlimited
+	retries  map[string]int
+	failures map[string]int
+}
+
+func newHostRetries(limit int) *hostRetries {
+	return &hostRetries{limit: limit, retries: map[string]int{}, failures: map[string]int{}}
+}
+
+// allow reports an error when attempts to host should stop. A retry is counted first
+func (h *hostRetries) allow(host string, retry bool) error {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.failures[host]; !ok {
+		h.failures[host] = 0
+	}
+	if retry {
+		h.retries[host]++
+	}
+	if h.limit > 0 && h.retries[host] > h.limit {
+		return fmt.Errorf("-max-retries-per-host %d of %s are used up", h.limit, host)
+	}
+	return nil
+}
+
+// fail counts a failed request to host
+func (h *hostRetries) fail(host string) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.failures[host]++
+}
+
+// hostFailures returns failed requests per host, nil unless requests went to several hosts
+func (h *hostRetries) hostFailures() map[string]int {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.failures) <= 1 {
+		return nil
+	}
+	failures := make(map[string]int, len(h.failures))
+	for host, n := range h.failures {
+		failures[host] = n
+	}
+	return failures
+}
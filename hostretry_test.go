@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	edgegrid "github.com/akamai-open/AkamaiOPEN-edgegrid-golang"
+)
+
+func TestMaxRetriesPerHost(t *testing.T) {
+	failing, failed := newTestServer(http.StatusServiceUnavailable)
+	defer failing.Close()
+	healthy, purged := newTestServer(http.StatusServiceUnavailable, http.StatusCreated)
+	defer healthy.Close()
+
+	config := newTestConfig(failing)
+	config.clock = &fakeClock{}
+	config.maxObjects = 1
+	config.hostRetries = newHostRetries(3)
+	healthyConf := config.edgeConf
+	healthyConf.Host = strings.TrimPrefix(healthy.URL, "https://")
+	config.section = "failing,healthy"
+	config.edgeConfs = []edgegrid.Config{config.edgeConf, healthyConf}
+	targets := sectionTargets(config)
+
+	input := "https://example.com/a\nhttps://example.com/b\nhttps://example.com/c\n"
+	if err := invalidateTargets(context.Background(), targets, nil, strings.NewReader(input)); err != nil {
+		t.Fatalf("%s", err)
+	}
+	// At most a first attempt of each request and 3 retries, instead of retryThreshold attempts of each
+	if got := failed.count(); got < 4 || got > 6 {
+		t.Errorf("the failing host should get 3 retries in all, got %d requests", got)
+	}
+	if got := purged.count(); got != 4 {
+		t.Errorf("the healthy host should retry its failed request, got %d requests", got)
+	}
+	if summary := targets[1].tally.Summary(); summary.PurgedObjects != 3 || summary.Failed != 0 {
+		t.Errorf("the healthy host should be unaffected, got %s", summary)
+	}
+	if summary := targets[0].tally.Summary(); summary.Failed != 3 {
+		t.Errorf("every request to the failing host should fail, got %s", summary)
+	}
+
+	failures := config.hostRetries.hostFailures()
+	if len(failures) != 2 || failures[config.edgeConf.Host] != 3 || failures[healthyConf.Host] != 0 {
+		t.Errorf("unexpected failures per host: %v", failures)
+	}
+	summary := Summary{HostFailures: failures}
+	if got := summary.String(); !strings.HasSuffix(got, ", host failures: "+config.edgeConf.Host+": 3") {
+		t.Errorf("expected failures of the failing host in the summary, got %s", got)
+	}
+}
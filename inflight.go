@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// byteLimiter bounds bytes of request bodies in flight by -max-in-flight-bytes, as a weighted semaphore
+// over body size. A body is acquired before its request goroutine starts, so reading the input waits too.
+// A body larger than the limit takes all of it and goes alone. A nil *byteLimiter never blocks.
+type byteLimiter struct {
+	mu      sync.Mutex
+	limit   int
+	used    int
+	changed chan struct{} // closed when bytes are released
+}
+
+func newByteLimiter(limit int) *byteLimiter {
+	return &byteLimiter{limit: limit, changed: make(chan struct{})}
+}
+
+// weight is what a body of n bytes holds of the limit
+func (l *byteLimiter) weight(n int) int {
+	if n > l.limit {
+		return l.limit
+	}
+	return n
+}
+
+// acquire blocks until n bytes fit under the limit or ctx is done
+func (l *byteLimiter) acquire(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+	n = l.weight(n)
+	for {
+		l.mu.Lock()
+		if l.used+n <= l.limit {
+			l.used += n
+			l.mu.Unlock()
+			return nil
+		}
+		changed := l.changed
+		l.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// release frees n bytes acquired by a finished request
+func (l *byteLimiter) release(n int) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.used -= l.weight(n)
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestByteLimiter(t *testing.T) {
+	l := newByteLimiter(100)
+	ctx := context.Background()
+	if err := l.acquire(ctx, 60); err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan struct{})
+	go func() {
+		l.acquire(ctx, 60)
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("bodies over the limit together should wait")
+	case <-time.After(20 * time.Millisecond):
+	}
+	l.release(60)
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("a released body should let the next one go")
+	}
+
+	// A body larger than the limit goes alone instead of never
+	l.release(60)
+	if err := l.acquire(ctx, 1000); err != nil {
+		t.Fatal(err)
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := l.acquire(cancelled, 1); err != context.Canceled {
+		t.Errorf("nothing should fit beside a body taking the limit, got %v", err)
+	}
+
+	var disabled *byteLimiter
+	if err := disabled.acquire(ctx, 1<<30); err != nil {
+		t.Errorf("a nil limiter should never block, got %v", err)
+	}
+	disabled.release(1 << 30)
+}
+
+func TestInvalidationMaxInFlightBytes(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, peak, requests := 0, 0, 0
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		requests++
+		if inFlight++; inFlight > peak {
+			peak = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+
+	// One object per request, and room for a single body in flight
+	config := newTestConfig(ts)
+	config.maxObjects = 1
+	config.inFlightBytes = newByteLimiter(len(`{"objects":["https://example.com/a"]}`))
+	in := "https://example.com/a\nhttps://example.com/b\nhttps://example.com/c\nhttps://example.com/d\n"
+	if err := Invalidation(context.Background(), config, strings.NewReader(in)); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 4 || peak != 1 {
+		t.Errorf("expected 4 requests one at a time, got %d with %d in flight at most", requests, peak)
+	}
+}
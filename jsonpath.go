@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// jsonPathStep is a step of -json-pointer: a key of an object or an index of an array, or every member
+// of either for a wildcard
+type jsonPathStep struct {
+	key      string
+	wildcard bool
+}
+
+func newJSONPathStep(key string) jsonPathStep {
+	if key == "*" {
+		return jsonPathStep{wildcard: true}
+	}
+	return jsonPathStep{key: key}
+}
+
+// parseJSONPath parses -json-pointer, either a JSON Pointer like "/items/*/url" or JSONPath-lite like
+// "items[].url" or "$.items[*].url". "*" and "[]" match every element
+func parseJSONPath(path string) ([]jsonPathStep, error) {
+	if strings.HasPrefix(path, "/") {
+		var steps []jsonPathStep
+		for _, token := range strings.Split(path[1:], "/") {
+			steps = append(steps, newJSONPathStep(strings.NewReplacer("~1", "/", "~0", "~").Replace(token)))
+		}
+		return steps, nil
+	}
+
+	var steps []jsonPathStep
+	s := strings.TrimPrefix(path, "$")
+	for len(s) > 0 {
+		switch s[0] {
+		case '.':
+			s = s[1:]
+		case '[':
+			end := strings.IndexByte(s, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("-json-pointer %q has an unclosed [", path)
+			}
+			inner := strings.TrimSpace(s[1:end])
+			switch {
+			case len(inner) == 0 || inner == "*":
+				steps = append(steps, jsonPathStep{wildcard: true})
+			case len(inner) >= 2 && (inner[0] == '"' || inner[0] == '\'') && inner[len(inner)-1] == inner[0]:
+				steps = append(steps, jsonPathStep{key: inner[1 : len(inner)-1]})
+			default:
+				if _, err := strconv.Atoi(inner); err != nil {
+					return nil, fmt.Errorf("-json-pointer %q has %q in [], which should be an index, * or a quoted key", path, inner)
+				}
+				steps = append(steps, jsonPathStep{key: inner})
+			}
+			s = s[end+1:]
+			continue
+		}
+		end := strings.IndexAny(s, ".[")
+		if end < 0 {
+			end = len(s)
+		}
+		if end == 0 {
+			continue
+		}
+		steps = append(steps, newJSONPathStep(s[:end]))
+		s = s[end:]
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("-json-pointer %q points at no field", path)
+	}
+	return steps, nil
+}
+
+// extractJSONPath returns values at steps in v, decoded with UseNumber. Members of objects matched by a
+// wildcard are visited in the order of their keys
+func extractJSONPath(v interface{}, steps []jsonPathStep) []interface{} {
+	if len(steps) == 0 {
+		return []interface{}{v}
+	}
+	step, rest := steps[0], steps[1:]
+	var values []interface{}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if !step.wildcard {
+			if member, ok := v[step.key]; ok {
+				values = extractJSONPath(member, rest)
+			}
+			break
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			values = append(values, extractJSONPath(v[key], rest)...)
+		}
+	case []interface{}:
+		if !step.wildcard {
+			if i, err := strconv.Atoi(step.key); err == nil && i >= 0 && i < len(v) {
+				values = extractJSONPath(v[i], rest)
+			}
+			break
+		}
+		for _, element := range v {
+			values = append(values, extractJSONPath(element, rest)...)
+		}
+	}
+	return values
+}
+
+// writeJSONPath writes values at -json-pointer of each JSON document in r to w, one per line. Strings
+// and numbers like CP codes are written, other values are skipped as invalid
+func writeJSONPath(config *Config, r io.Reader, w io.Writer) error {
+	steps, err := parseJSONPath(config.jsonPointer)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	for n := 1; ; n++ {
+		var doc interface{}
+		if err := dec.Decode(&doc); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("JSON document #%d: %s", n, err)
+		}
+		for _, value := range extractJSONPath(doc, steps) {
+			var line string
+			switch value := value.(type) {
+			case string:
+				line = value
+			case json.Number:
+				line = value.String()
+			default:
+				err := fmt.Errorf("JSON document #%d has %v at %s, which is not a string or a number", n, value, config.jsonPointer)
+				if config.strict {
+					return err
+				}
+				log.Warnf("skip invalid object: %s", err)
+				continue
+			}
+			if _, err := io.WriteString(w, line+"\n"); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// InvalidateByJSONPath purges objects at -json-pointer of arbitrary JSON through the same chunking as
+// text input
+func InvalidateByJSONPath(ctx context.Context, config *Config, fp io.Reader, wg *sync.WaitGroup) error {
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := writeJSONPath(config, fp, pw)
+		pw.Close()
+		done <- err
+	}()
+
+	err := InvalidateByURLs(ctx, config, pr, wg)
+	// Unblock the writer when InvalidateByURLs stopped reading early
+	pr.Close()
+	if pathErr := <-done; pathErr != nil && pathErr != io.ErrClosedPipe {
+		return pathErr
+	}
+	return err
+}
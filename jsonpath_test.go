@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseJSONPath(t *testing.T) {
+	want := []jsonPathStep{{key: "items"}, {wildcard: true}, {key: "url"}}
+	for _, path := range []string{"items[].url", ".items[*].url", "$.items[].url", "/items/*/url", `items["url"]`} {
+		steps, err := parseJSONPath(path)
+		if err != nil {
+			t.Errorf("%s: %s", path, err)
+			continue
+		}
+		if path == `items["url"]` {
+			if w := []jsonPathStep{{key: "items"}, {key: "url"}}; !reflect.DeepEqual(steps, w) {
+				t.Errorf("%s: expected %+v, got %+v", path, w, steps)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(steps, want) {
+			t.Errorf("%s: expected %+v, got %+v", path, want, steps)
+		}
+	}
+	for _, path := range []string{"", "$", "items[", "items[x]"} {
+		if _, err := parseJSONPath(path); err == nil {
+			t.Errorf("%q should be invalid", path)
+		}
+	}
+}
+
+func TestExtractJSONPath(t *testing.T) {
+	doc := `{"items":[
+		{"url":"https://example.com/a","variants":[{"url":"https://example.com/a?v=1"}]},
+		{"url":"https://example.com/b","variants":[]},
+		{"name":"no url"}
+	],"cpcodes":{"b":222,"a":111}}`
+	var v interface{}
+	dec := json.NewDecoder(strings.NewReader(doc))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string][]interface{}{
+		"items[].url":            {"https://example.com/a", "https://example.com/b"},
+		"items[].variants[].url": {"https://example.com/a?v=1"},
+		"/items/1/url":           {"https://example.com/b"},
+		"cpcodes.*":              {json.Number("111"), json.Number("222")},
+		"missing[].url":          nil,
+	}
+	for path, want := range tests {
+		steps, _ := parseJSONPath(path)
+		if got := extractJSONPath(v, steps); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected %v, got %v", path, want, got)
+		}
+	}
+}
+
+func TestInvalidationJSONPointer(t *testing.T) {
+	ts, rec := newTestServer(http.StatusCreated)
+	defer ts.Close()
+
+	// Documents can be concatenated, like JSON lines of an export
+	in := `{"release":{"items":[{"url":"https://example.com/a"},{"url":"https://example.com/b"},{"url":42}]}}
+{"release":{"items":[{"url":"https://example.com/c"}]}}`
+	config := newTestConfig(ts)
+	config.jsonPointer = "release.items[].url"
+	if err := Invalidation(context.Background(), config, strings.NewReader(in)); err != nil {
+		t.Fatal(err)
+	}
+	// 42 isn't a URL, so it is skipped like any invalid object of a list
+	want := `{"objects":["https://example.com/a","https://example.com/b","https://example.com/c"]}`
+	if got := rec.joinedBodies(); got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+
+	config.jsonPointer = "release.items[].url"
+	config.strict = true
+	err := Invalidation(context.Background(), config, strings.NewReader(`{"release":{"items":[{"url":{}}]}}`))
+	if err == nil || !strings.Contains(err.Error(), "not a string") {
+		t.Errorf("a value neither a string nor a number should fail under -strict, got %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := writeJSONPath(&Config{jsonPointer: "items[].url"}, strings.NewReader(`{"items":`), &out); err == nil {
+		t.Errorf("invalid JSON should fail")
+	}
+}
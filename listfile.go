@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// stdinPath in a -list-file means stdin
+const stdinPath = "-"
+
+// readListFile returns paths of lists in the -list-file, one per line. Blank lines and "#" comments
+// are skipped. Paths are relative to the working directory, and expanded as file arguments are
+func readListFile(path string) ([]string, error) {
+	path, err := homedir.Expand(path)
+	if err != nil {
+		return nil, err
+	}
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	var paths []string
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		paths = append(paths, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("%s: no list is given", path)
+	}
+	return paths, nil
+}
+
+// readsStdin reports whether one of paths is stdin
+func readsStdin(paths []string) bool {
+	for _, p := range paths {
+		if p == stdinPath {
+			return true
+		}
+	}
+	return false
+}
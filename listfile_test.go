@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunListFile(t *testing.T) {
+	ts, rec := newTestServer(http.StatusCreated)
+	defer ts.Close()
+	setEdgegridEnv(t)
+	defer unsetEdgegridEnv()
+	os.Setenv("AKAMAI_HOST", strings.TrimPrefix(ts.URL, "https://"))
+	defer log.SetLevel(log.Level)
+
+	dir, err := ioutil.TempDir("", "purge-list-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	arg := write("arg.txt", "https://example.com/arg\n")
+	first := write("first.txt", "https://example.com/a\nhttps://example.com/b\n")
+	second := write("second.txt", "https://example.com/c\n")
+	list := write("lists.txt", "# manifest\n"+first+"\n\n"+second+"\n")
+
+	if err := run([]string{"-insecure", "-list-file", list, arg}, &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+	// Lists follow file arguments in order
+	want := `{"objects":["https://example.com/arg"]}` + "\n" +
+		`{"objects":["https://example.com/a","https://example.com/b"]}` + "\n" +
+		`{"objects":["https://example.com/c"]}`
+	if got := rec.joinedBodies(); got != want {
+		t.Errorf("expected every list to be purged\n%s\ngot\n%s", want, got)
+	}
+
+	// Errors tell the list they come from
+	valid := write("valid.json", `{"objects":["https://example.com/a"]}`)
+	invalid := write("invalid.json", `{"objects":`)
+	list = write("lists.txt", valid+"\n"+invalid+"\n")
+	err = run([]string{"-insecure", "-t", "json", "-list-file", list}, &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), invalid) {
+		t.Errorf("the error should mention %s, got %v", invalid, err)
+	}
+	list = write("lists.txt", filepath.Join(dir, "missing.txt")+"\n")
+	err = run([]string{"-insecure", "-list-file", list}, &bytes.Buffer{})
+	if exitCode(err) != exitConfig || !strings.Contains(err.Error(), "missing.txt") {
+		t.Errorf("a missing list should be a config error mentioning it, got %v", err)
+	}
+}
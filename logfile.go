@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// setLogOutput directs logs to -log-file: "stderr" as by default, "stdout", or a file appended to.
+// Call config.closeLog once the command finishes
+func setLogOutput(config *Config) error {
+	switch config.logFile {
+	case "", "stderr":
+		return nil
+	case "stdout":
+		config.logRestore = log.Out
+		log.SetOutput(os.Stdout)
+		return nil
+	}
+	path, err := homedir.Expand(config.logFile)
+	if err != nil {
+		return err
+	}
+	fp, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	config.logOut, config.logRestore = fp, log.Out
+	log.SetOutput(fp)
+	return nil
+}
+
+// logsToStderr reports whether logs are written to stderr, where progress is drawn
+func (config *Config) logsToStderr() bool {
+	return config.logRestore == nil
+}
+
+// closeLog restores the log output of before setLogOutput, closing the -log-file
+func (config *Config) closeLog() {
+	if config.logRestore == nil {
+		return
+	}
+	log.SetOutput(config.logRestore)
+	config.logRestore = nil
+	if config.logOut != nil {
+		if err := config.logOut.Close(); err != nil {
+			log.Errorf("failed to close -log-file: %s", err)
+		}
+		config.logOut = nil
+	}
+}
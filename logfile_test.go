@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunLogFile(t *testing.T) {
+	ts, _ := newTestServer(http.StatusCreated)
+	defer ts.Close()
+	setEdgegridEnv(t)
+	defer unsetEdgegridEnv()
+	os.Setenv("AKAMAI_HOST", strings.TrimPrefix(ts.URL, "https://"))
+	defer log.SetLevel(log.Level)
+
+	dir, err := ioutil.TempDir("", "purge-log-file")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "purge.log")
+	if err := ioutil.WriteFile(path, []byte("previous run\n"), 0644); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	// stderr stands in for the default output
+	var stderr bytes.Buffer
+	out := log.Out
+	log.SetOutput(&stderr)
+	defer log.SetOutput(out)
+
+	var stdout bytes.Buffer
+	if err := run([]string{"-insecure", "-l", "info", "-log-file", path, "-url", "https://example.com/"}, &stdout); err != nil {
+		t.Fatalf("%s", err)
+	}
+	logs, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if !strings.HasPrefix(string(logs), "previous run\n") {
+		t.Errorf("the log file should be appended to, got %q", logs)
+	}
+	if !strings.Contains(string(logs), "[Succeed]") {
+		t.Errorf("logs should land in the log file, got %q", logs)
+	}
+	if stderr.Len() > 0 {
+		t.Errorf("nothing should be logged to stderr, got %q", stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "[Summary]") {
+		t.Errorf("the summary should stay on stdout, got %q", stdout.String())
+	}
+	if log.Out != &stderr {
+		t.Errorf("the log output should be restored once the run finishes")
+	}
+}
+
+func TestPrepareLogFileOutput(t *testing.T) {
+	setEdgegridEnv(t)
+	defer unsetEdgegridEnv()
+	defer log.SetLevel(log.Level)
+
+	dir, err := ioutil.TempDir("", "purge-log-file")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+	logPath, outputPath := filepath.Join(dir, "purge.log"), filepath.Join(dir, "results.jsonl")
+
+	var stderr bytes.Buffer
+	out := log.Out
+	log.SetOutput(&stderr)
+	defer log.SetOutput(out)
+
+	// Closing -output shouldn't leave -log-file open
+	config := &Config{}
+	fs := newFlagSet(config, "test")
+	if err := fs.Parse([]string{"-insecure", "-log-file", logPath, "-output", outputPath, "-url", "https://example.com/"}); err != nil {
+		t.Fatalf("%s", err)
+	}
+	cleanup, err := prepare(config, fs, &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	logOut := config.logOut
+	if logOut == nil || log.Out != logOut {
+		t.Fatalf("logs should go to -log-file")
+	}
+	cleanup()
+	if log.Out != &stderr {
+		t.Errorf("the log output should be restored once the run finishes")
+	}
+	if _, err := logOut.Write([]byte("after the run\n")); err == nil {
+		t.Errorf("-log-file should be closed once the run finishes")
+	}
+
+	// Logs on stdout would be mixed into results
+	config = &Config{}
+	fs = newFlagSet(config, "test")
+	if err := fs.Parse([]string{"-insecure", "-log-file", "stdout", "-output", "-", "-url", "https://example.com/"}); err != nil {
+		t.Fatalf("%s", err)
+	}
+	cleanup, err = prepare(config, fs, &bytes.Buffer{})
+	cleanup()
+	if err == nil {
+		t.Errorf("-log-file stdout should be rejected with -output -")
+	}
+	if log.Out != &stderr {
+		t.Errorf("the log output should be restored after a rejected run")
+	}
+}
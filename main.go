@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -10,12 +11,20 @@ import (
 	"io"
 	"io/ioutil"
 	"math/rand"
+	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"os"
 	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	edgegrid "github.com/akamai-open/AkamaiOPEN-edgegrid-golang"
 	uuid "github.com/google/uuid"
@@ -29,34 +38,276 @@ const (
 	defaultMethod            = "invalidate"
 	defaultNetwork           = "staging"
 	defaultFileType          = "text"
+	defaultObjectType        = "url"
 	defaultLogLevel          = "error"
-	maxBodySize              = 50000
+	defaultLogFormat         = "text"
+	defaultColor             = "auto"
+	defaultInputFormat       = "auto"
+	defaultBasePath          = "/ccu/v3"
+	defaultCSVColumn         = "1"
+	defaultJitter            = "equal"
+	defaultMaxBodySize       = 50000
+	defaultMaxObjects        = 1000
+	defaultBreakerThreshold  = 10
+	defaultBreakerCooldown   = 30 * time.Second
+	defaultMinConcurrency    = 1
+	defaultMaxConcurrency    = 32
+	minBodySize              = 1024
 	cachePurgeRequestMethohd = "POST"
+	purgeContentType         = "application/json"
 	retryThreshold           = 10 // uint32 shifting
+	maxAutoSplits            = 4  // halvings of a body by -auto-split, up to 16 requests
 	defaultRetryCount        = 0
+	defaultEdgegridMaxBody   = 131072
+	maxTagLength             = 128
+	akamaiAPIDomain          = ".akamaiapis.net"
 )
 
 var (
-	jsonOverHead     = len([]byte(`{"objects":[]}`))
-	jsonLineOverHead = len([]byte(`"",`))
-	log              = logrus.New()
-	logLevel         logrus.Level
+	jsonOverHead = len([]byte(`{"objects":[]}`))
+	log          = logrus.New()
+	logLevel     logrus.Level
 )
 
 // RequestBody ...
 type RequestBody struct {
-	Objects []string `json:"objects"`
+	Hostname string   `json:"hostname,omitempty"`
+	Objects  []string `json:"objects"`
 }
 
 // Config is configuration for Akamai Fast Purge(CCU v3) request
 type Config struct {
-	edgerc   string
-	section  string
-	method   string
-	network  string
-	fileType string
-	logLevel string
-	edgeConf edgegrid.Config
+	edgerc           string
+	configFile       string
+	listFile         string   // of paths to lists, read after file arguments
+	files            []string // file arguments followed by lists of -list-file
+	section          string
+	method           string
+	network          string
+	objectType       string
+	fileType         string
+	inputFormat      string
+	basePath         string
+	hostname         string
+	bodyTemplatePath string
+	bodyTemplate     map[string]json.RawMessage // fields of -body-template but "hostname", added to bodies
+	logLevel         string
+	logFormat        string
+	logFile          string
+	color            string
+	output           string
+	statePath        string // of -state, recording objects purged by the run
+	retryPath        string
+	checkpointPath   string
+	resume           bool
+	checkpoint       *checkpoint    // of -checkpoint, recording chunks completed by the run
+	chunk            *chunkProgress // of the chunk requests of a copy of config are of
+	autoSplit        bool
+	splits           int    // times bodies of requests were halved by -auto-split
+	beforeCmd        string // run before submitting, aborting the purge when it fails
+	afterCmd         string // run after the purge with the summary JSON on stdin
+	diff             bool
+	strict           bool
+	failFast         bool
+	rps              float64
+	maxBody          int
+	maxObjects       int
+	maxTotal         int
+	maxInFlightBytes int
+	maxLineBytes     int
+	maxHostRetries   int
+	verify           bool
+	verifyWait       bool
+	quota            int    // soft daily limit of objects of the account, 0 disables it
+	quotaFile        string // carrying the total of the day over runs
+	quotaAbort       bool
+	showVersion      bool
+	testCreds        bool
+	metricsAddr      string
+	csvColumn        string
+	csvHeader        bool
+	jsonPointer      string // of objects in arbitrary JSON input, read as a list
+	yes              bool
+	normalize        bool
+	canonicalize     canonicalization
+	encode           string
+	groupByHost      bool
+	replaceHosts     hostRewrites
+	sort             bool
+	dedupe           bool
+	stdin            []byte // read once for targets which all purge stdin given as a file
+	expand           bool
+	quiet            bool
+	caCert           string
+	userAgent        string
+	runID            string // shared by requests of a run, tracing them in logs and results
+	runIDHeader      string
+	idleTimeout      time.Duration
+	maxIdleConns     int
+	connStats        *connStats // of connections traced at debug level
+	explainAuth      bool
+	trace            bool
+	authExplainer    *authExplainer // of -explain-auth
+	insecure         bool
+	minTLSVersion    string
+	http2            bool
+	showProgress     bool
+	rateReport       bool
+	explain          bool
+	previewCount     bool
+	flattenJSON      bool
+	sample           float64 // of objects -explain validates instead of splitting them, 0 splits them all
+	compress         bool
+	detectType       bool // -t isn't given, detect it from stdin
+	sectionMethod    bool // -m isn't given, take it from the edgerc section if it has one
+	sectionNetwork   bool // -n isn't given, take it from the edgerc section if it has one
+	urls             stringList
+	cpcodes          stringList
+	tags             stringList
+	headers          headerList // added to every purge request
+	objects          []string   // given by one of the above, replacing files and stdin
+	jitter           string
+	noJitter         bool
+	wildcard         bool
+	warmup           int
+	seed             int64
+	randPerRequest   bool   // jitter of each request is of a source of its own seeded by seed, its target, chunk and body
+	chunkSeq         *int64 // chunks submitted by the target, numbering them for the source of jitter
+	chunkIndex       int64  // of the chunk of the request in order of submission by its target
+	retryOn          statusSet
+	maxDelay         time.Duration
+	startupJitter    time.Duration
+	deadline         time.Duration
+	interval         time.Duration // between cycles re-purging files, 0 purges them once
+	deadlineAt       time.Time     // of the whole run, cancelling in-flight requests
+	breakerThreshold int
+	adaptive         bool
+	throttleGlobal   bool
+	minConcurrency   int
+	maxConcurrency   int
+	breakerCooldown  time.Duration
+	edgeConf         edgegrid.Config
+	edgeConfs        []edgegrid.Config // of each section given by -s
+	sectionDefaults  []edgercDefaults  // of each section, in the order of edgeConfs
+	client           doer
+	results          *resultWriter
+	tally            *Results
+	progress         *progress
+	explainer        *explainer
+	limiter          *rateLimiter
+	concurrency      *adaptiveLimiter
+	cooldown         *cooldown // of -throttle-on-429-global
+	inFlightBytes    *byteLimiter
+	budget           *objectBudget
+	quotaUsage       *quotaTracker
+	verifier         *edgeVerifier
+	state            *purgeState
+	retries          *retryFile
+	compression      *compression
+	breaker          *breaker
+	halt             *halter   // of -fail-fast
+	logOut           *os.File  // of -log-file
+	logRestore       io.Writer // log output before -log-file, nil while logs go to stderr
+	hostRetries      *hostRetries
+	metrics          *metrics
+	rates            *rateRecorder     // of -rate-report
+	onResult         func(PurgeResult) // called once per request as it completes, from its goroutine
+	clock            clock             // nil is the real one
+	signer           signer            // of requests, nil is edgegrid.AddRequestHeader
+	rand             randSource        // of jitter, nil is the math/rand global one
+	targetName       string            // e.g. "section prod, network staging" when purging with several targets
+}
+
+// doer sends HTTP requests. *http.Client satisfies it, tests can swap in their own
+type doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// signer adds the EdgeGrid Authorization header to req. Tests swap in their own to see what is signed
+type signer func(edgeConf edgegrid.Config, req *http.Request) *http.Request
+
+// sign signs req with credentials of config. Every attempt must be signed afresh, since a signature
+// holds a timestamp and nonce and Fast Purge rejects stale or replayed ones
+func (config *Config) sign(req *http.Request) *http.Request {
+	if config.signer == nil {
+		return edgegrid.AddRequestHeader(config.edgeConf, req)
+	}
+	return config.signer(config.edgeConf, req)
+}
+
+// httpClient returns the doer used for purge requests, falling back to a default http.Client
+func (config *Config) httpClient() doer {
+	if config.client == nil {
+		return &http.Client{}
+	}
+	return config.client
+}
+
+// bodySizeLimit returns -max-body-size, or the Fast Purge limit when it isn't set
+func (config *Config) bodySizeLimit() int {
+	if config.maxBody == 0 {
+		return defaultMaxBodySize
+	}
+	return config.maxBody
+}
+
+// objectLimit returns -max-objects, or the default when it isn't set
+func (config *Config) objectLimit() int {
+	if config.maxObjects == 0 {
+		return defaultMaxObjects
+	}
+	return config.maxObjects
+}
+
+// basePathOrDefault returns -base-path, the Fast Purge API path when it isn't set
+func (config *Config) basePathOrDefault() string {
+	if len(config.basePath) == 0 {
+		return defaultBasePath
+	}
+	return config.basePath
+}
+
+// objectKind returns how objects of text input are validated, the object type or "path" for URLs under -hostname
+func (config *Config) objectKind() string {
+	if len(config.hostname) > 0 && config.objectTypeOrDefault() == "url" {
+		return "path"
+	}
+	return config.objectTypeOrDefault()
+}
+
+// objectTypeOrDefault returns the type of objects to purge given by the subcommand, "url" when it isn't set
+func (config *Config) objectTypeOrDefault() string {
+	if len(config.objectType) == 0 {
+		return defaultObjectType
+	}
+	return config.objectType
+}
+
+// record reports the result of a finished request to the summary, the -output destination and onResult, if any
+func (config *Config) record(result PurgeResult) {
+	if config.tally != nil {
+		config.tally.Record(result)
+	}
+	config.rates.observe(config.clockOrDefault().Now(), result.Duration)
+	config.progress.done(result)
+	if config.results != nil {
+		if err := config.results.write(result); err != nil {
+			log.Errorf("failed to write result of request_id: %s: %s", result.RequestID, err)
+		}
+	}
+	if config.onResult != nil {
+		config.onResult(result)
+	}
+	if !result.Succeeded() {
+		config.halt.fail(result)
+	}
+}
+
+// skip counts objects read from input but not submitted because the run is stopping
+func (config *Config) skip(objects int) {
+	if config.tally != nil {
+		config.tally.skip(objects)
+	}
 }
 
 func chkExist(path string) error {
@@ -68,270 +319,1837 @@ func chkExist(path string) error {
 		return err
 	}
 	fp, err := os.Open(path)
-	chkErr(err)
+	if err != nil {
+		return err
+	}
 	defer fp.Close()
 	return nil
 }
 
 // Validation check args provided to client. If args has invalid parameter(s), Validation returns error
 func Validation(config *Config) error {
+	if err := validateCredentials(config); err != nil {
+		return err
+	}
+	if err := validateHost(config.edgeConf.Host); err != nil {
+		if config.strict {
+			return err
+		}
+		log.Warn(err)
+	}
 
-	// Validate edgerc params
+	// Validate config params
+	if config.method != "invalidate" && config.method != "delete" && config.method != "both" {
+		return invalid("-m", ErrInvalidMethod, "you should specify a invalidation method is \"invalidate\", \"delete\" or \"both\"")
+	}
+	if config.network != "production" && config.network != "staging" && config.network != "both" {
+		return invalid("-n", ErrInvalidNetwork, "you should specify a invalidation network is \"production\", \"staging\" or \"both\"")
+	}
+	if (config.method == "delete" || config.method == "both") && config.network == "staging" {
+		// Deleting on staging is rarely meant, the production-delete confirmation doesn't cover it
+		err := invalid("-m", ErrDeleteOnStaging, "delete removes objects from staging cache entirely, so the next request waits for the origin. Use -m invalidate to just mark them stale")
+		if config.strict {
+			return err
+		}
+		log.Warn(err)
+	}
+	if config.fileType != "json" && config.fileType != "text" && config.fileType != "csv" && config.fileType != "mixed" {
+		return invalid("-t", ErrInvalidFileType, "you should specify a cache invalidation request list type is \"json\", \"text\", \"csv\" or \"mixed\"")
+	}
+	if config.inputFormat != "" && config.inputFormat != defaultInputFormat && config.inputFormat != "ndjson" && config.inputFormat != "jsonarray" {
+		return invalid("-input-format", ErrInvalidOption, "you should specify a JSON input format is \"auto\", \"ndjson\" or \"jsonarray\"")
+	}
+	if config.encode != "" && config.encode != "passthrough" && config.encode != "encode" && config.encode != "decode" {
+		return invalid("-encode", ErrInvalidOption, "you should specify an encoding is \"passthrough\", \"encode\" or \"decode\"")
+	}
+	if config.maxBody != 0 && config.maxBody < minBodySize {
+		return invalid("-max-body-size", ErrInvalidOption, "you should specify a max body size is at least %d bytes", minBodySize)
+	}
+	if config.maxObjects < 0 {
+		return invalid("-max-objects", ErrInvalidOption, "you should specify a max number of objects per request is positive")
+	}
+	if len(config.basePath) > 0 && (!strings.HasPrefix(config.basePath, "/") || path.Clean(config.basePath) != config.basePath || strings.ContainsAny(config.basePath, "?#")) {
+		return invalid("-base-path", ErrInvalidOption, "you should specify a base path is a clean absolute path like %q, got %q", defaultBasePath, config.basePath)
+	}
+	if err := validateHostname(config); err != nil {
+		return err
+	}
+	if config.maxTotal < 0 {
+		return invalid("-max-total-objects", ErrInvalidOption, "you should specify a max total number of objects is not negative")
+	}
+	if config.sample < 0 || config.sample > 1 {
+		return invalid("-sample", ErrInvalidOption, "you should specify a sample fraction between 0 and 1")
+	}
+	if config.quota < 0 {
+		return invalid("-quota", ErrInvalidOption, "you should specify a quota is not negative")
+	}
+	if config.warmup < 0 {
+		return invalid("-warmup", ErrInvalidOption, "you should specify a number of connections to warm up is not negative")
+	}
+	if len(config.runIDHeader) > 0 && (!validHeaderName(config.runIDHeader) || reservedHeaders[http.CanonicalHeaderKey(config.runIDHeader)]) {
+		return invalid("-run-id-header", ErrInvalidOption, "you should specify a header name not set by the client, got %q", config.runIDHeader)
+	}
+	if strings.ContainsAny(config.runID, "\r\n") {
+		return invalid("-run-id", ErrInvalidOption, "you should specify a run ID without line breaks")
+	}
+	if config.idleTimeout < 0 || config.maxIdleConns < 0 {
+		return invalid("-idle-timeout", ErrInvalidOption, "you should specify -idle-timeout and -max-idle-conns are not negative")
+	}
+	if config.maxLineBytes < 0 {
+		return invalid("-max-line-bytes", ErrInvalidOption, "you should specify a max line length is not negative")
+	}
+	if config.maxInFlightBytes < 0 {
+		return invalid("-max-in-flight-bytes", ErrInvalidOption, "you should specify a max number of bytes in flight is not negative")
+	}
+	if _, ok := jitterStrategies[config.jitter]; len(config.jitter) > 0 && !ok {
+		return invalid("-jitter", ErrInvalidOption, "you should specify a jitter strategy is \"full\", \"equal\", \"decorrelated\" or \"none\"")
+	}
+	if config.maxDelay < 0 {
+		return invalid("-max-delay", ErrInvalidOption, "you should specify a max retry delay is not negative")
+	}
+	if config.startupJitter < 0 {
+		return invalid("-startup-jitter", ErrInvalidOption, "you should specify a startup jitter is not negative")
+	}
+	if config.deadline < 0 {
+		return invalid("-deadline", ErrInvalidOption, "you should specify a deadline is not negative")
+	}
+	if config.interval < 0 {
+		return invalid("-interval", ErrInvalidOption, "you should specify an interval is not negative")
+	}
+	if config.maxHostRetries < 0 {
+		return invalid("-max-retries-per-host", ErrInvalidOption, "you should specify a max number of retries per host is not negative")
+	}
+	if config.breakerThreshold < 0 || config.breakerCooldown < 0 {
+		return invalid("-breaker-threshold", ErrInvalidOption, "you should specify a circuit breaker threshold and cooldown are not negative")
+	}
+	if config.adaptive && (config.minConcurrency < 1 || config.maxConcurrency < config.minConcurrency) {
+		return invalid("-min-concurrency", ErrInvalidOption, "you should specify -min-concurrency is positive and -max-concurrency is not smaller than it")
+	}
+	return nil
+}
+
+// validateHostname checks -hostname, or "hostname" of -body-template, goes with a URL list
+func validateHostname(config *Config) error {
+	if len(config.hostname) == 0 {
+		return nil
+	}
+	if config.objectTypeOrDefault() != "url" || config.fileType == "json" || config.fileType == "mixed" {
+		return invalid("-hostname", ErrInvalidOption, "you should specify -hostname only for URL lists, JSON bodies carry their own \"hostname\"")
+	}
+	if strings.ContainsAny(config.hostname, ":/ ") {
+		return invalid("-hostname", ErrInvalidOption, "you should specify -hostname as a host name only, got %q", config.hostname)
+	}
+	return nil
+}
+
+// validateCredentials checks edgerc params, which every subcommand needs
+func validateCredentials(config *Config) error {
 	if len(config.edgeConf.Host) == 0 {
-		return errors.New("edgerc does not have \"host\" parameter")
+		return invalid("host", ErrMissingHost, "edgerc does not have \"host\" parameter")
 	}
 	if len(config.edgeConf.ClientToken) == 0 {
-		return errors.New("edgerc does not have \"client_token\" parameter")
+		return invalid("client_token", ErrMissingClientToken, "edgerc does not have \"client_token\" parameter")
 	}
 	if len(config.edgeConf.ClientSecret) == 0 {
-		return errors.New("edgerc does not have \"client_secret\" parameter")
+		return invalid("client_secret", ErrMissingClientSecret, "edgerc does not have \"client_secret\" parameter")
 	}
 	if len(config.edgeConf.AccessToken) == 0 {
-		return errors.New("edgerc does not have \"access_token\" parameter")
+		return invalid("access_token", ErrMissingAccessToken, "edgerc does not have \"access_token\" parameter")
 	}
+	return nil
+}
 
-	// Validate config params
-	if config.method != "invalidate" && config.method != "delete" {
-		return errors.New("you should specify a invalidation method is \"invalidate\" or \"delete\"")
+// validateURL checks raw is a fully-qualified http(s) URL, which Fast Purge requires for url objects
+func validateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%q does not have \"http\" or \"https\" scheme", raw)
+	}
+	if len(u.Host) == 0 {
+		return fmt.Errorf("%q does not have a host", raw)
+	}
+	if strings.Contains(raw, "*") {
+		return fmt.Errorf("%q has a wildcard *, which is purged only with -wildcard", raw)
 	}
-	if config.network != "production" && config.network != "staging" {
-		return errors.New("you should specify a invalidation network is \"production\" or \"staging\"")
+	return nil
+}
+
+// validatePath checks raw is an absolute path without scheme and host, purged with -hostname
+func validatePath(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if len(u.Scheme) > 0 || len(u.Host) > 0 || !strings.HasPrefix(raw, "/") {
+		return fmt.Errorf("%q should be a path like \"/index.html\" under -hostname, not a URL", raw)
 	}
-	if config.fileType != "json" && config.fileType != "text" {
-		return errors.New("you should specify a cache invalidation request list type is \"json\" or \"text\"")
+	if strings.Contains(raw, "*") {
+		return fmt.Errorf("%q has a wildcard *, which is purged only with -wildcard", raw)
 	}
 	return nil
 }
 
+// checkObject validates an object of kind like validateObject, but lets -wildcard allow * in paths of
+// URLs and paths, e.g. "https://example.com/images/*". Hosts can't have wildcards
+func (config *Config) checkObject(kind, raw string) error {
+	if !config.wildcard || (kind != "url" && kind != "path") || !strings.Contains(raw, "*") {
+		return validateObject(kind, raw)
+	}
+	if kind == "url" {
+		if u, err := url.Parse(raw); err == nil && strings.Contains(u.Host, "*") {
+			return fmt.Errorf("%q has a wildcard * in its host, only paths can have them", raw)
+		}
+	}
+	// The rest is validated as it would be without wildcards
+	return validateObject(kind, strings.Replace(raw, "*", "x", -1))
+}
+
+// validateHost checks host looks like an Akamai API host, e.g. "akab-xxxx.luna.akamaiapis.net".
+// Other hosts are often copied from a wrong place and result in confusing 404s or connection errors
+func validateHost(host string) error {
+	if strings.Contains(host, "://") || strings.Contains(host, "/") {
+		return invalid("host", ErrInvalidHost, "edgerc \"host\" %q should be a host name only, remove the scheme and path", host)
+	}
+	name := strings.ToLower(host)
+	if h, _, err := net.SplitHostPort(name); err == nil {
+		name = h
+	}
+	if !strings.HasSuffix(name, akamaiAPIDomain) {
+		return invalid("host", ErrInvalidHost, "edgerc \"host\" %q doesn't look like an Akamai API host(*%s), "+
+			"check the section has credentials of an API client with access to Fast Purge(CCU)", host, akamaiAPIDomain)
+	}
+	return nil
+}
+
+// validateObject checks raw is a valid object of objectType: a URL, a numeric CP code or a cache tag
+func validateObject(objectType, raw string) error {
+	switch objectType {
+	case "cpcode":
+		if _, err := strconv.ParseUint(raw, 10, 64); err != nil {
+			return fmt.Errorf("%q is not a numeric CP code", raw)
+		}
+	case "path":
+		return validatePath(raw)
+	case "tag":
+		if len(raw) > maxTagLength {
+			return fmt.Errorf("%q is longer than %d characters", raw, maxTagLength)
+		}
+		if strings.ContainsAny(raw, " \t") {
+			return fmt.Errorf("%q contains whitespace, which cache tags can't have", raw)
+		}
+	default:
+		return validateURL(raw)
+	}
+	return nil
+}
+
+// normalizeURL trims surrounding whitespace including "\r" of CRLF line endings.
+// When lowerHost is true, it also lowercases scheme and host, keeping case of path and query
+func normalizeURL(raw string, lowerHost bool) string {
+	s := strings.TrimSpace(raw)
+	if !lowerHost {
+		return s
+	}
+	hostStart, end, ok := urlHostSpan(s)
+	if !ok {
+		return s
+	}
+	i := strings.Index(s, "://")
+	return strings.ToLower(s[:i+3]) + s[i+3:hostStart] + strings.ToLower(s[hostStart:end]) + s[end:]
+}
+
 // InvalidateByURLs ...
-func InvalidateByURLs(config *Config, fp io.Reader, wg *sync.WaitGroup) (err error) {
-	var buffer bytes.Buffer
-	bufsize := maxBodySize - jsonOverHead
-	scanner := bufio.NewScanner(fp)
+func InvalidateByURLs(ctx context.Context, config *Config, fp io.Reader, wg *sync.WaitGroup) (err error) {
+	maxBodySize := config.bodySizeLimit()
+	maxObjects := config.objectLimit()
+	groups := newObjectGroups(config, maxBodySize, maxObjects)
+	// Objects seen by -dedupe, keyed by their types too as -t mixed may repeat a value of another type
+	var seen map[string]bool
+	duplicates := 0
+	if config.dedupe {
+		seen = map[string]bool{}
+	}
+	scanner := newLineScanner(fp, config.maxLineBytes)
 
-	// Chop the text file by request body size upper limit
-	// reference: https://developer.akamai.com/api/purge/ccu/overview.html#limits
-	for scanner.Scan() {
-		line := scanner.Bytes()
-		_, err := url.Parse(string(line))
-		chkErr(err)
-		bufsize = bufsize - len(line) - jsonLineOverHead
-		if 0 < bufsize {
-			buffer.Write(line)
-			buffer.Write([]byte("\n"))
-		} else {
-			body := make([]byte, maxBodySize)
-			_, err := buffer.Read(body)
-			reqBody := createJSON(body)
-			chkErr(err)
-			wg.Add(1)
-			go invalidationRequest(config, reqBody, wg)
+	flush := func(group *objectGroup, chunk *chunker) error {
+		objectType := group.config.objectTypeOrDefault()
+		objects := chunk.objects
+		// Stop queuing new chunks once cancelled, in-flight requests are left to finish
+		if err := ctx.Err(); err != nil {
+			config.skip(len(objects))
+			config.retries.writeObjects(objects)
+			return err
+		}
+		progress, done := config.checkpoint.take(len(objects))
+		if done {
+			chunk.reset()
+			return nil
+		}
+		err := config.budget.take(len(objects))
+		if err == nil {
+			err = config.quotaUsage.take(len(objects))
+		}
+		if err != nil {
+			config.skip(len(objects))
+			config.retries.writeObjects(objects)
+			return err
+		}
+		if config.explainer.counting() {
+			config.explainer.count(len(objects), chunk.size)
+			chunk.reset()
+			return nil
+		}
+		if config.sort {
+			sortObjects(objects, objectType)
+		}
+		reqBody, err := marshalObjects(objects, objectType, group.config.hostname, config.bodyTemplate)
+		if err != nil {
+			// Fail the chunk alone as a request would, the others are still submitted
+			log.WithError(err).Error("[Failed]")
+			config.record(PurgeResult{Objects: len(objects), Error: err.Error(), FailedObjects: append([]string(nil), objects...)})
+			config.retries.writeObjects(objects)
+		} else if err := submit(ctx, group.config.withChunk(progress), reqBody, wg); err != nil {
+			config.skip(len(objects))
+			config.retries.writeObjects(objects)
+			return err
+		}
+		// The body is marshaled already, reuse the slice for the next chunk
+		chunk.reset()
+		return nil
+	}
 
-			bufsize = maxBodySize - jsonOverHead - len(line) - jsonLineOverHead
-			buffer.Reset()
-			buffer.Write(line)
-			buffer.Write([]byte("\n"))
+	// Chop the text file by request body size and object count upper limits, whichever is hit first
+	add := func(group *objectGroup, line string) error {
+		kind := group.config.objectKind()
+		line, err := encodeObject(config.encode, kind, line)
+		if err != nil {
+			if config.strict {
+				return err
+			}
+			log.Warnf("skip invalid object: %s", err)
+			return nil
+		}
+		if kind == "url" && len(config.replaceHosts) > 0 {
+			rewritten, err := config.replaceHosts.apply(line)
+			if err != nil {
+				if config.strict {
+					return err
+				}
+				log.Warnf("skip invalid object: %s", err)
+				return nil
+			}
+			line = rewritten
+		}
+		line = config.canonicalize.apply(kind, line)
+		if config.explainer != nil && config.explainer.sampler != nil {
+			config.explainer.sampler.observe(kind, line)
+			return nil
+		}
+		if err := config.checkObject(kind, line); err != nil {
+			if config.strict {
+				return err
+			}
+			log.Warnf("skip invalid object: %s", err)
+			return nil
+		}
+		if seen != nil {
+			key := group.config.objectTypeOrDefault() + " " + line
+			if seen[key] {
+				log.Debugf("skip duplicate %s", line)
+				duplicates++
+				return nil
+			}
+			seen[key] = true
+		}
+		if strings.Contains(line, "*") {
+			log.Warnf("wildcard object %s purges everything it matches", line)
+		}
+		if config.state.skip(line) {
+			log.Debugf("skip %s purged by the last run", line)
+			return nil
+		}
+		chunk := group.chunkers.of(line)
+		if full, byCount := chunk.full(line); full {
+			if byCount {
+				log.Infof("a request body reached %d objects under %d bytes, split it", maxObjects, maxBodySize)
+			}
+			if err := flush(group, chunk); err != nil {
+				return err
+			}
 		}
+		chunk.add(line)
+		return nil
 	}
-	body := make([]byte, maxBodySize)
-	count, err := buffer.Read(body)
-	chkErr(err)
-	reqBody := createJSON(body[:count])
 
-	// Request cache invalidation
-	wg.Add(1)
-	go invalidationRequest(config, reqBody, wg)
+	for scanner.Scan() {
+		if err := scanner.lineErr(); err != nil {
+			if config.strict {
+				return err
+			}
+			log.Warnf("skip invalid object: %s", err)
+			continue
+		}
+		// A line of -t mixed starts with the type of its object, which has a group of its own
+		group, line := groups.of(""), scanner.Text()
+		if config.fileType == "mixed" {
+			var objectType string
+			objectType, line = splitTypedLine(line)
+			group = groups.of(objectType)
+		}
+		line = normalizeURL(line, config.normalize)
+		if len(line) == 0 {
+			continue
+		}
+		lines, err := config.expandLine(line)
+		if err != nil {
+			if config.strict {
+				return err
+			}
+			log.Warnf("skip unexpandable line: %s", err)
+			continue
+		}
+		for _, line := range lines {
+			if err := add(group, line); err != nil {
+				return err
+			}
+		}
+	}
+	for _, group := range groups.all() {
+		for _, chunk := range group.chunkers.pending() {
+			if err := flush(group, chunk); err != nil {
+				return err
+			}
+		}
+	}
+	if duplicates > 0 {
+		log.Infof("skipped %d duplicate objects", duplicates)
+	}
 
+	// Objects read before a hard error are submitted above, the error still fails the run
 	if err := scanner.Err(); err != nil {
-		fmt.Fprintln(os.Stderr, "reading standard input:", err)
+		return fmt.Errorf("reading input: %s", err)
 	}
-	return err
+	return nil
 }
 
 // InvalidateByBodies ...
-func InvalidateByBodies(config *Config, fp io.Reader, wg *sync.WaitGroup) (err error) {
-	dec := json.NewDecoder(fp)
-	for {
-		var reqBody = map[string]interface{}{}
-		if err = dec.Decode(&reqBody); err != nil {
+func InvalidateByBodies(ctx context.Context, config *Config, fp io.Reader, wg *sync.WaitGroup) (err error) {
+	dec := newBodyDecoder(fp, config.inputFormat)
+	// send submits bodies in order, skipping the rest once one can't be
+	send := func(bodies [][]byte) (err error) {
+		for i, bodyBuf := range bodies {
+			var progress *chunkProgress
+			if err = ctx.Err(); err == nil {
+				var done bool
+				if progress, done = config.checkpoint.take(countObjects(bodyBuf)); done {
+					continue
+				}
+				err = config.budget.take(countObjects(bodyBuf))
+			}
+			if err == nil {
+				err = config.quotaUsage.take(countObjects(bodyBuf))
+			}
+			if err == nil {
+				err = submit(ctx, config.withChunk(progress), bodyBuf, wg)
+			}
+			if err != nil {
+				for _, skipped := range bodies[i:] {
+					config.skip(countObjects(skipped))
+					config.retries.writeBody(skipped)
+				}
+				return err
+			}
+		}
+		return nil
+	}
+	var merger *bodyMerger
+	if config.flattenJSON {
+		merger = newBodyMerger(config.bodySizeLimit(), config.objectLimit())
+	}
+	// flushMerged sends the body merged last by -flatten-json, or skips it once sending stopped
+	flushMerged := func(stopped bool) error {
+		last, err := merger.flush()
+		if err != nil || last == nil {
+			return err
+		}
+		if stopped {
+			config.skip(countObjects(last))
+			config.retries.writeBody(last)
+			return nil
+		}
+		return send([][]byte{last})
+	}
+	for n := 1; ; n++ {
+		var fields map[string]json.RawMessage
+		if fields, err = dec.next(); err != nil {
 			if err == io.EOF {
 				err = nil
+			} else {
+				err = fmt.Errorf("body #%d near offset %d: %s", n, dec.offset(), err)
 			}
 			break
 		}
-		var bodyBuf []byte
-		if bodyBuf, err = json.Marshal(reqBody); err != nil {
+		var reqBody Body
+		if reqBody, err = parseBody(fields); err != nil {
+			err = fmt.Errorf("body #%d: %s", n, err)
+			if config.strict {
+				break
+			}
+			log.Warnf("skip invalid body: %s", err)
+			err = nil
+			continue
+		}
+		reqBody = withTemplate(reqBody, config.bodyTemplate)
+		var bodies [][]byte
+		if config.sort {
+			reqBody.sortObjects()
+		}
+		if merger != nil {
+			if bodies, err = merger.add(reqBody); err != nil {
+				err = fmt.Errorf("body #%d: %s", n, err)
+				break
+			}
+			if err = send(bodies); err != nil {
+				flushMerged(true)
+				return err
+			}
+			continue
+		}
+		if bodies, err = splitBody(reqBody, config.bodySizeLimit()); err != nil {
+			err = fmt.Errorf("body #%d: %s", n, err)
 			break
 		}
-		wg.Add(1)
-		go invalidationRequest(config, bodyBuf, wg)
+		if len(bodies) > 1 {
+			log.Infof("body #%d exceeds %d bytes, split into %d requests", n, config.bodySizeLimit(), len(bodies))
+		}
+		if err = send(bodies); err != nil {
+			return err
+		}
+	}
+	// Objects merged before a hard error are sent, the error still fails the run
+	if flushErr := flushMerged(false); err == nil {
+		err = flushErr
 	}
 	return err
 }
 
 // Invalidation request to Akamai CCU v3 (a.k.a Fast Purge) with credential and URL list
-func Invalidation(config *Config, in io.Reader) (err error) {
+func Invalidation(ctx context.Context, config *Config, in io.Reader) (err error) {
 	var wg sync.WaitGroup
+	in = newRetryReader(in)
 
 	switch config.fileType {
-	case "text":
-		err = InvalidateByURLs(config, in, &wg)
+	case "text", "mixed":
+		if len(config.jsonPointer) > 0 {
+			err = InvalidateByJSONPath(ctx, config, in, &wg)
+			break
+		}
+		err = InvalidateByURLs(ctx, config, in, &wg)
 	case "json":
-		err = InvalidateByBodies(config, in, &wg)
+		err = InvalidateByBodies(ctx, config, in, &wg)
+	case "csv":
+		err = InvalidateByCSV(ctx, config, in, &wg)
 	}
 
 	wg.Wait()
 	return err
 }
 
+// detectFileType peeks the first non-whitespace byte of in, and returns "json" when it starts a JSON value,
+// "text" otherwise. Read the returned reader instead of in, which holds the peeked bytes
+func detectFileType(in io.Reader) (io.Reader, string) {
+	r := bufio.NewReader(in)
+	switch firstNonSpace(r) {
+	case '{', '[':
+		return r, "json"
+	default:
+		return r, "text"
+	}
+}
+
+// firstNonSpace peeks the first byte of r other than whitespace, 0 when there is none in its buffer
+func firstNonSpace(r *bufio.Reader) byte {
+	for i := 1; i <= r.Size(); i++ {
+		buf, err := r.Peek(i)
+		if err != nil {
+			break
+		}
+		switch buf[i-1] {
+		case ' ', '\t', '\r', '\n':
+			continue
+		default:
+			return buf[i-1]
+		}
+	}
+	return 0
+}
+
+// stdinInput returns stdin to read, detecting -t from its content unless -t is given explicitly
+func stdinInput(config *Config, stdin io.Reader) io.Reader {
+	if !config.detectType {
+		return stdin
+	}
+	in, fileType := detectFileType(stdin)
+	log.Debugf("detected %s input on stdin, specify -t to override", fileType)
+	config.fileType = fileType
+	return in
+}
+
+// isRemoteList reports whether path is an http(s) URL serving a purge list rather than a local file
+func isRemoteList(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// expandPaths expands "~" and glob patterns of each path. A pattern matching nothing is an error.
+// Remote lists are passed through as they are
+func expandPaths(patterns []string) ([]string, error) {
+	var paths []string
+	for _, pattern := range patterns {
+		if isRemoteList(pattern) || pattern == stdinPath {
+			paths = append(paths, pattern)
+			continue
+		}
+		expanded, err := homedir.Expand(pattern)
+		if err != nil {
+			return nil, err
+		}
+		matches, err := filepath.Glob(expanded)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("%s: no such file", pattern)
+		}
+		paths = append(paths, matches...)
+	}
+	return paths, nil
+}
+
+// openInput opens a local file, or fetches a remote list with the same HTTP client used for purge requests
+func openInput(ctx context.Context, config *Config, path string) (io.ReadCloser, error) {
+	if path == stdinPath {
+		if config.stdin != nil {
+			return ioutil.NopCloser(bytes.NewReader(config.stdin)), nil
+		}
+		return ioutil.NopCloser(os.Stdin), nil
+	}
+	if !isRemoteList(path) {
+		return os.Open(path)
+	}
+	req, err := http.NewRequest("GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", config.userAgentOrDefault())
+	resp, err := config.httpClient().Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, withTLSHint(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to fetch %s: %s", path, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// InvalidateFiles runs Invalidation for every file matched by patterns, or remote list given by URL.
+// Objects given by flags are purged along with the files, in a single list
+func InvalidateFiles(ctx context.Context, config *Config, patterns []string) error {
+	paths, err := expandPaths(patterns)
+	if err != nil {
+		return err
+	}
+	if len(config.objects) > 0 {
+		in, err := combinedInput(ctx, config, paths)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		return Invalidation(ctx, config, in)
+	}
+	for _, p := range paths {
+		in, err := openInput(ctx, config, p)
+		if err != nil {
+			return err
+		}
+		err = Invalidation(ctx, config, in)
+		in.Close()
+		if err != nil {
+			// Tell which of the lists failed
+			return fmt.Errorf("%s: %w", p, err)
+		}
+	}
+	return nil
+}
+
 func buildRequestURL(config *Config) *url.URL {
 	return &url.URL{
 		Scheme: "https",
 		Host:   config.edgeConf.Host,
-		Path:   path.Join("/ccu/v3", config.method, "url", config.network),
+		Path:   path.Join(config.basePathOrDefault(), config.method, config.objectTypeOrDefault(), config.network),
 	}
 }
 
-const (
-	baseDuration = 5
-)
+// newPurgeRequest builds a purge request of an attempt sending body. Each attempt gets a reader of its
+// own over body which is never written to, so a retry sends the whole body again however the previous
+// attempt consumed its reader. GetBody lets the transport re-send it too, e.g. on a refused HTTP/2 stream
+func newPurgeRequest(ctx context.Context, config *Config, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, cachePurgeRequestMethohd, buildRequestURL(config).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	return req, nil
+}
 
-// Error retry with exponential backoff and full jitter
-// akamai api limits: https://developer.akamai.com/api/purge/ccu/overview.html#limits
-// exponential backoff: https://www.awsarchitectureblog.com/2015/03/backoff.html
-// This is "Full Jitter" algorithm
-func nextDelay(count int) time.Duration {
-	var tmp int64 = 1 << uint32(count) * baseDuration
-	return time.Duration(tmp/2+rand.Int63n(tmp/2)) * time.Second
+// sleepContext sleeps for d, or returns early with an error when ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
-func invalidationRequest(config *Config, data []byte, wg *sync.WaitGroup) {
+// invalidationRequest sends one request body, retrying on -retry-on statuses and connection errors.
+// Cancelling ctx stops retrying, but doesn't abort a request already in flight
+func invalidationRequest(ctx context.Context, config *Config, data []byte, wg *sync.WaitGroup) {
 	defer wg.Done()
+	config.progress.queue()
 	reqID := uuid.New().String()
+	reqLog := log.WithField("request_id", reqID)
+	if len(config.runID) > 0 {
+		reqLog = reqLog.WithField("run_id", config.runID)
+	}
+	client := config.httpClient()
+	result := PurgeResult{RequestID: reqID, RunID: config.runID, Objects: countObjects(data)}
+	clock := config.clockOrDefault()
+	rnd := config.requestRand(data)
+	start := clock.Now()
+	// slot is the epoch of the -adaptive slot held by the attempt in flight, -1 when none is held
+	slot := -1
+	// split is set when the body was halved by -auto-split, the halves report instead of this request
+	split := false
+	releaseSlot := func(statusCode int, err error) {
+		config.concurrency.release(slot, statusCode, err)
+		slot = -1
+	}
+	defer func() {
+		// A panic, e.g. of the edgegrid library, fails this request alone instead of the whole process
+		if r := recover(); r != nil {
+			if slot >= 0 {
+				releaseSlot(0, nil)
+			}
+			result.Error = fmt.Sprintf("panic: %v", r)
+			reqLog.WithField("error", result.Error).Error("[Failed]")
+		}
+		if split {
+			config.progress.drop()
+			return
+		}
+		result.Duration = clock.Now().Sub(start)
+		if !result.Succeeded() {
+			result.FailedObjects = bodyObjects(data)
+			config.retries.writeBody(data)
+			config.hostRetries.fail(config.edgeConf.Host)
+		}
+		config.record(result)
+	}()
+
+	// -deadline cancels even requests in flight, unlike interruption which only stops retrying
+	reqCtx := context.Background()
+	if !config.deadlineAt.IsZero() {
+		var cancel context.CancelFunc
+		reqCtx, cancel = context.WithDeadline(reqCtx, config.deadlineAt)
+		defer cancel()
+	}
+
+	// Spread the burst of requests released at once, -startup-jitter is 0 unless opted in
+	if config.startupJitter > 0 {
+		if err := clock.Sleep(ctx, randDuration(rnd, config.startupJitter)); err != nil {
+			result.Error = err.Error()
+			return
+		}
+	}
 
+	var delay time.Duration
 L:
 	for i := 0; i < retryThreshold; i++ {
-		bodyBuf := bytes.NewBuffer(data)
-		client := &http.Client{}
-		req, err := http.NewRequest(cachePurgeRequestMethohd, buildRequestURL(config).String(), bodyBuf)
-		chkErr(err)
+		// Back off only before a retry: terminal outcomes break out of the loop and the last
+		// attempt never gets here
+		if i > 0 {
+			if err := clock.Sleep(ctx, delay); err != nil {
+				result.Error = fmt.Sprintf("%s, stopped retrying: %s", result.Error, err)
+				break L
+			}
+			config.metrics.incRetries()
+		}
+		// A host which used up its retries fails fast, others go on
+		if err := config.hostRetries.allow(config.edgeConf.Host, i > 0); err != nil {
+			if i == 0 {
+				result.Error = err.Error()
+			} else {
+				result.Error = fmt.Sprintf("%s, stopped retrying: %s", result.Error, err)
+			}
+			reqLog.WithField("attempt", i+1).Warn("[Host retries used up]")
+			break L
+		}
+		result.Attempts = i + 1
+		body, compressed := data, config.compression.enabled()
+		if compressed {
+			gzipped, err := gzipBody(data)
+			if err != nil {
+				result.Error = err.Error()
+				reqLog.WithError(err).Error("[Failed]")
+				break L
+			}
+			body = gzipped
+		}
+		req, err := newPurgeRequest(reqCtx, config, body)
+		if err != nil {
+			result.Error = err.Error()
+			reqLog.WithError(err).Error("[Failed]")
+			break L
+		}
+
+		// Wait out a pause of -throttle-on-429-global, then for a slot of -adaptive concurrency, then for
+		// a token so that all goroutines together stay under -rps
+		if err := config.cooldown.wait(ctx, clock); err != nil {
+			result.Error = err.Error()
+			break L
+		}
+		if slot, err = config.concurrency.acquire(ctx); err != nil {
+			result.Error = err.Error()
+			break L
+		}
+		if err := config.limiter.wait(ctx); err != nil {
+			releaseSlot(0, nil)
+			result.Error = err.Error()
+			break L
+		}
+		// Fail fast without retrying while the API looks down
+		if err := config.breaker.allow(); err != nil {
+			releaseSlot(0, nil)
+			result.Error = err.Error()
+			result.CircuitOpen = true
+			reqLog.WithField("attempt", i+1).Warn("[Circuit open]")
+			break L
+		}
+
+		// Add Akamai Authorization header over the explicit Content-Type and User-Agent, then -header ones which can't replace it
+		req.Header.Set("Content-Type", purgeContentType)
+		req.Header.Set("User-Agent", config.userAgentOrDefault())
+		if compressed {
+			// Signed as sent, so the signature covers the compressed bytes
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+		req = config.sign(req)
+		config.headers.apply(req)
+		if len(config.runIDHeader) > 0 && len(config.runID) > 0 {
+			req.Header.Set(config.runIDHeader, config.runID)
+		}
 
-		// Add Akamai Authorization header
-		req = edgegrid.AddRequestHeader(config.edgeConf, req)
+		// Trace whether the connection is reused only when it is logged
+		var reused bool
+		if log.IsLevelEnabled(logrus.DebugLevel) {
+			req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+				GotConn: func(info httptrace.GotConnInfo) {
+					reused = info.Reused
+					config.connStats.gotConn(info.Reused)
+				},
+				TLSHandshakeDone: config.connStats.handshake,
+			}))
+		}
+		var trace *requestTrace
+		if config.trace {
+			trace = newRequestTrace(clock.Now)
+			req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace.clientTrace()))
+		}
 
 		// Send invalidation request
-		if resp, err := client.Do(req); err == nil {
+		config.metrics.addInFlight(1)
+		sent := clock.Now()
+		resp, err := client.Do(req)
+		latency := clock.Now().Sub(sent)
+		config.authExplainer.explain(config.edgeConf, req, body, resp, sent)
+		if trace != nil {
+			reqLog.WithField("attempt", i+1).WithFields(trace.fields()).Debug("[Trace]")
+		}
+		config.metrics.addInFlight(-1)
+		if err == nil {
+			releaseSlot(resp.StatusCode, nil)
+		} else {
+			releaseSlot(0, err)
+		}
+		attemptLog := reqLog.WithFields(logrus.Fields{
+			"attempt":      i + 1,
+			"max_attempts": retryThreshold,
+			"latency":      latency,
+		})
+		// retryLog picks the delay before the next attempt, logged unless this one is the last
+		retryLog := func() *logrus.Entry {
+			if i+1 >= retryThreshold {
+				return attemptLog
+			}
+			delay = config.nextDelay(i, delay, rnd)
+			return attemptLog.WithField("next_delay", delay)
+		}
+		if err == nil {
+			config.metrics.observeRequest(resp.StatusCode, nil, latency)
+			config.breaker.report(resp.StatusCode, nil)
+			attemptLog.WithFields(logrus.Fields{
+				"status": resp.StatusCode,
+				"proto":  resp.Proto,
+				"reused": reused,
+			}).Debug("[Response]")
 			respBody, _ := ioutil.ReadAll(resp.Body)
 			resp.Body.Close()
+			result.StatusCode = resp.StatusCode
+			// Error responses are JSON too, carrying supportId which Akamai support asks for
+			var rb ResponseBody
+			parsed := json.Unmarshal(respBody, &rb) == nil
+			var halves [][]byte
+			if resp.StatusCode == http.StatusBadRequest && config.autoSplit && parsed && bodyTooLarge(rb) && config.splits < maxAutoSplits {
+				halves = halveBody(data)
+			}
 
-			switch resp.StatusCode {
-			case http.StatusTooManyRequests, http.StatusInsufficientStorage:
-				log.Printf("[Rate limited]request_id: %s\n", reqID)
-			case http.StatusCreated:
-				log.Printf("[Succeed]request_id: %s, response: %s\n", reqID, respBody)
+			switch {
+			case resp.StatusCode == http.StatusCreated:
+				config.state.purged(data)
+				config.chunk.succeeded(clock.Now())
+				config.verifier.purged(data, rb.EstimatedSeconds)
+				result.PurgeID = rb.PurgeID
+				result.SupportID, result.Detail = "", ""
+				result.Error = ""
+				attemptLog.WithFields(logrus.Fields{
+					"status":   resp.StatusCode,
+					"response": string(respBody),
+				}).Info("[Succeed]")
 				break L
+			case len(halves) > 0:
+				attemptLog.WithFields(logrus.Fields{
+					"status":     resp.StatusCode,
+					"support_id": rb.SupportID,
+					"detail":     rb.Detail,
+					"objects":    result.Objects,
+				}).Warn("[Auto split] the body is rejected for its size, sending its halves instead")
+				split = true
+				half := *config
+				half.splits++
+				config.chunk.split(len(halves))
+				wg.Add(len(halves))
+				for _, body := range halves {
+					go invalidationRequest(ctx, &half, body, wg)
+				}
+				break L
+			case resp.StatusCode == http.StatusUnsupportedMediaType && compressed:
+				// The gateway doesn't take compressed bodies, send this one and the rest plain right away
+				if config.compression.reject() {
+					attemptLog.WithField("status", resp.StatusCode).Warn("[Compression rejected] falling back to uncompressed bodies")
+				}
+				result.Error = http.StatusText(resp.StatusCode)
+				delay = 0
+			case config.retryable(resp.StatusCode) && len(authHint(resp.StatusCode)) == 0:
+				result.Error = http.StatusText(resp.StatusCode)
+				event := "[Retrying]"
+				if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusInsufficientStorage {
+					result.Error = "rate limited"
+					event = "[Rate limited]"
+				}
+				result.SupportID, result.Detail = rb.SupportID, rb.Detail
+				entry := retryLog().WithFields(logrus.Fields{
+					"status":     resp.StatusCode,
+					"support_id": rb.SupportID,
+				})
+				if resp.StatusCode == http.StatusTooManyRequests && config.cooldown != nil && i+1 < retryThreshold {
+					// Every request waits out the pause before sending, this one included instead of its own delay
+					pause, ok := retryAfter(resp.Header, clock.Now())
+					if !ok {
+						pause = delay
+					}
+					// A Retry-After of hours would hold up every request, not only this one
+					pause = capDelay(pause, config.maxDelay)
+					if config.cooldown.pause(clock.Now(), pause) {
+						entry.WithField("pause", pause).Warn("[Cooldown] pausing all requests")
+					}
+					delay = 0
+				}
+				entry.Info(event)
 			default:
-				log.Errorf("[Failed]request_id: %s, request_body_length: %d, response_status: %d, response_body: %s, request_header: %s, request_body: %s, \n", reqID, req.ContentLength, resp.StatusCode, string(respBody), req.Header["Authorization"], string(data))
+				result.Error = http.StatusText(resp.StatusCode)
+				fields := logrus.Fields{
+					"status":              resp.StatusCode,
+					"request_body_length": req.ContentLength,
+					"request_header":      req.Header["Authorization"],
+					"request_body":        string(data),
+				}
+				if parsed {
+					result.SupportID, result.Detail = rb.SupportID, rb.Detail
+					if len(rb.Detail) > 0 {
+						result.Error += ": " + rb.Detail
+					}
+					fields["support_id"] = rb.SupportID
+					fields["title"] = rb.Title
+					fields["detail"] = rb.Detail
+				} else {
+					fields["response_body"] = string(respBody)
+				}
+				// Rejected credentials look like any other failure but for what to check
+				if hint := authHint(resp.StatusCode); len(hint) > 0 {
+					result.Error += ", " + hint
+					fields["hint"] = hint
+				}
+				attemptLog.WithFields(fields).Error("[Failed]")
 				break L
 			}
-		}
-		// Don't delay at last iteration
-		if retryThreshold-i > 1 {
-			time.Sleep(nextDelay(i))
+		} else {
+			err = withTLSHint(err)
+			config.metrics.observeRequest(0, err, latency)
+			config.breaker.report(0, err)
+			attemptLog.WithError(err).Debug("[Response]")
+			result.Error = err.Error()
+			result.ConnectionErrors++
+			if !retryableError(err) {
+				attemptLog.WithError(err).WithField("retry", false).Warn("[Connection error]")
+				break L
+			}
+			retryLog().WithError(err).WithField("retry", true).Warn("[Connection error]")
 		}
 	}
 }
 
-func createJSON(data []byte) (body []byte) {
-	buf := bytes.NewBuffer(data)
-	rb, err := createRequestBody(buf)
-	chkErr(err)
-	body, err = json.Marshal(rb)
-	chkErr(err)
-	return body
+// sortObjects sorts objects in place, CP codes numerically
+func sortObjects(objects []string, objectType string) {
+	if objectType == "cpcode" {
+		// CP codes are validated as numbers, so shorter ones are smaller
+		sort.Slice(objects, func(i, j int) bool {
+			if len(objects[i]) != len(objects[j]) {
+				return len(objects[i]) < len(objects[j])
+			}
+			return objects[i] < objects[j]
+		})
+		return
+	}
+	sort.Strings(objects)
 }
 
-func createRequestBody(in io.Reader) (RequestBody, error) {
-	r := bufio.NewReader(in)
-	rb := RequestBody{
-		Objects: []string{},
+// marshalObjects returns a request body of objects, which are paths under hostname when it is given,
+// with extra fields of -body-template
+func marshalObjects(objects []string, objectType, hostname string, extra map[string]json.RawMessage) ([]byte, error) {
+	var body []byte
+	var err error
+	if len(extra) > 0 {
+		raw := make([]json.RawMessage, len(objects))
+		for i, object := range objects {
+			if objectType == "cpcode" {
+				raw[i] = json.RawMessage(object)
+			} else if raw[i], err = json.Marshal(object); err != nil {
+				return nil, err
+			}
+		}
+		body, err = Body{Hostname: hostname, Objects: raw, Extra: extra}.MarshalJSON()
+	} else if objectType == "cpcode" {
+		// Fast Purge takes CP codes as numbers, they are validated as such already
+		cpcodes := make([]json.Number, len(objects))
+		for i, object := range objects {
+			cpcodes[i] = json.Number(object)
+		}
+		body, err = json.Marshal(struct {
+			Objects []json.Number `json:"objects"`
+		}{cpcodes})
+	} else {
+		body, err = json.Marshal(RequestBody{Hostname: hostname, Objects: objects})
 	}
-	for {
-		l, err := r.ReadString(byte('\n'))
-		if err != nil {
-			if err == io.EOF {
-				break
+	return body, err
+}
+
+// jsonStringLen returns the length of s encoded as a JSON string by encoding/json, which escapes
+// HTML characters as well as quotes and control characters. So that bodies never exceed the size limit
+func jsonStringLen(s string) int {
+	n := len(`""`)
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			switch {
+			case c == '"' || c == '\\' || c == '\n' || c == '\r' || c == '\t':
+				n += 2
+			case c < 0x20 || c == '<' || c == '>' || c == '&':
+				n += len(`\u0000`)
+			default:
+				n++
 			}
-			return rb, err
+			i++
+			continue
 		}
-		rb.Objects = append(rb.Objects, l[:len(l)-1])
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			// Invalid UTF-8 is replaced with U+FFFD, which some Go versions escape as "\ufffd". Count the longer
+			n += len(`\ufffd`)
+		case r == '\u2028' || r == '\u2029':
+			n += len(`\u2028`)
+		default:
+			n += size
+		}
+		i += size
 	}
-	return rb, nil
+	return n
 }
 
-func chkErr(err error) {
+// checkEdgercMode fails when the edgerc holding client secrets is readable by group or others, as ssh
+// does for private keys. Windows has no such permission bits
+func checkEdgercMode(path string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	fi, err := os.Stat(path)
 	if err != nil {
-		log.Fatalf("%s", err)
+		return err
+	}
+	if mode := fi.Mode().Perm(); mode&0044 != 0 {
+		return invalid("-c", ErrInsecureEdgerc, "edgerc %s is readable by others(%04o), it holds client secrets. Run chmod 600 %s", path, mode, path)
 	}
+	return nil
 }
 
-func initEdgeConfig(config *Config) {
+func initEdgeConfig(config *Config) (err error) {
 	// Akamai library using panic in casually... :(
 	defer func() {
-		if err := recover(); err != nil {
-			return
+		if r := recover(); r != nil {
+			err = fmt.Errorf("failed to load section %q from %s: %v", config.section, config.edgerc, r)
 		}
 	}()
 	config.edgeConf = edgegrid.InitConfig(config.edgerc, config.section)
+	return nil
+}
+
+// edgeConfigFromEnv reads credentials from AKAMAI_* environment variables. ok is false unless all of them are set
+func edgeConfigFromEnv() (edgeConf edgegrid.Config, ok bool) {
+	env := map[string]*string{
+		"AKAMAI_HOST":          &edgeConf.Host,
+		"AKAMAI_CLIENT_TOKEN":  &edgeConf.ClientToken,
+		"AKAMAI_CLIENT_SECRET": &edgeConf.ClientSecret,
+		"AKAMAI_ACCESS_TOKEN":  &edgeConf.AccessToken,
+	}
+	for name, field := range env {
+		if *field = os.Getenv(name); len(*field) == 0 {
+			return edgegrid.Config{}, false
+		}
+	}
+	edgeConf.MaxBody = defaultEdgegridMaxBody
+	return edgeConf, true
+}
+
+// loadEdgeConfig loads credentials from environment variables, or from the edgerc file when they are absent
+func loadEdgeConfig(config *Config) error {
+	if edgeConf, ok := edgeConfigFromEnv(); ok {
+		log.Debugf("use credentials from environment variables, skip %s", config.edgerc)
+		config.edgeConf = edgeConf
+		return nil
+	}
+
+	// Validate edgerc file
+	edgercPath, err := homedir.Expand(config.edgerc)
+	if err != nil {
+		return err
+	}
+	if err = chkExist(edgercPath); err != nil {
+		return err
+	}
+	if err := checkEdgercMode(edgercPath); err != nil {
+		if config.strict {
+			return err
+		}
+		log.Warn(err)
+	}
+	config.edgerc = edgercPath
+	if err := initEdgeConfig(config); err != nil {
+		return err
+	}
+	return applyEdgercDefaults(config)
 }
 
 func setLogLevel(config *Config) (err error) {
 	logLevel, err = logrus.ParseLevel(config.logLevel)
+	// -quiet drops per-request success logs whatever -l is. The summary isn't a log, so it is still printed
+	if config.quiet && logLevel > logrus.WarnLevel {
+		logLevel = logrus.WarnLevel
+	}
 	logrus.SetLevel(logLevel)
+	log.SetLevel(logLevel)
 	return err
 }
 
+func setLogFormat(config *Config) error {
+	switch config.logFormat {
+	case "text":
+		formatter, err := textFormatter(config.color)
+		if err != nil {
+			return err
+		}
+		log.Formatter = formatter
+	case "json":
+		log.Formatter = &logrus.JSONFormatter{}
+	default:
+		return errors.New("you should specify a log format is \"text\" or \"json\"")
+	}
+	return nil
+}
+
+// textFormatter returns a text formatter coloring logs per -color. auto colors only on a TTY, and not at all when NO_COLOR is set
+func textFormatter(color string) (*logrus.TextFormatter, error) {
+	switch color {
+	case "", "auto":
+		if len(os.Getenv("NO_COLOR")) > 0 {
+			return &logrus.TextFormatter{DisableColors: true}, nil
+		}
+		return &logrus.TextFormatter{}, nil
+	case "always":
+		return &logrus.TextFormatter{ForceColors: true}, nil
+	case "never":
+		return &logrus.TextFormatter{DisableColors: true}, nil
+	default:
+		return nil, errors.New("you should specify a color mode is \"auto\", \"always\" or \"never\"")
+	}
+}
+
 func init() {
 	rand.Seed(time.Now().UnixNano())
 }
 
-func main() {
+// addCommonFlags defines flags shared by all subcommands: credentials, logging and TLS
+func addCommonFlags(fs *flag.FlagSet, config *Config) {
+	fs.StringVar(&config.configFile, "config", "", "specify a TOML or YAML file setting flags by their names, flags on the command line override it")
+	fs.StringVar(&config.edgerc, "c", defaultEdgerc, "specify a edgerc file")
+	fs.StringVar(&config.section, "s", defaultSection, "specify a config section(comma-separated sections purge the same objects with each of them)")
+	fs.StringVar(&config.logLevel, "l", defaultLogLevel, "specify log level(info or debug)")
+	fs.BoolVar(&config.quiet, "quiet", false, "log only warnings and errors regardless of -l, the summary is still printed")
+	fs.StringVar(&config.logFormat, "log-format", defaultLogFormat, "specify log format(text or json)")
+	fs.StringVar(&config.logFile, "log-file", "", "specify a file to append logs to, or stdout or stderr(default stderr)")
+	fs.StringVar(&config.color, "color", defaultColor, "specify when to color text logs(auto, always or never), auto colors only on a terminal unless NO_COLOR is set")
+	fs.StringVar(&config.runID, "run-id", "", "specify an ID of the run shared by its requests in logs and results, e.g. of a CI job(a random UUID when not given)")
+	fs.StringVar(&config.runIDHeader, "run-id-header", "", "specify a header to send the run ID with every request, e.g. X-Correlation-ID")
+	fs.DurationVar(&config.idleTimeout, "idle-timeout", defaultIdleTimeout, "specify how long an idle connection to the purge host is kept for the next request")
+	fs.IntVar(&config.maxIdleConns, "max-idle-conns", defaultMaxIdleConns, "specify a maximum number of idle connections kept per purge host")
+	fs.BoolVar(&config.explainAuth, "explain-auth", false, "write inputs of the EdgeGrid signature of the first request as JSON to stderr, with tokens masked and without the client secret, to debug rejected signatures")
+	fs.StringVar(&config.userAgent, "user-agent", "", "specify a User-Agent header of requests(default \"akamai-fast-purge-client/<version>\")")
+	fs.StringVar(&config.caCert, "ca-cert", "", "specify a PEM file of additional CA certificates, e.g. of a TLS-intercepting proxy")
+	fs.BoolVar(&config.http2, "http2", true, "attempt HTTP/2, multiplexing requests over fewer connections(false forces HTTP/1.1)")
+	fs.BoolVar(&config.insecure, "insecure", false, "DANGEROUS: skip TLS certificate verification, only for test environments")
+	fs.StringVar(&config.minTLSVersion, "min-tls-version", defaultMinTLSVersion, "specify the minimum TLS version of connections(1.2 or 1.3), hosts not supporting it are failed")
+	fs.BoolVar(&config.showVersion, "version", false, "print version information and exit")
+}
+
+// newFlagSet defines command line flags of purge subcommands bound to config.
+// name is the command line shown in the usage, e.g. "akamai-fast-purge-client cpcode"
+func newFlagSet(config *Config, name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	addCommonFlags(fs, config)
+	fs.StringVar(&config.method, "m", defaultMethod, "specify a invalidation method(invalidate or delete, or both of them)")
+	fs.StringVar(&config.network, "n", defaultNetwork, "specify a target network(akamai production or staging network, or both of them)")
+	fs.StringVar(&config.fileType, "t", defaultFileType, "specify a invalidation list type(json, text, csv or mixed, a text list whose lines may start with url, cpcode or tag), detected between json and text for stdin when not given")
+	fs.StringVar(&config.listFile, "list-file", "", "specify a file listing paths of lists to purge one per line, after file arguments(\"-\" for stdin)")
+	fs.StringVar(&config.inputFormat, "input-format", defaultInputFormat, "specify how bodies of json input are laid out(ndjson for concatenated objects, jsonarray for an array of them), detected by a leading [ when auto")
+	fs.StringVar(&config.csvColumn, "csv-column", defaultCSVColumn, "specify a CSV column holding URLs by 1-origin index or header name")
+	fs.StringVar(&config.jsonPointer, "json-pointer", "", "specify a path of objects in arbitrary JSON input, like \"items[].url\" or \"/items/*/url\", to purge them as a list")
+	fs.BoolVar(&config.csvHeader, "csv-header", false, "skip the first CSV record as a header(detected automatically when it isn't a URL)")
+	fs.BoolVar(&config.explain, "explain", false, "print how the input is split into requests by -max-body-size and -max-objects, without sending anything")
+	fs.BoolVar(&config.previewCount, "preview-count", false, "print how many requests and objects the input makes and the size of the largest request, without building bodies or sending anything")
+	fs.BoolVar(&config.trace, "trace", false, "log DNS lookup, connect, TLS handshake and time to first byte of every request at debug level, to tell where latency comes from")
+	fs.Float64Var(&config.sample, "sample", 0, "with -explain, validate a random fraction of objects like 0.01 instead, estimating invalid ones of a huge list")
+	fs.BoolVar(&config.rateReport, "rate-report", false, "report the request rate achieved and latency percentiles in the summary, to tune -rps")
+	fs.BoolVar(&config.showProgress, "progress", false, "show progress on stderr when it is a terminal")
+	fs.BoolVar(&config.normalize, "normalize", false, "lowercase scheme and host of URLs before submitting")
+	fs.Var(&config.replaceHosts, "replace-host", "specify old=new to purge URLs of host old on host new instead, e.g. www.example.com=stage.example.com(repeatable)")
+	fs.BoolVar(&config.flattenJSON, "flatten-json", false, "with -t json, merge objects of consecutive bodies of the same other fields into requests up to -max-body-size and -max-objects")
+	fs.BoolVar(&config.groupByHost, "group-by-host", false, "chunk URLs by their host, so that objects of a request share a host instead of following input order")
+	fs.StringVar(&config.encode, "encode", defaultEncoding, "specify how percent-encoding of URLs and paths is submitted: "+strings.Join(encodings, ", ")+"(as it is, escaping unsafe characters, or unescaping them)")
+	fs.Var(&config.canonicalize, "canonicalize", "specify comma-separated transformations of URLs and paths before submitting: "+strings.Join(canonicalizations, ", "))
+	fs.StringVar(&config.bodyTemplatePath, "body-template", "", "specify a JSON file of an object like {\"hostname\":\"www.example.com\"} whose fields are added to every request body alongside \"objects\"")
+	fs.StringVar(&config.hostname, "hostname", "", "specify a host name to purge paths under, the list has paths like \"/index.html\" instead of URLs")
+	fs.BoolVar(&config.expand, "expand", false, "expand ranges like {1..100} and sets like {a,b,c} in each line into objects, up to -max-objects of a line")
+	fs.BoolVar(&config.dedupe, "dedupe", false, "skip objects repeated in a list, or in files and objects given by -url, -cpcode or -tag together")
+	fs.BoolVar(&config.sort, "sort", false, "sort objects in each request for stable, diffable request bodies, at the cost of sorting every chunk")
+	fs.BoolVar(&config.failFast, "fail-fast", false, "stop queuing and retrying requests on the first one failed, returning its error")
+	fs.BoolVar(&config.strict, "strict", false, "fail on invalid input instead of skipping it with a warning")
+	fs.Float64Var(&config.rps, "rps", 0, "specify a maximum number of requests per second(0 means unlimited)")
+	fs.IntVar(&config.maxObjects, "max-objects", defaultMaxObjects, "specify a maximum number of objects per request")
+	fs.IntVar(&config.maxTotal, "max-total-objects", 0, "specify a maximum number of objects of the whole input, aborting before sending anything for files(0 means unlimited)")
+	fs.Var(&config.retryOn, "retry-on", "specify comma-separated HTTP status codes to retry on(default \""+defaultRetryOn.String()+"\")")
+	fs.StringVar(&config.jitter, "jitter", defaultJitter, "specify a jitter strategy of retry backoff(full, equal, decorrelated or none)")
+	fs.BoolVar(&config.noJitter, "no-jitter", false, "back off purely exponentially without randomness, same as -jitter none")
+	fs.Int64Var(&config.seed, "seed", 0, "specify a seed of the randomness of jitter, making delays reproducible(random when not given)")
+	fs.Int64Var(&config.seed, "retry-jitter-seed", 0, "same as -seed")
+	fs.DurationVar(&config.maxDelay, "max-delay", 0, "specify a maximum delay between retries(0 means up to 24h)")
+	fs.DurationVar(&config.startupJitter, "startup-jitter", 0, "specify a maximum random delay before the first attempt of each request, spreading the initial burst(0 disables it)")
+	fs.BoolVar(&config.adaptive, "adaptive", false, "adjust requests in flight between -min-concurrency and -max-concurrency, growing on successes and halving on rate limiting or server errors")
+	fs.BoolVar(&config.throttleGlobal, "throttle-on-429-global", false, "pause sending of all requests on a 429 for its Retry-After, or the retry delay without one, instead of backing off the rate limited request alone")
+	fs.IntVar(&config.quota, "quota", 0, "specify a daily purge quota of objects of the account, warning when objects submitted approach or exceed it(0 disables it)")
+	fs.StringVar(&config.quotaFile, "quota-file", "", "specify a file to carry the total of objects submitted today over runs, for -quota")
+	fs.BoolVar(&config.quotaAbort, "quota-abort", false, "stop submitting instead of warning when -quota would be exceeded")
+	fs.BoolVar(&config.verify, "verify", false, "check with HEAD requests that purged URLs are no longer cached at the edge, by X-Cache")
+	fs.BoolVar(&config.verifyWait, "verify-wait", false, "wait estimatedSeconds of purges before -verify")
+	fs.IntVar(&config.maxLineBytes, "max-line-bytes", defaultMaxLineBytes, "specify the longest line of a list, longer ones are skipped as invalid")
+	fs.IntVar(&config.maxInFlightBytes, "max-in-flight-bytes", 0, "specify a maximum total size of request bodies in flight, bounding memory independently of concurrency(0 means unlimited)")
+	fs.IntVar(&config.minConcurrency, "min-concurrency", defaultMinConcurrency, "specify the number of requests in flight -adaptive starts with and never goes below")
+	fs.IntVar(&config.maxConcurrency, "max-concurrency", defaultMaxConcurrency, "specify the number of requests in flight -adaptive never goes above")
+	fs.IntVar(&config.warmup, "warmup", 0, "specify a number of connections to the purge host to open before the first request, so that the initial burst doesn't wait for TLS handshakes(0 disables it)")
+	fs.IntVar(&config.maxHostRetries, "max-retries-per-host", 0, "specify a maximum number of retries per API host across requests, after which requests to it fail fast while other hosts go on(0 means unlimited)")
+	fs.IntVar(&config.breakerThreshold, "breaker-threshold", defaultBreakerThreshold, "specify consecutive failures across requests after which remaining requests fail fast(0 disables it)")
+	fs.DurationVar(&config.breakerCooldown, "breaker-cooldown", defaultBreakerCooldown, "specify how long requests fail fast before a probe request is tried")
+	fs.DurationVar(&config.deadline, "deadline", 0, "specify a time limit of the whole run(e.g. \"10m\", 0 means unlimited), requests in flight are cancelled when it expires")
+	fs.DurationVar(&config.interval, "interval", 0, "specify an interval to re-read and purge the files again until interrupted(e.g. \"5m\", 0 purges them once)")
+	fs.BoolVar(&config.autoSplit, "auto-split", false, "halve a request body rejected with 400 for too many objects or its size, and send the halves instead")
+	fs.BoolVar(&config.compress, "compress", false, "gzip request bodies with Content-Encoding: gzip, falling back to plain ones if the gateway rejects them")
+	fs.IntVar(&config.maxBody, "max-body-size", defaultMaxBodySize, "specify a maximum request body size in bytes")
+	fs.StringVar(&config.basePath, "base-path", defaultBasePath, "specify the path of Fast Purge API, e.g. for a mock or another API version")
+	fs.StringVar(&config.statePath, "state", "", "specify a file to record objects purged by the run in, for -diff of the next run")
+	fs.StringVar(&config.checkpointPath, "checkpoint", "", "specify a file to record chunks of the input purged so far in, for -resume of an interrupted run")
+	fs.BoolVar(&config.resume, "resume", false, "skip chunks recorded in -checkpoint by an interrupted run of the same input and flags, purging only the rest")
+	fs.BoolVar(&config.diff, "diff", false, "skip objects purged by the last run recorded in -state, submitting only added ones")
+	fs.StringVar(&config.retryPath, "retry-file", "", "specify a file to write objects of failed requests to, as a list to give back to the next run(JSON lines of bodies for -t json)")
+	fs.StringVar(&config.beforeCmd, "before-cmd", "", "specify a shell command to run before submitting, a failure of which aborts the purge")
+	fs.StringVar(&config.afterCmd, "after-cmd", "", "specify a shell command to run after the purge, reading the summary as JSON on stdin")
+	fs.StringVar(&config.output, "output", "", "specify a file to write per-request results as JSON lines(\"-\" for stdout)")
+	fs.StringVar(&config.metricsAddr, "metrics-addr", "", "specify an address to expose Prometheus metrics on(e.g. \":9090\")")
+	fs.Var(&config.urls, "url", "specify a URL to purge instead of files, can be repeated")
+	fs.Var(&config.cpcodes, "cpcode", "specify a CP code to purge instead of files, can be repeated")
+	fs.Var(&config.tags, "tag", "specify a cache tag to purge instead of files, can be repeated")
+	fs.Var(&config.headers, "header", "specify a header to add to every purge request as \"Key: Value\", e.g. a correlation ID for tracing, can be repeated")
+	fs.BoolVar(&config.testCreds, "test-credentials", false, "check credentials are accepted by Fast Purge with a request purging nothing, and exit")
+	fs.BoolVar(&config.wildcard, "wildcard", false, "allow * in paths of URLs for products supporting wildcard purges, requires -yes on production network")
+	fs.BoolVar(&config.yes, "yes", false, "skip the confirmation of deleting objects from production network")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags] [file ...]\n", name)
+		if len(config.objectType) == 0 {
+			// Without a subcommand, list them
+			fmt.Fprintf(fs.Output(), "       %s url|cpcode|tag [flags] [file ...]\n", name)
+			fmt.Fprintf(fs.Output(), "       %s status [flags] <purgeId>\n", name)
+			fmt.Fprintf(fs.Output(), "       %s doctor [flags]\n", name)
+		}
+		fmt.Fprintln(fs.Output(), "\nFlags:")
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// flagGiven reports whether the flag name is given explicitly on the command line
+func flagGiven(fs *flag.FlagSet, name string) (given bool) {
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			given = true
+		}
+	})
+	return given
+}
+
+// applyEnvDefaults takes edgerc path and section from AKAMAI_EDGERC and AKAMAI_EDGERC_SECTION,
+// like the official Akamai CLI does, unless -c or -s is given explicitly
+func applyEnvDefaults(config *Config, fs *flag.FlagSet) {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	if edgerc := os.Getenv("AKAMAI_EDGERC"); len(edgerc) > 0 && !set["c"] {
+		config.edgerc = edgerc
+	}
+	if section := os.Getenv("AKAMAI_EDGERC_SECTION"); len(section) > 0 && !set["s"] {
+		config.section = section
+	}
+}
+
+// setup applies the -config file and environment defaults, and configures logging, which every subcommand does first
+func setup(config *Config, fs *flag.FlagSet) error {
+	if err := applyConfigFile(config, fs); err != nil {
+		return err
+	}
+	applyEnvDefaults(config, fs)
+	if err := setLogLevel(config); err != nil {
+		return err
+	}
+	if err := setLogFormat(config); err != nil {
+		return err
+	}
+	return setLogOutput(config)
+}
+
+// prepare loads credentials, validates config, and sets up outputs and the HTTP client for a run.
+// Call cleanup once the run finishes
+func prepare(config *Config, fs *flag.FlagSet, stdout io.Writer) (cleanup func(), err error) {
+	cleanup = func() {}
+	if err := setup(config, fs); err != nil {
+		return cleanup, err
+	}
+	cleanup = config.closeLog
+	if len(config.runID) == 0 {
+		config.runID = uuid.New().String()
+	}
+	log.WithField("run_id", config.runID).Debug("[Run]")
+
+	config.sectionMethod = !flagGiven(fs, "m")
+	config.sectionNetwork = !flagGiven(fs, "n")
+	if err := loadSections(config); err != nil {
+		return cleanup, err
+	}
+	config.detectType = !flagGiven(fs, "t")
+	if len(config.jsonPointer) > 0 {
+		// Objects are extracted as a list, which isn't of Fast Purge bodies
+		if flagGiven(fs, "t") {
+			return cleanup, errors.New("you should specify -json-pointer without -t, it reads JSON input as a list")
+		}
+		if _, err := parseJSONPath(config.jsonPointer); err != nil {
+			return cleanup, err
+		}
+		config.fileType, config.detectType = "text", false
+	}
+	config.files = fs.Args()
+	if len(config.listFile) > 0 {
+		listed, err := readListFile(config.listFile)
+		if err != nil {
+			return cleanup, err
+		}
+		config.files = append(config.files, listed...)
+	}
+
+	switch config.output {
+	case "":
+	case "-":
+		if config.logFile == "stdout" {
+			return cleanup, errors.New("you should specify -log-file other than stdout with -output -, which writes results to stdout")
+		}
+		config.results = newResultWriter(stdout)
+	default:
+		outputPath, err := homedir.Expand(config.output)
+		if err != nil {
+			return cleanup, err
+		}
+		out, err := os.Create(outputPath)
+		if err != nil {
+			return cleanup, err
+		}
+		closeLog := cleanup
+		cleanup = func() {
+			out.Close()
+			closeLog()
+		}
+		config.results = newResultWriter(out)
+	}
+
+	// Share one client, and so its connections, between remote lists and purge requests
+	client, err := newHTTPClient(config)
+	if err != nil {
+		return cleanup, err
+	}
+	config.client = client
+
+	if err := resolveArgObjects(config, fs); err != nil {
+		return cleanup, err
+	}
+	if config.interval > 0 && (len(config.files) == 0 || readsStdin(config.files)) {
+		return cleanup, errors.New("you should specify files with -interval, which are re-read every cycle")
+	}
+
+	// Before anything depending on -hostname, which the template may set
+	if len(config.bodyTemplatePath) > 0 {
+		template, err := loadBodyTemplate(config.bodyTemplatePath)
+		if err != nil {
+			return cleanup, err
+		}
+		if config.bodyTemplate, err = applyBodyTemplate(config, template); err != nil {
+			return cleanup, err
+		}
+	}
+
+	if config.verifyWait && !config.verify {
+		return cleanup, errors.New("you should specify -verify with -verify-wait")
+	}
+	if config.verify {
+		if kind := config.objectKind(); kind != "url" && kind != "path" {
+			return cleanup, fmt.Errorf("you should specify -verify with URLs, %s can't be requested at the edge", kind)
+		}
+		if config.interval > 0 {
+			return cleanup, errors.New("you should specify -verify without -interval")
+		}
+		config.verifier = newEdgeVerifier(config.hostname)
+	}
+
+	// Tests set their own source. Before the sampler of -sample, which picks by it too
+	if config.rand == nil {
+		if !flagGiven(fs, "seed") && !flagGiven(fs, "retry-jitter-seed") {
+			config.seed = time.Now().UnixNano()
+		}
+		config.rand = newSeededRand(config.seed)
+		config.randPerRequest = true
+	}
+	if config.sample > 0 && (!config.explain || config.fileType == "json") {
+		return cleanup, errors.New("you should specify -sample with -explain and lists, it never sends anything")
+	}
+	if config.previewCount && config.explain {
+		return cleanup, errors.New("you should specify -preview-count or -explain, not both")
+	}
+	if config.explain || config.previewCount {
+		config.explainer = newExplainer(stdout)
+		config.explainer.countOnly = config.previewCount
+		if config.sample > 0 {
+			config.explainer.sampler = newSampler(config.sample, config.randOrDefault())
+			config.explainer.sampler.validate = config.checkObject
+		}
+	}
+
+	if len(config.replaceHosts) > 0 && config.objectKind() != "url" {
+		return cleanup, fmt.Errorf("you should specify -replace-host with URLs, %s have no host", config.objectKind())
+	}
+	// Stdin may turn out to be JSON yet
+	if config.flattenJSON && config.fileType != "json" && !config.detectType {
+		return cleanup, errors.New("you should specify -flatten-json with JSON bodies, lists are chunked already")
+	}
+	if config.groupByHost && config.objectKind() != "url" {
+		return cleanup, fmt.Errorf("you should specify -group-by-host with URLs, %s have no host", config.objectKind())
+	}
+	if config.wildcard {
+		if kind := config.objectKind(); kind != "url" && kind != "path" {
+			return cleanup, fmt.Errorf("you should specify -wildcard with URLs, %s can't have wildcards", kind)
+		}
+		log.Warn("-wildcard is set, a wildcard object purges everything it matches, which is broad and expensive for the origin")
+		if purgesProduction(config) && !config.yes && config.explainer == nil {
+			return cleanup, errors.New("purging wildcard objects from production network requires -yes")
+		}
+	}
+
+	// Nothing is deleted by -explain or -preview-count
+	if needsConfirmation(config) && !config.yes && config.explainer == nil {
+		// The prompt reads stdin, so it can't be answered when stdin is the input or not a terminal
+		if (len(config.files) == 0 && len(config.objects) == 0) || readsStdin(config.files) || !isTerminal(os.Stdin) {
+			return cleanup, errors.New("deleting objects from production network requires -yes when not running interactively")
+		}
+		paths, err := expandPaths(config.files)
+		if err != nil {
+			return cleanup, err
+		}
+		if err := confirm(context.Background(), config, paths, os.Stdin, os.Stderr); err != nil {
+			return cleanup, err
+		}
+	}
+
+	if config.maxTotal > 0 {
+		// Files can be counted before sending anything, stdin is only capped as it is read
+		if len(config.files) > 0 || len(config.objects) > 0 {
+			paths, err := expandPaths(config.files)
+			if err != nil {
+				return cleanup, err
+			}
+			total, err := countPaths(context.Background(), config, paths)
+			if err != nil {
+				return cleanup, err
+			}
+			if total > config.maxTotal {
+				return cleanup, fmt.Errorf("%d objects exceed -max-total-objects %d, nothing was purged", total, config.maxTotal)
+			}
+		}
+		config.budget = newObjectBudget(config.maxTotal)
+	}
+
+	if config.diff && len(config.statePath) == 0 {
+		return cleanup, errors.New("you should specify -state with -diff, which records objects purged by the last run")
+	}
+	if len(config.statePath) > 0 {
+		// An object purged by one section or network but not the other can't be told apart in the state
+		if len(sectionNames(config.section)) > 1 || config.network == "both" || config.method == "both" {
+			return cleanup, errors.New("you should specify -state with a single section, method and network")
+		}
+		if config.fileType == "mixed" {
+			return cleanup, errors.New("you should specify -state with a single object type, not with -t mixed")
+		}
+		if config.diff && (config.fileType == "json" || config.interval > 0) {
+			return cleanup, errors.New("you should specify -diff with lists, it can't skip objects of JSON bodies or repeat with -interval")
+		}
+		if config.state, err = loadState(config.statePath, config.objectTypeOrDefault(), config.hostname, config.diff); err != nil {
+			return cleanup, err
+		}
+	}
+
+	if config.resume && len(config.checkpointPath) == 0 {
+		return cleanup, errors.New("you should specify -checkpoint with -resume, which records chunks purged by the interrupted run")
+	}
+	if len(config.checkpointPath) > 0 {
+		// Chunks are numbered by reading the input, which has to be read alike again to resume
+		if (len(config.files) == 0 && len(config.objects) == 0) || readsStdin(config.files) || config.interval > 0 {
+			return cleanup, errors.New("you should specify -checkpoint with files, which are hashed to resume the same input, not with stdin or -interval")
+		}
+		if len(sectionNames(config.section)) > 1 || config.network == "both" || config.method == "both" {
+			return cleanup, errors.New("you should specify -checkpoint with a single section, method and network")
+		}
+		if len(config.statePath) > 0 || config.explainer != nil {
+			return cleanup, errors.New("you should specify -checkpoint without -state, -explain or -preview-count")
+		}
+		paths, err := expandPaths(config.files)
+		if err != nil {
+			return cleanup, err
+		}
+		hash, err := hashInput(context.Background(), config, paths)
+		if err != nil {
+			return cleanup, err
+		}
+		if config.checkpoint, err = loadCheckpoint(config.checkpointPath, hash, config.resume, config.clockOrDefault().Now()); err != nil {
+			return cleanup, err
+		}
+	}
+
+	if config.rps > 0 {
+		config.limiter = newRateLimiter(config.rps)
+	}
+	if config.rateReport {
+		config.rates = newRateRecorder()
+	}
+	if config.compress {
+		config.compression = newCompression()
+	}
+	if len(config.retryPath) > 0 {
+		// Objects are written without their types, which a retry of -t mixed couldn't tell
+		if config.fileType == "mixed" {
+			return cleanup, errors.New("you should specify -retry-file with a single object type, not with -t mixed")
+		}
+		if config.retries, err = newRetryFile(config.retryPath, config.fileType == "json"); err != nil {
+			return cleanup, err
+		}
+	}
+	// Failures are counted per host even without a limit, to tell sections apart in the summary
+	config.hostRetries = newHostRetries(config.maxHostRetries)
+	if log.IsLevelEnabled(logrus.DebugLevel) {
+		config.connStats = &connStats{}
+	}
+	if config.explainAuth {
+		config.authExplainer = newAuthExplainer(os.Stderr)
+	}
+	if config.trace && !log.IsLevelEnabled(logrus.DebugLevel) {
+		log.Warn("-trace logs at debug level, give -l debug to see it")
+	}
+	if config.breakerThreshold > 0 {
+		config.breaker = newBreaker(config.breakerThreshold, config.breakerCooldown)
+	}
+	if config.adaptive {
+		config.concurrency = newAdaptiveLimiter(config.minConcurrency, config.maxConcurrency)
+	}
+	if config.throttleGlobal {
+		config.cooldown = newCooldown()
+	}
+	if config.maxInFlightBytes > 0 {
+		config.inFlightBytes = newByteLimiter(config.maxInFlightBytes)
+	}
+	if len(config.quotaFile) > 0 && config.quota == 0 {
+		return cleanup, errors.New("you should specify -quota with -quota-file")
+	}
+	// -explain and -preview-count send nothing, which uses none of the quota
+	if config.quota > 0 && config.explainer == nil {
+		if config.quotaUsage, err = newQuotaTracker(config.quota, config.quotaAbort, config.quotaFile, config.clockOrDefault().Now()); err != nil {
+			return cleanup, err
+		}
+	}
+
+	// Progress would only clutter redirected logs
+	if config.showProgress && isTerminal(os.Stderr) {
+		config.progress = newProgress(os.Stderr)
+		// Logs elsewhere by -log-file don't break the progress line
+		if config.logsToStderr() {
+			out := log.Out
+			log.SetOutput(config.progress)
+			closeOutput := cleanup
+			cleanup = func() {
+				log.SetOutput(out)
+				closeOutput()
+			}
+		}
+	}
+	return cleanup, nil
+}
+
+// run dispatches on the subcommand given as the first argument. "url", "cpcode" and "tag" purge objects of
+// the type from the given files, or stdin when no file is given, "status" queries a purge request, and
+// "doctor" checks the connection to the purge host.
+// Without a subcommand, it purges URLs as the command did before subcommands were added.
+// The returned error determines the exit code, see exitCode
+func run(args []string, stdout io.Writer) error {
 	var config Config
-	flag.StringVar(&config.edgerc, "c", defaultEdgerc, "specify a edgerc file")
-	flag.StringVar(&config.section, "s", defaultSection, "specify a config section")
-	flag.StringVar(&config.method, "m", defaultMethod, "specify a invalidation method(invalidate or delete)")
-	flag.StringVar(&config.network, "n", defaultNetwork, "specify a target network(akamai production or staging network)")
-	flag.StringVar(&config.fileType, "t", defaultFileType, "specify a invalidation list type(json or text)")
-	flag.StringVar(&config.logLevel, "l", defaultLogLevel, "specify log level(info or debug)")
-	flag.Parse()
+	name := os.Args[0]
+	if len(args) > 0 {
+		switch args[0] {
+		case "url", "cpcode", "tag":
+			config.objectType = args[0]
+			name += " " + args[0]
+			args = args[1:]
+		case "status":
+			return runStatus(name+" status", args[1:], stdout)
+		case "doctor":
+			return runDoctor(name+" doctor", args[1:], stdout)
+		}
+	}
+	fs := newFlagSet(&config, name)
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return configError(err)
+	}
 
-	err := setLogLevel(&config)
-	chkErr(err)
+	if config.showVersion {
+		fmt.Fprintln(stdout, versionString())
+		return nil
+	}
 
-	// Validate edgerc file
-	edgercPath, err := homedir.Expand(config.edgerc)
-	chkErr(err)
-	err = chkExist(edgercPath)
-	chkErr(err)
-	config.edgerc = edgercPath
+	if config.testCreds {
+		return runTestCredentials(&config, fs, stdout)
+	}
 
-	initEdgeConfig(&config)
+	cleanup, err := prepare(&config, fs, stdout)
+	defer cleanup()
+	if err != nil {
+		return configError(err)
+	}
 
-	err = Validation(&config)
-	chkErr(err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopSignal := cancelOnInterrupt(cancel)
+	defer stopSignal()
 
-	if flag.NArg() == 0 {
-		err = Invalidation(&config, os.Stdin)
+	if len(config.metricsAddr) > 0 {
+		config.metrics = newMetrics()
+		addr, err := serveMetrics(ctx, config.metricsAddr, config.metrics)
+		if err != nil {
+			return configError(err)
+		}
+		log.Infof("serving metrics on http://%s/metrics", addr)
+	}
+
+	if config.deadline > 0 {
+		var cancelDeadline context.CancelFunc
+		ctx, cancelDeadline = context.WithTimeout(ctx, config.deadline)
+		defer cancelDeadline()
+		config.deadlineAt, _ = ctx.Deadline()
+	}
+
+	if config.failFast {
+		var cancelRun context.CancelFunc
+		ctx, cancelRun = context.WithCancel(ctx)
+		defer cancelRun()
+		config.halt = newHalter(cancelRun)
+	}
+
+	config.tally = &Results{}
+	var in io.Reader = os.Stdin
+	switch {
+	case len(config.objects) > 0:
+		in = argInput(&config)
+		config.fileType = "text"
+	case len(config.files) == 0:
+		in = stdinInput(&config, in)
+	}
+	// Each section of -s, method of -m both and network of -n both gets its own copy of config, purging the same objects
+	targets := networkTargets(methodTargets(sectionTargets(&config)))
+	if config.explainer != nil {
+		// Targets split the input alike
+		if err := invalidateTargets(ctx, targets[:1], config.files, in); err != nil {
+			return configError(err)
+		}
+		config.explainer.finish()
+		return nil
+	}
+
+	// Keep stdout clean for results when they are written there
+	summaryOut := stdout
+	if config.output == "-" {
+		summaryOut = os.Stderr
+	}
+	if len(config.beforeCmd) > 0 {
+		if err := runHook(ctx, "-before-cmd", config.beforeCmd, nil, summaryOut); err != nil {
+			if ctx.Err() != nil {
+				return errInterrupted
+			}
+			return configError(fmt.Errorf("%s, nothing was purged", err))
+		}
+	}
+	if config.warmup > 0 {
+		warmUpTargets(ctx, targets, config.warmup)
+	}
+	if config.interval > 0 {
+		err = repeatTargets(ctx, targets, config.files, config.interval, summaryOut)
 	} else {
-		for i := 0; i < flag.NArg(); i++ {
-			invalidationRequestFile, err := homedir.Expand(flag.Arg(i))
-			chkErr(err)
-			in, err := os.Open(invalidationRequestFile)
-			chkErr(err)
-			err = Invalidation(&config, in)
-			in.Close()
-			chkErr(err)
+		err = invalidateTargets(ctx, targets, config.files, in)
+	}
+	var summary Summary
+	for _, target := range targets {
+		summary = summary.merge(target.tally.Summary())
+	}
+	// Targets share the controller of -adaptive, it is only in the total
+	summary.Concurrency, summary.Backoffs = config.concurrency.stats()
+	summary.Unchanged = config.state.unchangedObjects()
+	summary.Resumed = config.checkpoint.resumedObjects()
+	summary.Rate = config.rates.report()
+	summary.Breakdown = targetBreakdown(targets)
+	summary.HostFailures = config.hostRetries.hostFailures()
+	if config.verifier != nil && ctx.Err() == nil {
+		report := config.verifier.verify(ctx, &config)
+		fmt.Fprintln(summaryOut, "[Verify]", report)
+		for _, url := range report.cached {
+			fmt.Fprintln(summaryOut, "[Verify] still cached:", url)
+		}
+	}
+	// Keep the last state when nothing was read, e.g. the input is missing
+	if summary.Requests > 0 || summary.Unchanged > 0 {
+		if saveErr := config.state.save(config.clockOrDefault().Now()); saveErr != nil && err == nil {
+			err = fmt.Errorf("failed to save -state: %s", saveErr)
 		}
 	}
-	chkErr(err)
+	if saveErr := config.quotaUsage.save(); saveErr != nil && err == nil {
+		err = fmt.Errorf("failed to save -quota-file: %s", saveErr)
+	}
+	if closeErr := config.retries.close(err == nil && ctx.Err() == nil && summary.Failed == 0); closeErr != nil && err == nil {
+		err = fmt.Errorf("failed to write -retry-file: %s", closeErr)
+	}
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		summary.DeadlineExceeded = true
+		err = errDeadline
+	case config.halt.failure() != nil:
+		err = config.halt.failure()
+	case ctx.Err() != nil:
+		summary.Interrupted = true
+		err = errInterrupted
+	case err != nil && summary.Requests == 0:
+		// Nothing was submitted, e.g. the input is invalid
+		err = configError(err)
+	case err == nil && summary.Requests == 0 && summary.Unchanged == 0 && summary.Resumed == 0:
+		// Don't look successful when the input is empty or every object in it is skipped as invalid
+		err = configError(errNothingToPurge)
+	case err == nil && summary.Failed > 0:
+		err = errPurgeFailed
+	}
+	config.progress.finish()
+	if len(targets) > 1 {
+		for _, target := range targets {
+			fmt.Fprintf(summaryOut, "[Summary] %s: %s\n", target.targetName, target.tally.Summary())
+		}
+	}
+	fmt.Fprintln(summaryOut, "[Summary]", summary)
+	if config.connStats != nil {
+		log.Debugf("[Connections] %s", config.connStats)
+	}
+	runAfterHook(config.afterCmd, summary, summaryOut)
+	return err
+}
+
+func main() {
+	err := run(os.Args[1:], os.Stdout)
+	if err != nil && err != errInterrupted {
+		log.Error(err)
+	}
+	os.Exit(exitCode(err))
 }
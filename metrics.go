@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are upper bounds(seconds) of the request latency histogram
+var latencyBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// metrics collects request statistics and exposes them in the Prometheus text format.
+// All methods are no-op on a nil *metrics, so they can be called unconditionally.
+type metrics struct {
+	mu             sync.Mutex
+	requests       map[string]uint64 // by status class: "2xx", "4xx", "5xx", ..., or "error"
+	retries        uint64
+	inFlight       int64
+	latencyCounts  []uint64 // per bucket, not cumulative
+	latencySum     float64
+	latencyObserve uint64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests:      map[string]uint64{},
+		latencyCounts: make([]uint64, len(latencyBuckets)),
+	}
+}
+
+func statusClass(status int) string {
+	return fmt.Sprintf("%dxx", status/100)
+}
+
+// observeRequest records one HTTP attempt. status is ignored when err is not nil
+func (m *metrics) observeRequest(status int, err error, latency time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		m.requests["error"]++
+	} else {
+		m.requests[statusClass(status)]++
+	}
+	seconds := latency.Seconds()
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			m.latencyCounts[i]++
+			break
+		}
+	}
+	m.latencySum += seconds
+	m.latencyObserve++
+}
+
+func (m *metrics) incRetries() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.retries++
+	m.mu.Unlock()
+}
+
+func (m *metrics) addInFlight(delta int64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.inFlight += delta
+	m.mu.Unlock()
+}
+
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# HELP fastpurge_requests_total Fast Purge HTTP requests by status class.")
+	fmt.Fprintln(w, "# TYPE fastpurge_requests_total counter")
+	classes := make([]string, 0, len(m.requests))
+	for class := range m.requests {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	for _, class := range classes {
+		fmt.Fprintf(w, "fastpurge_requests_total{class=%q} %d\n", class, m.requests[class])
+	}
+
+	fmt.Fprintln(w, "# HELP fastpurge_request_duration_seconds Fast Purge HTTP request latency.")
+	fmt.Fprintln(w, "# TYPE fastpurge_request_duration_seconds histogram")
+	var cumulative uint64
+	for i, bound := range latencyBuckets {
+		cumulative += m.latencyCounts[i]
+		fmt.Fprintf(w, "fastpurge_request_duration_seconds_bucket{le=\"%g\"} %d\n", bound, cumulative)
+	}
+	fmt.Fprintf(w, "fastpurge_request_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.latencyObserve)
+	fmt.Fprintf(w, "fastpurge_request_duration_seconds_sum %g\n", m.latencySum)
+	fmt.Fprintf(w, "fastpurge_request_duration_seconds_count %d\n", m.latencyObserve)
+
+	fmt.Fprintln(w, "# HELP fastpurge_retries_total Fast Purge HTTP requests retried.")
+	fmt.Fprintln(w, "# TYPE fastpurge_retries_total counter")
+	fmt.Fprintf(w, "fastpurge_retries_total %d\n", m.retries)
+
+	fmt.Fprintln(w, "# HELP fastpurge_requests_in_flight Fast Purge HTTP requests currently in flight.")
+	fmt.Fprintln(w, "# TYPE fastpurge_requests_in_flight gauge")
+	fmt.Fprintf(w, "fastpurge_requests_in_flight %d\n", m.inFlight)
+}
+
+// serveMetrics exposes m on addr at /metrics until ctx is done
+func serveMetrics(ctx context.Context, addr string, m *metrics) (net.Addr, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	srv := &http.Server{Handler: mux}
+
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Errorf("metrics server stopped: %s", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	return ln.Addr(), nil
+}
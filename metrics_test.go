@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func scrape(t *testing.T, m *metrics) string {
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	return rec.Body.String()
+}
+
+func TestMetricsExposition(t *testing.T) {
+	m := newMetrics()
+	m.observeRequest(http.StatusCreated, nil, 200*time.Millisecond)
+	m.observeRequest(http.StatusCreated, nil, 3*time.Second)
+	m.observeRequest(http.StatusTooManyRequests, nil, 50*time.Millisecond)
+	m.observeRequest(0, errors.New("connection refused"), time.Minute)
+	m.incRetries()
+	m.addInFlight(2)
+	m.addInFlight(-1)
+
+	out := scrape(t, m)
+	for _, want := range []string{
+		`fastpurge_requests_total{class="2xx"} 2`,
+		`fastpurge_requests_total{class="4xx"} 1`,
+		`fastpurge_requests_total{class="error"} 1`,
+		`fastpurge_request_duration_seconds_bucket{le="0.1"} 1`,
+		`fastpurge_request_duration_seconds_bucket{le="0.25"} 2`,
+		`fastpurge_request_duration_seconds_bucket{le="5"} 3`,
+		`fastpurge_request_duration_seconds_bucket{le="+Inf"} 4`,
+		`fastpurge_request_duration_seconds_count 4`,
+		`fastpurge_retries_total 1`,
+		`fastpurge_requests_in_flight 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics should contain %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestMetricsNil(t *testing.T) {
+	var m *metrics
+	m.observeRequest(http.StatusCreated, nil, time.Second)
+	m.incRetries()
+	m.addInFlight(1)
+}
+
+func TestInvalidationRequestMetrics(t *testing.T) {
+	ts, _ := newTestServer(http.StatusTooManyRequests, http.StatusCreated)
+	defer ts.Close()
+
+	config := newTestConfig(ts)
+	config.metrics = newMetrics()
+	sendTestRequest(config)
+
+	out := scrape(t, config.metrics)
+	for _, want := range []string{
+		`fastpurge_requests_total{class="2xx"} 1`,
+		`fastpurge_requests_total{class="4xx"} 1`,
+		`fastpurge_retries_total 1`,
+		`fastpurge_requests_in_flight 0`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics should contain %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestServeMetricsShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	addr, err := serveMetrics(ctx, "127.0.0.1:0", newMetrics())
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	resp, err := http.Get("http://" + addr.String() + "/metrics")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "fastpurge_retries_total 0") {
+		t.Errorf("unexpected metrics response: %s", body)
+	}
+
+	cancel()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := http.Get("http://" + addr.String() + "/metrics"); err != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("metrics server is still serving after cancellation")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
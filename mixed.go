@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+)
+
+// objectTypes are types of objects a line of -t mixed can start with, each purged at its own endpoint
+var objectTypes = []string{"url", "cpcode", "tag"}
+
+// splitTypedLine returns the type a line of -t mixed starts with, like "cpcode 12345", and the object
+// after it. A line without a known type is an object of the default type, "" is returned for it
+func splitTypedLine(line string) (objectType, object string) {
+	s := strings.TrimSpace(line)
+	i := strings.IndexAny(s, " \t")
+	if i < 0 {
+		return "", line
+	}
+	for _, t := range objectTypes {
+		if s[:i] == t {
+			return t, strings.TrimSpace(s[i:])
+		}
+	}
+	return "", line
+}
+
+// objectGroup is objects of a type chunked into requests to the endpoint of the type, by config of it
+type objectGroup struct {
+	config   *Config
+	chunkers *hostChunkers
+}
+
+// objectGroups keeps a group per object type, so that objects of -t mixed are batched per type.
+// Without -t mixed, there is the group of the default type alone
+type objectGroups struct {
+	config     *Config
+	maxBody    int
+	maxObjects int
+	byType     map[string]*objectGroup
+	types      []string // in the order seen first
+}
+
+func newObjectGroups(config *Config, maxBody, maxObjects int) *objectGroups {
+	return &objectGroups{config: config, maxBody: maxBody, maxObjects: maxObjects, byType: map[string]*objectGroup{}}
+}
+
+// of returns the group of objectType, "" for the default type. Groups of other types get a copy of
+// config purging objects of the type
+func (g *objectGroups) of(objectType string) *objectGroup {
+	if len(objectType) == 0 {
+		objectType = g.config.objectTypeOrDefault()
+	}
+	if group, ok := g.byType[objectType]; ok {
+		return group
+	}
+	config := g.config
+	if objectType != config.objectTypeOrDefault() {
+		typed := *config
+		typed.objectType = objectType
+		config = &typed
+	}
+	overHead := jsonOverHead
+	if len(config.hostname) > 0 {
+		overHead += len(`"hostname":,`) + jsonStringLen(config.hostname)
+	}
+	overHead += templateOverHead(config.bodyTemplate)
+	group := &objectGroup{
+		config: config,
+		chunkers: newHostChunkers(config.groupByHost && config.objectKind() == "url", func() *chunker {
+			return newChunker(g.maxBody, g.maxObjects, overHead)
+		}),
+	}
+	g.byType[objectType] = group
+	g.types = append(g.types, objectType)
+	return group
+}
+
+// all returns groups in the order their types were seen first
+func (g *objectGroups) all() []*objectGroup {
+	groups := make([]*objectGroup, len(g.types))
+	for i, t := range g.types {
+		groups[i] = g.byType[t]
+	}
+	return groups
+}
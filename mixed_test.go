@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSplitTypedLine(t *testing.T) {
+	for line, want := range map[string][2]string{
+		"url https://example.com/a": {"url", "https://example.com/a"},
+		"cpcode\t12345 ":            {"cpcode", "12345"},
+		"  tag  product-123":        {"tag", "product-123"},
+		"https://example.com/b":     {"", "https://example.com/b"},
+		"video 12345":               {"", "video 12345"},
+		"tag":                       {"", "tag"},
+	} {
+		if objectType, object := splitTypedLine(line); objectType != want[0] || object != want[1] {
+			t.Errorf("%q: expected %q, got %q, %q", line, want, objectType, object)
+		}
+	}
+}
+
+func TestInvalidateMixed(t *testing.T) {
+	ts, rec := newTestServer(http.StatusCreated)
+	defer ts.Close()
+
+	config := newTestConfig(ts)
+	config.fileType = "mixed"
+	config.maxObjects = 2
+	in := "url https://example.com/a\ncpcode 12345\ntag product-1\n" +
+		"https://example.com/b\ncpcode 67890\ncpcode 11111\n" +
+		"tag product-2\nurl https://example.com/c\n" +
+		// An unknown type is of the default type, which makes it invalid here
+		"video 22222\n"
+	if err := Invalidation(context.Background(), config, strings.NewReader(in)); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for i, req := range rec.requests {
+		got = append(got, req.URL.Path+" "+string(rec.bodies[i]))
+	}
+	// Requests of types are sent concurrently, so they arrive in any order
+	sort.Strings(got)
+	want := []string{
+		`/ccu/v3/invalidate/cpcode/staging {"objects":[11111]}`,
+		`/ccu/v3/invalidate/cpcode/staging {"objects":[12345,67890]}`,
+		`/ccu/v3/invalidate/tag/staging {"objects":["product-1","product-2"]}`,
+		`/ccu/v3/invalidate/url/staging {"objects":["https://example.com/a","https://example.com/b"]}`,
+		`/ccu/v3/invalidate/url/staging {"objects":["https://example.com/c"]}`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected requests batched per type\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+}
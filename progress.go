@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// eraseLine moves the cursor to the line head and clears the line
+const eraseLine = "\r\033[K"
+
+// progress draws a single status line of a run on a terminal. Logs are written through it as well,
+// so the status line is erased before and redrawn after each of them instead of being mixed in.
+// All methods are no-op on a nil *progress.
+type progress struct {
+	mu        sync.Mutex
+	out       io.Writer
+	queued    int
+	completed int
+	failed    int
+}
+
+func newProgress(out io.Writer) *progress {
+	return &progress{out: out}
+}
+
+// draw must be called with mu held
+func (p *progress) draw() {
+	fmt.Fprintf(p.out, "%s[Progress] submitted: %d, completed: %d, failed: %d", eraseLine, p.queued, p.completed, p.failed)
+}
+
+// queue counts a request about to be sent
+func (p *progress) queue() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.queued++
+	p.draw()
+}
+
+// drop forgets a request replaced by others, e.g. halved by -auto-split
+func (p *progress) drop() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.queued--
+	p.draw()
+}
+
+// done counts a finished request
+func (p *progress) done(result PurgeResult) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.completed++
+	if !result.Succeeded() {
+		p.failed++
+	}
+	p.draw()
+}
+
+// Write writes a log entry above the status line
+func (p *progress) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	io.WriteString(p.out, eraseLine)
+	n, err := p.out.Write(b)
+	p.draw()
+	return n, err
+}
+
+// finish leaves the last status line on the terminal
+func (p *progress) finish() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.draw()
+	io.WriteString(p.out, "\n")
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestProgress(t *testing.T) {
+	var out bytes.Buffer
+	p := newProgress(&out)
+	p.queue()
+	p.queue()
+	p.done(PurgeResult{StatusCode: http.StatusCreated})
+	p.done(PurgeResult{StatusCode: http.StatusForbidden})
+
+	if got := out.String(); !strings.HasSuffix(got, eraseLine+"[Progress] submitted: 2, completed: 2, failed: 1") {
+		t.Errorf("unexpected progress line: %q", got)
+	}
+
+	// A log entry erases the status line, then it is redrawn below the entry
+	out.Reset()
+	p.Write([]byte("level=error msg=\"[Failed]\"\n"))
+	want := eraseLine + "level=error msg=\"[Failed]\"\n" + eraseLine + "[Progress] submitted: 2, completed: 2, failed: 1"
+	if got := out.String(); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	out.Reset()
+	p.finish()
+	if got := out.String(); !strings.HasSuffix(got, "\n") {
+		t.Errorf("finish should end the status line: %q", got)
+	}
+
+	var nilProgress *progress
+	nilProgress.queue()
+	nilProgress.done(PurgeResult{})
+	nilProgress.finish()
+}
+
+func TestInvalidationRequestProgress(t *testing.T) {
+	ts, _ := newTestServer(http.StatusCreated)
+	defer ts.Close()
+
+	var out bytes.Buffer
+	config := newTestConfig(ts)
+	config.progress = newProgress(&out)
+	sendTestRequest(config)
+	if got := out.String(); !strings.HasSuffix(got, "submitted: 1, completed: 1, failed: 0") {
+		t.Errorf("unexpected progress line: %q", got)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// quotaWarnRatio of -quota is when objects submitted approach it
+const quotaWarnRatio = 0.9
+
+// quotaFile is the content of -quota-file, the running total of objects submitted on a day in UTC
+type quotaFile struct {
+	Day     string `json:"day"`
+	Objects int    `json:"objects"`
+}
+
+// quotaTracker counts objects submitted against -quota, a soft daily limit of the account, warning when
+// it is approached or exceeded, or stopping the run under -quota-abort. The total is carried over runs
+// of the same day by -quota-file. It is shared by all targets. A nil *quotaTracker counts nothing
+type quotaTracker struct {
+	mu       sync.Mutex
+	limit    int
+	abort    bool
+	path     string // "" counts the run alone
+	day      string
+	used     int
+	approach bool // warned about approaching the quota
+	exceeded bool // warned about exceeding it
+}
+
+// newQuotaTracker starts from the total of -quota-file when it is of the same day as now
+func newQuotaTracker(limit int, abort bool, path string, now time.Time) (*quotaTracker, error) {
+	q := &quotaTracker{limit: limit, abort: abort, day: now.UTC().Format("2006-01-02")}
+	if len(path) == 0 {
+		return q, nil
+	}
+	path, err := homedir.Expand(path)
+	if err != nil {
+		return nil, err
+	}
+	q.path = path
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var last quotaFile
+	if err := json.Unmarshal(data, &last); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if last.Day == q.day {
+		q.used = last.Objects
+	}
+	return q, nil
+}
+
+// take counts n objects about to be submitted. Under -quota-abort it fails without counting them when
+// they would exceed the quota
+func (q *quotaTracker) take(n int) error {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.used+n > q.limit {
+		if q.abort {
+			return fmt.Errorf("objects today would exceed -quota %d, stopped after submitting %d objects today", q.limit, q.used)
+		}
+		if !q.exceeded {
+			q.exceeded = true
+			log.Warnf("objects submitted today exceed -quota %d: %d", q.limit, q.used+n)
+		}
+	} else if !q.approach && float64(q.used+n) >= quotaWarnRatio*float64(q.limit) {
+		q.approach = true
+		log.Warnf("objects submitted today approach -quota %d: %d", q.limit, q.used+n)
+	}
+	q.used += n
+	return nil
+}
+
+// save writes the total of the day to -quota-file
+func (q *quotaTracker) save() error {
+	if q == nil || len(q.path) == 0 {
+		return nil
+	}
+	q.mu.Lock()
+	data, err := json.Marshal(quotaFile{Day: q.day, Objects: q.used})
+	q.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(q.path, append(data, '\n'))
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestQuotaTrackerWarns(t *testing.T) {
+	hook, restore := captureLog()
+	defer restore()
+
+	q, err := newQuotaTracker(10, false, "", time.Now())
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	warnings := func() (messages []string) {
+		for _, entry := range hook.AllEntries() {
+			if entry.Level == logrus.WarnLevel {
+				messages = append(messages, entry.Message)
+			}
+		}
+		return messages
+	}
+	for _, n := range []int{5, 3} {
+		if err := q.take(n); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+	if got := warnings(); len(got) != 0 {
+		t.Errorf("80%% of the quota should not warn, got %q", got)
+	}
+	for _, n := range []int{1, 0} {
+		if err := q.take(n); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+	if got := warnings(); len(got) != 1 || !strings.Contains(got[0], "approach -quota 10: 9") {
+		t.Errorf("approaching the quota should warn once, got %q", got)
+	}
+	for _, n := range []int{2, 2} {
+		if err := q.take(n); err != nil {
+			t.Errorf("exceeding the quota should only warn, got %v", err)
+		}
+	}
+	if got := warnings(); len(got) != 2 || !strings.Contains(got[1], "exceed -quota 10: 11") {
+		t.Errorf("exceeding the quota should warn once, got %q", got)
+	}
+
+	var disabled *quotaTracker
+	if err := disabled.take(1 << 20); err != nil {
+		t.Errorf("a nil tracker should count nothing, got %v", err)
+	}
+}
+
+func TestQuotaTrackerAborts(t *testing.T) {
+	q, err := newQuotaTracker(3, true, "", time.Now())
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := q.take(2); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := q.take(2); err == nil || !strings.Contains(err.Error(), "stopped after submitting 2 objects") {
+		t.Errorf("exceeding the quota should stop under -quota-abort, got %v", err)
+	}
+	if err := q.take(1); err != nil {
+		t.Errorf("a refused take should not count, got %v", err)
+	}
+}
+
+func TestQuotaTrackerPersists(t *testing.T) {
+	dir, err := ioutil.TempDir("", "purge-quota")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "quota.json")
+
+	day := time.Date(2020, 4, 1, 23, 0, 0, 0, time.UTC)
+	q, err := newQuotaTracker(10, true, path, day)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := q.take(6); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := q.save(); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	q, err = newQuotaTracker(10, true, path, day.Add(30*time.Minute))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := q.take(5); err == nil {
+		t.Errorf("a run of the same day should count objects of the previous one")
+	}
+
+	q, err = newQuotaTracker(10, true, path, day.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := q.take(10); err != nil {
+		t.Errorf("a run of the next day should start afresh, got %v", err)
+	}
+}
+
+func TestRunQuotaFile(t *testing.T) {
+	ts, rec := newTestServer(http.StatusCreated)
+	defer ts.Close()
+	setEdgegridEnv(t)
+	defer unsetEdgegridEnv()
+	os.Setenv("AKAMAI_HOST", strings.TrimPrefix(ts.URL, "https://"))
+	defer log.SetLevel(log.Level)
+
+	dir, err := ioutil.TempDir("", "purge-quota-run")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+	input := filepath.Join(dir, "urls.txt")
+	if err := ioutil.WriteFile(input, []byte(strings.Repeat("https://example.com/a\n", 3)), 0644); err != nil {
+		t.Fatalf("%s", err)
+	}
+	args := []string{"-insecure", "-quota", "5", "-quota-abort", "-quota-file", filepath.Join(dir, "quota.json"), input}
+
+	if got := exitCode(run(args, &bytes.Buffer{})); got != exitOK {
+		t.Fatalf("expected exit code %d within the quota, got %d", exitOK, got)
+	}
+	if got := exitCode(run(args, &bytes.Buffer{})); got == exitOK {
+		t.Errorf("a second run over the quota of the day should fail")
+	}
+	if rec.count() != 1 {
+		t.Errorf("the second run should submit nothing, got %d requests in total", rec.count())
+	}
+}
+
+func TestRunQuotaPreviewCount(t *testing.T) {
+	ts, rec := newTestServer(http.StatusCreated)
+	defer ts.Close()
+	setEdgegridEnv(t)
+	defer unsetEdgegridEnv()
+	os.Setenv("AKAMAI_HOST", strings.TrimPrefix(ts.URL, "https://"))
+	defer log.SetLevel(log.Level)
+
+	dir, err := ioutil.TempDir("", "purge-quota-preview")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+	input := filepath.Join(dir, "urls.txt")
+	if err := ioutil.WriteFile(input, []byte(strings.Repeat("https://example.com/a\n", 3)), 0644); err != nil {
+		t.Fatalf("%s", err)
+	}
+	quotaFile := filepath.Join(dir, "quota.json")
+
+	// Counting an input over -quota sends nothing, so it neither fails nor uses the quota
+	var out bytes.Buffer
+	if err := run([]string{"-insecure", "-preview-count", "-quota", "2", "-quota-abort", "-quota-file", quotaFile, input}, &out); err != nil {
+		t.Fatalf("-preview-count over -quota should succeed, got %s", err)
+	}
+	if !strings.Contains(out.String(), "objects: 3") {
+		t.Errorf("expected every object counted, got %q", out.String())
+	}
+	if _, err := os.Stat(quotaFile); !os.IsNotExist(err) {
+		t.Errorf("-preview-count shouldn't record usage of the quota, got %v", err)
+	}
+	if rec.count() != 0 {
+		t.Errorf("-preview-count should send nothing, got %d requests", rec.count())
+	}
+}
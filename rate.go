@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RateReport is the request rate achieved by a run and latencies of its requests, from queuing to
+// completion including retries. It tells whether -rps could go higher or is hitting rate limits
+type RateReport struct {
+	RequestsPerSecond float64       `json:"requests_per_second"`
+	LatencyAvg        time.Duration `json:"latency_avg_ns"`
+	LatencyP50        time.Duration `json:"latency_p50_ns"`
+	LatencyP95        time.Duration `json:"latency_p95_ns"`
+	LatencyP99        time.Duration `json:"latency_p99_ns"`
+}
+
+func (r RateReport) String() string {
+	return fmt.Sprintf("rate: %.2f requests/s, latency avg: %s, p50: %s, p95: %s, p99: %s",
+		r.RequestsPerSecond, r.LatencyAvg, r.LatencyP50, r.LatencyP95, r.LatencyP99)
+}
+
+// rateRecorder collects timings of requests for -rate-report. It is shared by request goroutines
+// of every target. A nil *rateRecorder records nothing
+type rateRecorder struct {
+	mu        sync.Mutex
+	first     time.Time // start of the earliest request
+	last      time.Time // end of the latest request
+	latencies []time.Duration
+}
+
+func newRateRecorder() *rateRecorder {
+	return &rateRecorder{}
+}
+
+// observe records a request which took d until end
+func (r *rateRecorder) observe(end time.Time, d time.Duration) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if start := end.Add(-d); r.first.IsZero() || start.Before(r.first) {
+		r.first = start
+	}
+	if end.After(r.last) {
+		r.last = end
+	}
+	r.latencies = append(r.latencies, d)
+}
+
+// report returns the rate and latencies of requests observed so far, nil when there is none
+func (r *rateRecorder) report() *RateReport {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.latencies) == 0 {
+		return nil
+	}
+	latencies := append([]time.Duration(nil), r.latencies...)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var sum time.Duration
+	for _, d := range latencies {
+		sum += d
+	}
+	report := &RateReport{
+		LatencyAvg: sum / time.Duration(len(latencies)),
+		LatencyP50: percentile(latencies, 50),
+		LatencyP95: percentile(latencies, 95),
+		LatencyP99: percentile(latencies, 99),
+	}
+	if elapsed := r.last.Sub(r.first); elapsed > 0 {
+		report.RequestsPerSecond = float64(len(latencies)) / elapsed.Seconds()
+	}
+	return report
+}
+
+// percentile returns the p-th percentile of sorted by the nearest rank
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100 // ceil(p/100*n)
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
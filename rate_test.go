@@ -0,0 +1,62 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateRecorder(t *testing.T) {
+	r := newRateRecorder()
+	if report := r.report(); report != nil {
+		t.Errorf("no report is expected without requests, got %v", report)
+	}
+
+	// 10 requests over 5 seconds, taking 100ms to 1s
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 1; i <= 10; i++ {
+		d := time.Duration(i) * 100 * time.Millisecond
+		end := start.Add(time.Duration(i-1)*400*time.Millisecond + d)
+		r.observe(end, d)
+	}
+	// The last one ends at 3.6s+1s
+	want := RateReport{
+		RequestsPerSecond: 10 / 4.6,
+		LatencyAvg:        550 * time.Millisecond,
+		LatencyP50:        500 * time.Millisecond,
+		LatencyP95:        time.Second,
+		LatencyP99:        time.Second,
+	}
+	got := r.report()
+	if got == nil {
+		t.Fatalf("expected a report")
+	}
+	if math.Abs(got.RequestsPerSecond-want.RequestsPerSecond) > 1e-9 {
+		t.Errorf("expected %f requests/s, got %f", want.RequestsPerSecond, got.RequestsPerSecond)
+	}
+	got.RequestsPerSecond = want.RequestsPerSecond
+	if *got != want {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	summary := Summary{Requests: 10, Rate: r.report()}
+	if !strings.Contains(summary.String(), "rate: 2.17 requests/s, latency avg: 550ms, p50: 500ms, p95: 1s, p99: 1s") {
+		t.Errorf("the summary should report the rate: %s", summary)
+	}
+
+	var disabled *rateRecorder
+	disabled.observe(start, time.Second)
+	if report := disabled.report(); report != nil {
+		t.Errorf("a nil recorder should report nothing, got %v", report)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := []time.Duration{1, 2, 3, 4}
+	for p, want := range map[int]time.Duration{0: 1, 25: 1, 50: 2, 75: 3, 99: 4, 100: 4} {
+		if got := percentile(sorted, p); got != want {
+			t.Errorf("p%d: expected %d, got %d", p, want, got)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterReserve(t *testing.T) {
+	l := newRateLimiter(10)
+	now := time.Now()
+
+	// Tokens are handed out every 100ms
+	for i := 0; i < 5; i++ {
+		if delay := l.reserve(now); delay != time.Duration(i)*100*time.Millisecond {
+			t.Errorf("token %d: expected delay %s, got %s", i, time.Duration(i)*100*time.Millisecond, delay)
+		}
+	}
+
+	// An idle limiter doesn't accumulate tokens
+	later := now.Add(time.Hour)
+	if delay := l.reserve(later); delay != 0 {
+		t.Errorf("expected no delay after idling, got %s", delay)
+	}
+	if delay := l.reserve(later); delay != 100*time.Millisecond {
+		t.Errorf("expected 100ms delay, got %s", delay)
+	}
+}
+
+func TestRateLimiterShared(t *testing.T) {
+	l := newRateLimiter(100)
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := l.wait(context.Background()); err != nil {
+				t.Errorf("%s", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("10 tokens at 100 rps should take at least 90ms, took %s", elapsed)
+	}
+}
+
+func TestRateLimiterCancel(t *testing.T) {
+	l := newRateLimiter(0.1)
+	l.wait(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("waiting should stop on context cancellation, got %v", err)
+	}
+
+	var nilLimiter *rateLimiter
+	if err := nilLimiter.wait(context.Background()); err != nil {
+		t.Errorf("nil limiter should never block: %s", err)
+	}
+}
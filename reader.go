@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"time"
+)
+
+// maxReadRetries is how many times in a row a read failing with a temporary error is retried
+const maxReadRetries = 5
+
+// readRetryDelay is the wait before retrying a read, tests shorten it
+var readRetryDelay = 10 * time.Millisecond
+
+// retryReader retries reads failing with temporary errors like EINTR from interrupted pipes,
+// which bufio.Scanner and json.Decoder would otherwise take as the end of input
+type retryReader struct {
+	r        io.Reader
+	failures int // temporary errors in a row
+}
+
+func newRetryReader(r io.Reader) *retryReader {
+	return &retryReader{r: r}
+}
+
+func (rr *retryReader) Read(p []byte) (int, error) {
+	for {
+		n, err := rr.r.Read(p)
+		if err == nil || !temporaryReadError(err) {
+			rr.failures = 0
+			return n, err
+		}
+		rr.failures++
+		if rr.failures > maxReadRetries {
+			return n, err
+		}
+		// Hand over data read so far, the error is retried by the next read
+		if n > 0 {
+			return n, nil
+		}
+		time.Sleep(readRetryDelay)
+	}
+}
+
+// temporaryReadError reports whether a read failing with err is worth retrying
+func temporaryReadError(err error) bool {
+	if errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.EAGAIN) {
+		return true
+	}
+	var temporary interface{ Temporary() bool }
+	return errors.As(err, &temporary) && temporary.Temporary()
+}
+
+// defaultMaxLineBytes is the longest line of a list read by default, far over 64KB of bufio.Scanner
+const defaultMaxLineBytes = 1 << 20
+
+// lineScanner reads lines like bufio.Scanner, but a line longer than max is reported by lineErr and
+// skipped instead of stopping the scan with bufio.ErrTooLong, so that the rest of a list is still read
+type lineScanner struct {
+	*bufio.Scanner
+	max        int
+	line       int  // number of the last line
+	tooLong    bool // the last line is over max, Text() is empty
+	discarding bool // in the rest of a line over max
+}
+
+func newLineScanner(r io.Reader, max int) *lineScanner {
+	if max <= 0 {
+		max = defaultMaxLineBytes
+	}
+	s := &lineScanner{Scanner: bufio.NewScanner(r), max: max}
+	s.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), max)
+	s.Split(s.split)
+	return s
+}
+
+func (s *lineScanner) split(data []byte, atEOF bool) (int, []byte, error) {
+	if s.discarding {
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			s.discarding = false
+			return i + 1, nil, nil
+		}
+		return len(data), nil, nil
+	}
+	advance, token, err := bufio.ScanLines(data, atEOF)
+	if advance == 0 && token == nil && err == nil && len(data) >= s.max {
+		// The buffer is full without a newline, drop the line up to the next one
+		s.discarding = true
+		s.tooLong = true
+		return len(data), []byte{}, nil
+	}
+	if token != nil {
+		s.tooLong = false
+	}
+	return advance, token, err
+}
+
+// Scan advances to the next line, which may be over the limit, see lineErr
+func (s *lineScanner) Scan() bool {
+	if !s.Scanner.Scan() {
+		return false
+	}
+	s.line++
+	return true
+}
+
+// lineErr returns an error when the last line is longer than the limit
+func (s *lineScanner) lineErr() error {
+	if !s.tooLong {
+		return nil
+	}
+	return fmt.Errorf("line %d is longer than -max-line-bytes %d", s.line, s.max)
+}
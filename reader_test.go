@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// chunkReader returns one chunk per read along with the error of the same index, then io.EOF
+type chunkReader struct {
+	chunks []string
+	errs   []error
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.chunks[0])
+	err := r.errs[0]
+	r.chunks, r.errs = r.chunks[1:], r.errs[1:]
+	return n, err
+}
+
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "resource temporarily unavailable" }
+func (temporaryError) Temporary() bool { return true }
+
+func TestInvalidationRetriesTemporaryReadErrors(t *testing.T) {
+	defer func(d time.Duration) { readRetryDelay = d }(readRetryDelay)
+	readRetryDelay = 0
+
+	ts, rec := newTestServer(http.StatusCreated)
+	defer ts.Close()
+	config := newTestConfig(ts)
+	config.tally = &Results{}
+	in := &chunkReader{
+		chunks: []string{"https://example.com/a\nhttps://exa", "", "mple.com/b\n", "https://example.com/c\n"},
+		errs:   []error{temporaryError{}, syscall.EINTR, nil, nil},
+	}
+	if err := Invalidation(context.Background(), config, in); err != nil {
+		t.Fatalf("temporary read errors should be retried, got %s", err)
+	}
+	if summary := config.tally.Summary(); summary.PurgedObjects != 3 {
+		t.Errorf("expected no objects to be lost: %s", summary)
+	}
+	for _, object := range []string{"https://example.com/a", "https://example.com/b", "https://example.com/c"} {
+		if !strings.Contains(rec.joinedBodies(), `"`+object+`"`) {
+			t.Errorf("%s should be submitted in %s", object, rec.joinedBodies())
+		}
+	}
+
+	// Objects read before a hard error are still submitted, and the error is reported
+	config = newTestConfig(ts)
+	config.tally = &Results{}
+	hard := errors.New("broken pipe")
+	in = &chunkReader{chunks: []string{"https://example.com/a\n", ""}, errs: []error{nil, hard}}
+	if err := Invalidation(context.Background(), config, in); err == nil || !strings.Contains(err.Error(), "broken pipe") {
+		t.Errorf("a hard read error should fail, got %v", err)
+	}
+	if summary := config.tally.Summary(); summary.PurgedObjects != 1 {
+		t.Errorf("objects read before a hard error should be submitted: %s", summary)
+	}
+
+	// Temporary errors are retried a bounded number of times
+	chunks, errs := make([]string, maxReadRetries+1), make([]error, maxReadRetries+1)
+	for i := range errs {
+		errs[i] = temporaryError{}
+	}
+	if _, err := newRetryReader(&chunkReader{chunks: chunks, errs: errs}).Read(make([]byte, 8)); err == nil {
+		t.Errorf("expected the error after %d retries", maxReadRetries)
+	}
+}
+
+func TestLineScannerTooLong(t *testing.T) {
+	long := strings.Repeat("a", 70*1024)
+	input := "https://example.com/a\n" + long + "\nhttps://example.com/b\n" + long
+	scanner := newLineScanner(strings.NewReader(input), 64*1024)
+	var lines, errs []string
+	for scanner.Scan() {
+		if err := scanner.lineErr(); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("a long line should not stop the scan, got %s", err)
+	}
+	if want := []string{"https://example.com/a", "https://example.com/b"}; strings.Join(lines, " ") != strings.Join(want, " ") {
+		t.Errorf("expected %q, got %q", want, lines)
+	}
+	want := []string{"line 2 is longer than -max-line-bytes 65536", "line 4 is longer than -max-line-bytes 65536"}
+	if strings.Join(errs, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expected %q, got %q", want, errs)
+	}
+}
+
+func TestInvalidateByURLsLongLine(t *testing.T) {
+	ts, rec := newTestServer(http.StatusCreated)
+	defer ts.Close()
+
+	input := "https://example.com/a\nhttps://example.com/" + strings.Repeat("a", 70*1024) + "\nhttps://example.com/b\n"
+	config := newTestConfig(ts)
+	config.maxLineBytes = 64 * 1024
+	var wg sync.WaitGroup
+	if err := InvalidateByURLs(context.Background(), config, strings.NewReader(input), &wg); err != nil {
+		t.Fatalf("%s", err)
+	}
+	wg.Wait()
+	if got, want := rec.joinedBodies(), `{"objects":["https://example.com/a","https://example.com/b"]}`; got != want {
+		t.Errorf("lines around the long one should be purged, expected %s, got %s", want, got)
+	}
+
+	config.strict = true
+	err := InvalidateByURLs(context.Background(), config, strings.NewReader(input), &wg)
+	wg.Wait()
+	if err == nil || err.Error() != "line 2 is longer than -max-line-bytes 65536" {
+		t.Errorf("expected the long line to fail under -strict, got %v", err)
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ResponseBody is a response from Akamai Fast Purge(CCU v3)
+type ResponseBody struct {
+	HTTPStatus       int    `json:"httpStatus"`
+	Title            string `json:"title"`
+	Detail           string `json:"detail"`
+	EstimatedSeconds int    `json:"estimatedSeconds"`
+	PurgeID          string `json:"purgeId"`
+	SupportID        string `json:"supportId"`
+}
+
+// PurgeResult is an outcome of a single invalidation request (one chunk of objects)
+type PurgeResult struct {
+	RequestID        string        `json:"request_id"`
+	RunID            string        `json:"run_id,omitempty"` // shared by requests of a run
+	Objects          int           `json:"objects"`
+	StatusCode       int           `json:"status_code"`
+	PurgeID          string        `json:"purge_id,omitempty"`
+	SupportID        string        `json:"support_id,omitempty"` // of the last failed response
+	Detail           string        `json:"detail,omitempty"`     // of the last failed response
+	Attempts         int           `json:"attempts"`
+	Duration         time.Duration `json:"duration_ns"`
+	ConnectionErrors int           `json:"connection_errors,omitempty"`
+	CircuitOpen      bool          `json:"circuit_open,omitempty"` // failed fast by the circuit breaker
+	Error            string        `json:"error,omitempty"`
+	// Objects of a failed request, not purged. To retry exactly them
+	FailedObjects []string `json:"failed_objects,omitempty"`
+}
+
+// resultWriter writes PurgeResults as JSON lines. Writes are serialized so it is safe
+// to share between request goroutines
+type resultWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func newResultWriter(w io.Writer) *resultWriter {
+	return &resultWriter{enc: json.NewEncoder(w)}
+}
+
+func (rw *resultWriter) write(result PurgeResult) error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	return rw.enc.Encode(result)
+}
+
+// countObjects returns the number of purge objects in a request body
+func countObjects(data []byte) int {
+	// Objects are numbers for CP codes, don't care about their types
+	var rb struct {
+		Objects []json.RawMessage `json:"objects"`
+	}
+	if err := json.Unmarshal(data, &rb); err != nil {
+		return 0
+	}
+	return len(rb.Objects)
+}
+
+// bodyObjects returns purge objects of a request body as they are in lists: URLs and tags unquoted,
+// CP codes as numbers
+func bodyObjects(data []byte) []string {
+	var rb struct {
+		Objects []json.RawMessage `json:"objects"`
+	}
+	if err := json.Unmarshal(data, &rb); err != nil {
+		return nil
+	}
+	objects := make([]string, len(rb.Objects))
+	for i, raw := range rb.Objects {
+		objects[i] = string(raw)
+		var str string
+		if json.Unmarshal(raw, &str) == nil {
+			objects[i] = str
+		}
+	}
+	return objects
+}
+
+// bodyTooLarge reports whether a 400 response rejects the body for its size, e.g. "too many objects"
+// or "body too large", which -auto-split fixes by halving it
+func bodyTooLarge(rb ResponseBody) bool {
+	message := strings.ToLower(rb.Title + " " + rb.Detail)
+	for _, phrase := range []string{"too many", "too large", "too big", "exceed"} {
+		if strings.Contains(message, phrase) {
+			return true
+		}
+	}
+	return false
+}
+
+// Succeeded reports whether Fast Purge accepted the request
+func (result PurgeResult) Succeeded() bool {
+	return result.StatusCode == http.StatusCreated
+}
+
+// Err returns the failure of the request as a *PurgeError, nil when it succeeded
+func (result PurgeResult) Err() error {
+	if result.Succeeded() {
+		return nil
+	}
+	return &PurgeError{
+		RequestID:  result.RequestID,
+		StatusCode: result.StatusCode,
+		SupportID:  result.SupportID,
+		Detail:     result.Detail,
+		Objects:    result.FailedObjects,
+		Message:    result.Error,
+	}
+}
+
+// PurgeError is a failed purge request, so that code embedding the client can tell failures apart by
+// errors.As, e.g. to retry or to alert, from PurgeResult.Err of results given to onResult
+type PurgeError struct {
+	RequestID  string
+	StatusCode int    // of the last response, 0 when none was received, e.g. on connection errors
+	SupportID  string // which Akamai support asks for
+	Detail     string // of the problem details of the response
+	Objects    []string
+	Message    string // as in PurgeResult.Error
+}
+
+func (e *PurgeError) Error() string {
+	s := "request_id: " + e.RequestID
+	if e.StatusCode > 0 {
+		s += fmt.Sprintf(", status: %d", e.StatusCode)
+	}
+	if len(e.Message) > 0 {
+		s += ": " + e.Message
+	}
+	if len(e.SupportID) > 0 {
+		s += " (supportId: " + e.SupportID + ")"
+	}
+	return s
+}
+
+// Summary is an aggregate of PurgeResults of a run
+type Summary struct {
+	Requests           int  `json:"requests"`
+	Succeeded          int  `json:"succeeded"`
+	Failed             int  `json:"failed"`
+	Objects            int  `json:"objects"`
+	PurgedObjects      int  `json:"purged_objects"`
+	FailedObjects      int  `json:"failed_objects"`
+	UnsubmittedObjects int  `json:"unsubmitted_objects,omitempty"`  // read from input, but not submitted before stopping
+	CircuitOpen        int  `json:"circuit_open,omitempty"`         // requests failed fast by the circuit breaker
+	Unchanged          int  `json:"unchanged_objects,omitempty"`    // skipped by -diff as the last run purged them
+	Resumed            int  `json:"resumed_objects,omitempty"`      // skipped by -resume as the interrupted run purged them
+	Concurrency        int  `json:"concurrency,omitempty"`          // requests in flight -adaptive ended with
+	Backoffs           int  `json:"concurrency_backoffs,omitempty"` // times -adaptive halved them
+	Interrupted        bool `json:"interrupted,omitempty"`
+	DeadlineExceeded   bool `json:"deadline_exceeded,omitempty"`
+	// SupportIDs of failed requests, to tell Akamai support
+	SupportIDs []string `json:"support_ids,omitempty"`
+	// Rate of -rate-report, of all targets together
+	Rate *RateReport `json:"rate,omitempty"`
+	// Breakdown of -m both or -n both by "method/network"
+	Breakdown map[string]Summary `json:"breakdown,omitempty"`
+	// HostFailures are failed requests per API host, when sections have several hosts
+	HostFailures map[string]int `json:"host_failures,omitempty"`
+}
+
+func (s Summary) String() string {
+	str := fmt.Sprintf("requests: %d(succeeded: %d, failed: %d), objects: %d(purged: %d, failed: %d)",
+		s.Requests, s.Succeeded, s.Failed, s.Objects, s.PurgedObjects, s.FailedObjects)
+	if s.UnsubmittedObjects > 0 {
+		str += fmt.Sprintf(", unsubmitted objects: %d", s.UnsubmittedObjects)
+	}
+	if s.CircuitOpen > 0 {
+		str += fmt.Sprintf(", circuit open: %d requests", s.CircuitOpen)
+	}
+	if s.Unchanged > 0 {
+		str += fmt.Sprintf(", unchanged objects: %d", s.Unchanged)
+	}
+	if s.Resumed > 0 {
+		str += fmt.Sprintf(", resumed objects: %d", s.Resumed)
+	}
+	if s.Concurrency > 0 {
+		str += fmt.Sprintf(", concurrency: %d(backoffs: %d)", s.Concurrency, s.Backoffs)
+	}
+	if len(s.SupportIDs) > 0 {
+		ids := s.SupportIDs
+		if len(ids) > maxSummarySupportIDs {
+			ids = ids[:maxSummarySupportIDs]
+		}
+		str += ", supportIds: " + strings.Join(ids, ", ")
+		if more := len(s.SupportIDs) - len(ids); more > 0 {
+			str += fmt.Sprintf(" and %d more", more)
+		}
+	}
+	if failed := hostFailuresString(s.HostFailures); len(failed) > 0 {
+		str += ", host failures: " + failed
+	}
+	if s.Rate != nil {
+		str += ", " + s.Rate.String()
+	}
+	if s.Interrupted {
+		str += ", interrupted"
+	}
+	if s.DeadlineExceeded {
+		str += ", deadline exceeded"
+	}
+	return str
+}
+
+// merge returns the sum of s and other, e.g. to total summaries of several sections
+func (s Summary) merge(other Summary) Summary {
+	s.Requests += other.Requests
+	s.Succeeded += other.Succeeded
+	s.Failed += other.Failed
+	s.Objects += other.Objects
+	s.PurgedObjects += other.PurgedObjects
+	s.FailedObjects += other.FailedObjects
+	s.UnsubmittedObjects += other.UnsubmittedObjects
+	s.CircuitOpen += other.CircuitOpen
+	s.Unchanged += other.Unchanged
+	s.Resumed += other.Resumed
+	if other.Concurrency > s.Concurrency {
+		s.Concurrency = other.Concurrency
+	}
+	s.Backoffs += other.Backoffs
+	s.SupportIDs = append(s.SupportIDs, other.SupportIDs...)
+	s.Interrupted = s.Interrupted || other.Interrupted
+	s.DeadlineExceeded = s.DeadlineExceeded || other.DeadlineExceeded
+	if other.Rate != nil {
+		s.Rate = other.Rate
+	}
+	return s
+}
+
+// hostFailuresString lists hosts with failed requests like "a.purge.akamaiapis.net: 3", sorted by host
+func hostFailuresString(failures map[string]int) string {
+	var hosts []string
+	for host, n := range failures {
+		if n > 0 {
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts)
+	for i, host := range hosts {
+		hosts[i] = fmt.Sprintf("%s: %d", host, failures[host])
+	}
+	return strings.Join(hosts, ", ")
+}
+
+// maxSummarySupportIDs is the number of supportIds shown in a summary line, the rest is only counted
+const maxSummarySupportIDs = 5
+
+// maxFailedObjects is the number of failed objects kept by Results, so that a long run failing
+// throughout doesn't pile them up in memory. The rest is only counted
+const maxFailedObjects = 10000
+
+// Results aggregates PurgeResults reported by request goroutines into the summary of a run and its failed
+// objects. It is safe for concurrent use, the zero value is empty
+type Results struct {
+	mu      sync.Mutex
+	summary Summary
+	failed  []string // objects of failed requests, in the order they failed, up to maxFailedObjects
+}
+
+// Record adds the result of a finished request
+func (t *Results) Record(result PurgeResult) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.summary.Requests++
+	t.summary.Objects += result.Objects
+	if result.Succeeded() {
+		t.summary.Succeeded++
+		t.summary.PurgedObjects += result.Objects
+	} else {
+		t.summary.Failed++
+		t.summary.FailedObjects += result.Objects
+		if result.CircuitOpen {
+			t.summary.CircuitOpen++
+		}
+		if len(result.SupportID) > 0 {
+			t.summary.SupportIDs = append(t.summary.SupportIDs, result.SupportID)
+		}
+		if room := maxFailedObjects - len(t.failed); room > 0 {
+			if len(result.FailedObjects) > room {
+				result.FailedObjects = result.FailedObjects[:room]
+			}
+			t.failed = append(t.failed, result.FailedObjects...)
+		}
+	}
+}
+
+// skip counts objects read from input but not submitted
+func (t *Results) skip(objects int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.summary.UnsubmittedObjects += objects
+}
+
+// Summary returns the aggregate of results recorded so far
+func (t *Results) Summary() Summary {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	summary := t.summary
+	// Don't share the slice with later adds
+	summary.SupportIDs = append([]string(nil), t.summary.SupportIDs...)
+	return summary
+}
+
+// FailedObjects returns the first maxFailedObjects objects of failed requests recorded so far
+func (t *Results) FailedObjects() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string(nil), t.failed...)
+}
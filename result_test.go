@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestResultWriterConcurrent(t *testing.T) {
+	var buf bytes.Buffer
+	rw := newResultWriter(&buf)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rw.write(PurgeResult{RequestID: "req", Objects: 1, StatusCode: http.StatusCreated})
+		}()
+	}
+	wg.Wait()
+
+	lines := 0
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var result PurgeResult
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			t.Fatalf("line %d is not valid JSON: %s", lines+1, err)
+		}
+		lines++
+	}
+	if lines != 100 {
+		t.Errorf("expected 100 result lines, got %d", lines)
+	}
+}
+
+func TestInvalidationRequestRecordsResult(t *testing.T) {
+	ts, _ := newTestServer(http.StatusCreated)
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	config := newTestConfig(ts)
+	config.results = newResultWriter(&buf)
+	sendTestRequest(config)
+
+	var result PurgeResult
+	if err := json.Unmarshal(buf.Bytes(), &result); err != nil {
+		t.Fatalf("result is not valid JSON: %s", err)
+	}
+	if result.RequestID == "" {
+		t.Errorf("result has no request_id")
+	}
+	if result.Objects != 1 || result.StatusCode != http.StatusCreated || result.PurgeID != testPurgeID || result.Error != "" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+}
+
+func TestResults(t *testing.T) {
+	var results Results
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			results.Record(PurgeResult{Objects: 3, StatusCode: http.StatusCreated})
+		}()
+		go func(i int) {
+			defer wg.Done()
+			object := fmt.Sprintf("https://example.com/%d", i)
+			results.Record(PurgeResult{Objects: 1, StatusCode: http.StatusForbidden, Error: "Forbidden", FailedObjects: []string{object}})
+		}(i)
+		go func() {
+			defer wg.Done()
+			results.skip(1)
+		}()
+	}
+	wg.Wait()
+
+	want := Summary{Requests: 100, Succeeded: 50, Failed: 50, Objects: 200, PurgedObjects: 150, FailedObjects: 50, UnsubmittedObjects: 50}
+	if got := results.Summary(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+	if s := want.String(); s != "requests: 100(succeeded: 50, failed: 50), objects: 200(purged: 150, failed: 50), unsubmitted objects: 50" {
+		t.Errorf("unexpected summary string: %s", s)
+	}
+
+	failed := results.FailedObjects()
+	sort.Strings(failed)
+	var wantFailed []string
+	for i := 0; i < 50; i++ {
+		wantFailed = append(wantFailed, fmt.Sprintf("https://example.com/%d", i))
+	}
+	sort.Strings(wantFailed)
+	if !reflect.DeepEqual(failed, wantFailed) {
+		t.Errorf("expected failed objects %q, got %q", wantFailed, failed)
+	}
+	// The returned slice is a copy
+	failed[0] = "changed"
+	if results.FailedObjects()[0] == "changed" {
+		t.Error("FailedObjects shouldn't share its slice")
+	}
+}
+
+func TestResultsMaxFailedObjects(t *testing.T) {
+	var results Results
+	objects := make([]string, maxFailedObjects-1)
+	for i := range objects {
+		objects[i] = fmt.Sprintf("https://example.com/%d", i)
+	}
+	for _, failed := range [][]string{objects, {"https://example.com/last", "https://example.com/over"}, {"https://example.com/more"}} {
+		results.Record(PurgeResult{Objects: len(failed), StatusCode: http.StatusForbidden, Error: "Forbidden", FailedObjects: failed})
+	}
+	if s := results.Summary(); s.Failed != 3 || s.FailedObjects != maxFailedObjects+2 {
+		t.Errorf("failures over the cap should still be counted, got %s", s)
+	}
+	failed := results.FailedObjects()
+	if len(failed) != maxFailedObjects || failed[len(failed)-1] != "https://example.com/last" {
+		t.Errorf("expected the first %d failed objects kept, got %d ending with %q", maxFailedObjects, len(failed), failed[len(failed)-1])
+	}
+}
+
+func TestOnResult(t *testing.T) {
+	ts, rec := newTestServer(http.StatusTooManyRequests, http.StatusCreated)
+	defer ts.Close()
+
+	var mu sync.Mutex
+	var results []PurgeResult
+	config := newTestConfig(ts)
+	config.maxBody = minBodySize
+	config.maxDelay = time.Millisecond
+	config.onResult = func(result PurgeResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		results = append(results, result)
+	}
+
+	var input bytes.Buffer
+	for i := 0; i < 100; i++ {
+		input.WriteString("https://example.com/" + strconv.Itoa(i) + ".html\n")
+	}
+	if err := Invalidation(context.Background(), config, &input); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	// The first request is rate limited once, so there is one more request sent than results
+	if len(results) < 2 || len(results) != rec.count()-1 {
+		t.Fatalf("expected a result per request, got %d results for %d requests", len(results), rec.count())
+	}
+	ids, objects, attempts := map[string]bool{}, 0, 0
+	for _, result := range results {
+		if ids[result.RequestID] {
+			t.Errorf("onResult is called more than once for %s", result.RequestID)
+		}
+		ids[result.RequestID] = true
+		if !result.Succeeded() || result.PurgeID != testPurgeID || result.Duration <= 0 {
+			t.Errorf("unexpected result: %+v", result)
+		}
+		objects += result.Objects
+		attempts += result.Attempts
+	}
+	if objects != 100 || attempts != rec.count() {
+		t.Errorf("expected 100 objects in %d attempts, got %d in %d", rec.count(), objects, attempts)
+	}
+}
+
+func TestSupportIDs(t *testing.T) {
+	ts, _ := newTestServer(http.StatusForbidden)
+	defer ts.Close()
+
+	hook, restore := captureLog()
+	defer restore()
+	var buf bytes.Buffer
+	config := newTestConfig(ts)
+	config.tally = &Results{}
+	config.results = newResultWriter(&buf)
+	sendTestRequest(config)
+
+	entry := hook.LastEntry()
+	if entry == nil || entry.Message != "[Failed]" || entry.Data["support_id"] != testSupportID {
+		t.Errorf("expected supportId as a field of [Failed], got %+v", entry)
+	}
+	var result PurgeResult
+	if err := json.Unmarshal(buf.Bytes(), &result); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if result.SupportID != testSupportID {
+		t.Errorf("expected supportId in the result, got %+v", result)
+	}
+	summary := config.tally.Summary()
+	if !reflect.DeepEqual(summary.SupportIDs, []string{testSupportID}) || !strings.Contains(summary.String(), testSupportID) {
+		t.Errorf("expected supportId in the summary, got %s", summary)
+	}
+}
+
+func TestSupportIDsNotJSON(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("<html>Forbidden</html>"))
+	}))
+	defer ts.Close()
+
+	hook, restore := captureLog()
+	defer restore()
+	sendTestRequest(newTestConfig(ts))
+
+	entry := hook.LastEntry()
+	if entry == nil || entry.Data["response_body"] != "<html>Forbidden</html>" {
+		t.Errorf("expected the raw response body when it isn't JSON, got %+v", entry)
+	}
+	if _, ok := entry.Data["support_id"]; ok {
+		t.Errorf("there is no supportId in a non-JSON body, got %+v", entry.Data)
+	}
+}
+
+func TestSummarySupportIDsLimit(t *testing.T) {
+	s := Summary{Requests: 7, Failed: 7, SupportIDs: []string{"a", "b", "c", "d", "e", "f", "g"}}
+	want := "requests: 7(succeeded: 0, failed: 7), objects: 0(purged: 0, failed: 0), supportIds: a, b, c, d, e and 2 more"
+	if got := s.String(); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestResultFailedObjects(t *testing.T) {
+	// The chunk of /c fails
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		if bytes.Contains(body, []byte("example.com/c")) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+
+	config := newTestConfig(ts)
+	config.maxObjects = 2
+	var out bytes.Buffer
+	config.results = newResultWriter(&out)
+	in := "https://example.com/a\nhttps://example.com/b\nhttps://example.com/c\nhttps://example.com/d\n"
+	if err := Invalidation(context.Background(), config, strings.NewReader(in)); err != nil {
+		t.Fatal(err)
+	}
+
+	var failed [][]string
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		var result PurgeResult
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		if result.Succeeded() != (len(result.FailedObjects) == 0) {
+			t.Errorf("only failed requests should list failed objects: %+v", result)
+		}
+		if !result.Succeeded() {
+			failed = append(failed, result.FailedObjects)
+		}
+	}
+	if want := [][]string{{"https://example.com/c", "https://example.com/d"}}; !reflect.DeepEqual(failed, want) {
+		t.Errorf("expected objects of the failed chunk %q, got %q", want, failed)
+	}
+}
+
+func TestAuthFailureHint(t *testing.T) {
+	ts, rec := newTestServer(http.StatusForbidden)
+	defer ts.Close()
+
+	hook, restore := captureLog()
+	defer restore()
+	config := newTestConfig(ts)
+	var buf bytes.Buffer
+	config.results = newResultWriter(&buf)
+	// Even when -retry-on has it, rejected credentials aren't retried
+	config.retryOn = statusSet{http.StatusForbidden: true}
+	sendTestRequest(config)
+
+	if n := rec.count(); n != 1 {
+		t.Errorf("403 should not be retried, but %d requests were sent", n)
+	}
+	entry := hook.LastEntry()
+	if entry == nil || entry.Data["support_id"] != testSupportID || !strings.Contains(fmt.Sprint(entry.Data["hint"]), "access to Fast Purge") {
+		t.Errorf("expected an actionable hint with supportId, got %+v", entry)
+	}
+	var result PurgeResult
+	if err := json.Unmarshal(buf.Bytes(), &result); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if !strings.Contains(result.Error, "client_secret and access_token") || result.SupportID != testSupportID {
+		t.Errorf("expected the hint and supportId in the result, got %+v", result)
+	}
+}
+
+func TestPurgeError(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"httpStatus":400,"title":"Bad request","detail":"Invalid object","supportId":"` + testSupportID + `"}`))
+	}))
+	defer ts.Close()
+
+	var results []PurgeResult
+	config := newTestConfig(ts)
+	config.onResult = func(result PurgeResult) { results = append(results, result) }
+	sendTestRequest(config)
+	ts.Close()
+	config.clock = &fakeClock{}
+	sendTestRequest(config)
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+
+	var perr *PurgeError
+	if !errors.As(results[0].Err(), &perr) {
+		t.Fatalf("expected a *PurgeError, got %v", results[0].Err())
+	}
+	if perr.StatusCode != http.StatusBadRequest || perr.SupportID != testSupportID || perr.Detail != "Invalid object" ||
+		perr.RequestID != results[0].RequestID || !reflect.DeepEqual(perr.Objects, []string{"http://example.com/"}) {
+		t.Errorf("unexpected error of a 400 response: %+v", perr)
+	}
+	if !strings.Contains(perr.Error(), testSupportID) || !strings.Contains(perr.Error(), "400") {
+		t.Errorf("expected the status and supportId in %q", perr.Error())
+	}
+
+	// A request no response was received for has no status
+	if !errors.As(results[1].Err(), &perr) || perr.StatusCode != 0 || len(perr.Message) == 0 {
+		t.Errorf("expected an error without a status on connection errors, got %+v", perr)
+	}
+
+	if err := (PurgeResult{StatusCode: http.StatusCreated}).Err(); err != nil {
+		t.Errorf("a succeeded request has no error, got %v", err)
+	}
+}
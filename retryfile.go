@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// retryFile collects objects of failed and unsubmitted requests into the -retry-file, to be given back
+// as the input of the next run: a list of objects for lists, or request bodies as JSON lines for
+// -t json. It is written to a temporary file and replaces the -retry-file on close, so that it can be
+// the input of the run writing it. A nil *retryFile writes nothing
+type retryFile struct {
+	mu     sync.Mutex
+	path   string
+	bodies bool // write request bodies rather than objects
+	tmp    *os.File
+	w      *bufio.Writer
+	err    error // of the first failed write
+}
+
+func newRetryFile(path string, bodies bool) (*retryFile, error) {
+	path, err := homedir.Expand(path)
+	if err != nil {
+		return nil, err
+	}
+	return &retryFile{path: path, bodies: bodies}, nil
+}
+
+// writeBody writes a request body, or its objects for lists
+func (f *retryFile) writeBody(body []byte) {
+	if f == nil {
+		return
+	}
+	if !f.bodies {
+		f.writeObjects(bodyObjects(body))
+		return
+	}
+	f.writeLines([]string{string(body)})
+}
+
+// writeObjects writes objects of a list
+func (f *retryFile) writeObjects(objects []string) {
+	if f == nil {
+		return
+	}
+	f.writeLines(objects)
+}
+
+func (f *retryFile) writeLines(lines []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil || len(lines) == 0 {
+		return
+	}
+	if f.tmp == nil {
+		if f.tmp, f.err = ioutil.TempFile(filepath.Dir(f.path), filepath.Base(f.path)+".tmp"); f.err != nil {
+			return
+		}
+		f.w = bufio.NewWriter(f.tmp)
+	}
+	for _, line := range lines {
+		if _, f.err = f.w.WriteString(line + "\n"); f.err != nil {
+			return
+		}
+	}
+}
+
+// close replaces the -retry-file with what is written. When nothing is, it isn't created, and one left
+// by an earlier run is emptied if the run succeeded
+func (f *retryFile) close(succeeded bool) error {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.tmp == nil {
+		if f.err == nil && succeeded {
+			if _, err := os.Stat(f.path); err == nil {
+				return os.Truncate(f.path, 0)
+			}
+		}
+		return f.err
+	}
+	defer os.Remove(f.tmp.Name())
+	if f.err == nil {
+		f.err = f.w.Flush()
+	}
+	if err := f.tmp.Close(); f.err == nil {
+		f.err = err
+	}
+	if f.err != nil {
+		return f.err
+	}
+	return os.Rename(f.tmp.Name(), f.path)
+}
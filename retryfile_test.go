@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunRetryFile(t *testing.T) {
+	// Requests with /c fail
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		if bytes.Contains(body, []byte("example.com/c")) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+	setEdgegridEnv(t)
+	defer unsetEdgegridEnv()
+	os.Setenv("AKAMAI_HOST", strings.TrimPrefix(ts.URL, "https://"))
+	defer log.SetLevel(log.Level)
+
+	dir, err := ioutil.TempDir("", "purge-retry-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	read := func(path string) string {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	// Only objects of the failed chunk are written
+	retry := write("retry.txt", "https://example.com/a\nhttps://example.com/b\nhttps://example.com/c\nhttps://example.com/d\n")
+	if err := run([]string{"-insecure", "-max-objects", "2", "-retry-file", retry, retry}, &bytes.Buffer{}); err == nil {
+		t.Error("the run should fail by the failed chunk")
+	}
+	if got, want := read(retry), "https://example.com/c\nhttps://example.com/d\n"; got != want {
+		t.Errorf("expected the retry file to be rewritten with failed objects %q, got %q", want, got)
+	}
+
+	// Once everything succeeds, it is emptied, and isn't created by succeeded runs
+	write("retry.txt", "https://example.com/d\n")
+	if err := run([]string{"-insecure", "-retry-file", retry, retry}, &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(retry); len(got) != 0 {
+		t.Errorf("the retry file should be emptied by a succeeded run, got %q", got)
+	}
+	created := filepath.Join(dir, "created.txt")
+	input := write("input.txt", "https://example.com/a\n")
+	if err := run([]string{"-insecure", "-retry-file", created, input}, &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(created); !os.IsNotExist(err) {
+		t.Errorf("a succeeded run shouldn't create the retry file, got %v", err)
+	}
+
+	// Bodies of JSON are written as they are
+	bodies := write("bodies.json", `{"objects":["https://example.com/a"]}`+"\n"+`{"objects":["https://example.com/c"]}`+"\n")
+	jsonRetry := filepath.Join(dir, "retry.json")
+	if err := run([]string{"-insecure", "-t", "json", "-retry-file", jsonRetry, bodies}, &bytes.Buffer{}); err == nil {
+		t.Error("the run should fail by the failed body")
+	}
+	if got, want := read(jsonRetry), `{"objects":["https://example.com/c"]}`+"\n"; got != want {
+		t.Errorf("expected the failed body %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// hostRewrite is a -replace-host: URLs of host old are purged on host new instead
+type hostRewrite struct {
+	old, new string
+}
+
+// hostRewrites are -replace-host flags given as "old=new", repeatable
+type hostRewrites []hostRewrite
+
+func (rewrites *hostRewrites) String() string {
+	var pairs []string
+	for _, r := range *rewrites {
+		pairs = append(pairs, r.old+"="+r.new)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (rewrites *hostRewrites) Set(value string) error {
+	i := strings.Index(value, "=")
+	if i < 0 {
+		return fmt.Errorf("%q should be old=new", value)
+	}
+	old, new := strings.TrimSpace(value[:i]), strings.TrimSpace(value[i+1:])
+	if len(old) == 0 || len(new) == 0 || strings.ContainsAny(old+new, "/?#@ ") {
+		return fmt.Errorf("%q should be old=new of host names like www.example.com=stage.example.com", value)
+	}
+	*rewrites = append(*rewrites, hostRewrite{old: old, new: new})
+	return nil
+}
+
+// apply rewrites the host of a URL by the first matching -replace-host, keeping the rest as it is.
+// Hosts match case-insensitively, with the port if any. A URL of no matching host is returned as is
+func (rewrites hostRewrites) apply(raw string) (string, error) {
+	start, end, ok := urlHostSpan(raw)
+	if !ok {
+		return raw, nil
+	}
+	for _, r := range rewrites {
+		if strings.EqualFold(raw[start:end], r.old) {
+			rewritten := raw[:start] + r.new + raw[end:]
+			if err := validateURL(rewritten); err != nil {
+				return raw, fmt.Errorf("-replace-host %s=%s of %q: %s", r.old, r.new, raw, err)
+			}
+			return rewritten, nil
+		}
+	}
+	return raw, nil
+}
+
+// urlHostSpan returns where the host of a URL like "https://user@host:port/path" starts and ends,
+// ok is false without "://"
+func urlHostSpan(s string) (start, end int, ok bool) {
+	i := strings.Index(s, "://")
+	if i < 0 {
+		return 0, 0, false
+	}
+	start, end = i+3, len(s)
+	if j := strings.IndexAny(s[start:], "/?#"); j >= 0 {
+		end = start + j
+	}
+	// Userinfo is case sensitive, only the part after "@" is a host
+	if at := strings.LastIndex(s[start:end], "@"); at >= 0 {
+		start += at + 1
+	}
+	return start, end, true
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestHostRewritesApply(t *testing.T) {
+	var rewrites hostRewrites
+	for _, value := range []string{"www.example.com=stage.example.com", "cdn.example.com:8443=cdn-stage.example.com:8443"} {
+		if err := rewrites.Set(value); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+	tests := []struct {
+		raw, want string
+	}{
+		{"https://www.example.com/a?b=1#c", "https://stage.example.com/a?b=1#c"},
+		{"http://WWW.Example.com", "http://stage.example.com"},
+		{"https://user@www.example.com/a", "https://user@stage.example.com/a"},
+		{"https://cdn.example.com:8443/img.png", "https://cdn-stage.example.com:8443/img.png"},
+		// Other hosts and ports pass through
+		{"https://cdn.example.com/img.png", "https://cdn.example.com/img.png"},
+		{"https://example.com/www.example.com", "https://example.com/www.example.com"},
+		{"https://www.example.com.evil.com/", "https://www.example.com.evil.com/"},
+	}
+	for _, tt := range tests {
+		got, err := rewrites.apply(tt.raw)
+		if err != nil {
+			t.Errorf("%q: %s", tt.raw, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q: expected %q, got %q", tt.raw, tt.want, got)
+		}
+	}
+}
+
+func TestHostRewritesSet(t *testing.T) {
+	for _, value := range []string{"www.example.com", "=stage.example.com", "www.example.com=", "www.example.com=https://stage.example.com/"} {
+		var rewrites hostRewrites
+		if err := rewrites.Set(value); err == nil {
+			t.Errorf("-replace-host %q should be refused", value)
+		}
+	}
+	// A rewrite has to leave a valid URL
+	rewrites := hostRewrites{{old: "www.example.com", new: "stage*.example.com"}}
+	if _, err := rewrites.apply("https://www.example.com/"); err == nil {
+		t.Errorf("a rewrite to an invalid URL should fail")
+	}
+}
+
+func TestInvalidateByURLsReplaceHost(t *testing.T) {
+	ts, rec := newTestServer(http.StatusCreated)
+	defer ts.Close()
+
+	config := newTestConfig(ts)
+	if err := config.replaceHosts.Set("www.example.com=stage.example.com"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	input := "https://www.example.com/a\nhttps://other.example.com/b\n"
+	var wg sync.WaitGroup
+	if err := InvalidateByURLs(context.Background(), config, strings.NewReader(input), &wg); err != nil {
+		t.Fatalf("%s", err)
+	}
+	wg.Wait()
+	if got, want := rec.joinedBodies(), `{"objects":["https://stage.example.com/a","https://other.example.com/b"]}`; got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
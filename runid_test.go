@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunID(t *testing.T) {
+	ts, rec := newTestServer(http.StatusCreated)
+	defer ts.Close()
+	setEdgegridEnv(t)
+	defer unsetEdgegridEnv()
+	os.Setenv("AKAMAI_HOST", strings.TrimPrefix(ts.URL, "https://"))
+	defer log.SetLevel(log.Level)
+	hook, restore := captureLog()
+	defer restore()
+
+	dir, err := ioutil.TempDir("", "purge-run-id")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "results.jsonl")
+	args := []string{"-insecure", "-l", "info", "-max-objects", "1", "-output", output, "-run-id-header", "X-Run-Id",
+		"-url", "https://example.com/a", "-url", "https://example.com/b", "-url", "https://example.com/c"}
+	if err := run(args, &bytes.Buffer{}); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	fp, err := os.Open(output)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer fp.Close()
+	runID, requestIDs := "", map[string]bool{}
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		var result PurgeResult
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			t.Fatalf("%s", err)
+		}
+		if len(runID) == 0 {
+			runID = result.RunID
+		}
+		if len(result.RunID) == 0 || result.RunID != runID {
+			t.Errorf("expected every result of the run ID %q, got %+v", runID, result)
+		}
+		if requestIDs[result.RequestID] {
+			t.Errorf("request ID %s is given twice", result.RequestID)
+		}
+		requestIDs[result.RequestID] = true
+	}
+	if len(requestIDs) != 3 {
+		t.Fatalf("expected results of 3 requests, got %d", len(requestIDs))
+	}
+
+	for _, entry := range hook.AllEntries() {
+		if _, ok := entry.Data["request_id"]; ok && entry.Data["run_id"] != runID {
+			t.Errorf("expected %s logged with the run ID %q, got %v", entry.Message, runID, entry.Data)
+		}
+	}
+	for _, req := range rec.requests {
+		if got := req.Header.Get("X-Run-Id"); got != runID {
+			t.Errorf("expected the run ID in X-Run-Id, got %q", got)
+		}
+	}
+
+	// A given run ID is used as it is
+	os.Remove(output)
+	if err := run([]string{"-insecure", "-output", output, "-run-id", "ci-1234", "-url", "https://example.com/a"}, &bytes.Buffer{}); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if results, _ := ioutil.ReadFile(output); !strings.Contains(string(results), `"run_id":"ci-1234"`) {
+		t.Errorf("expected results of the given run ID, got %s", results)
+	}
+}
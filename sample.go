@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// sampleScale is the resolution of -sample fractions
+const sampleScale = 1000000
+
+// sampler validates a random fraction of objects for -sample, under -explain, to estimate how many of a
+// huge list are invalid without going through all of it. Picks come from the jitter source, so they
+// are deterministic under a fixed seed
+type sampler struct {
+	mu   sync.Mutex
+	rate float64
+	rand randSource
+	// validate checks a picked object, validateObject unless -wildcard allows more
+	validate func(kind, object string) error
+	seen     int
+	sampled  int
+	invalid  int
+}
+
+func newSampler(rate float64, rnd randSource) *sampler {
+	return &sampler{rate: rate, rand: rnd, validate: validateObject}
+}
+
+// observe counts an object, validating it when it is picked
+func (s *sampler) observe(kind, object string) {
+	s.mu.Lock()
+	s.seen++
+	picked := s.rand.Int63n(sampleScale) < int64(s.rate*sampleScale)
+	if picked {
+		s.sampled++
+	}
+	s.mu.Unlock()
+	if !picked {
+		return
+	}
+	if err := s.validate(kind, object); err != nil {
+		log.Warnf("invalid object in the sample: %s", err)
+		s.mu.Lock()
+		s.invalid++
+		s.mu.Unlock()
+	}
+}
+
+// String reports the sample with the invalid objects extrapolated to the whole input
+func (s *sampler) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sampled == 0 {
+		return fmt.Sprintf("sampled 0 of %d objects, give a larger -sample", s.seen)
+	}
+	ratio := float64(s.invalid) / float64(s.sampled)
+	return fmt.Sprintf("sampled %d of %d objects, %d invalid(%.2f%%), about %d invalid in the whole input, nothing was sent",
+		s.sampled, s.seen, s.invalid, ratio*100, int(ratio*float64(s.seen)+0.5))
+}
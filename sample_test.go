@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExplainSample(t *testing.T) {
+	ts, rec := newTestServer(http.StatusCreated)
+	defer ts.Close()
+
+	// Every tenth object lacks a scheme
+	var in strings.Builder
+	for i := 0; i < 10000; i++ {
+		if i%10 == 0 {
+			fmt.Fprintf(&in, "example.com/%d\n", i)
+			continue
+		}
+		fmt.Fprintf(&in, "https://example.com/%d\n", i)
+	}
+
+	sample := func(seed int64) (*sampler, string) {
+		var out bytes.Buffer
+		config := newTestConfig(ts)
+		config.rand = rand.New(rand.NewSource(seed))
+		config.explainer = newExplainer(&out)
+		config.explainer.sampler = newSampler(0.01, config.rand)
+		if err := Invalidation(context.Background(), config, strings.NewReader(in.String())); err != nil {
+			t.Fatal(err)
+		}
+		config.explainer.finish()
+		return config.explainer.sampler, out.String()
+	}
+
+	defer log.SetLevel(log.Level)
+	log.SetLevel(0)
+	s, out := sample(1)
+	if s.seen != 10000 || s.sampled < 50 || s.sampled > 150 {
+		t.Errorf("expected about 1%% of 10000 objects sampled, got %d of %d", s.sampled, s.seen)
+	}
+	if s.invalid == 0 || s.invalid > s.sampled/4 {
+		t.Errorf("expected about 10%% of the sample invalid, got %d of %d", s.invalid, s.sampled)
+	}
+	if !strings.HasPrefix(out, fmt.Sprintf("sampled %d of 10000 objects, %d invalid", s.sampled, s.invalid)) {
+		t.Errorf("expected only the sample report, got %q", out)
+	}
+	if _, again := sample(1); again != out {
+		t.Errorf("the sample should be deterministic under a fixed seed, got %q and %q", out, again)
+	}
+	if n := rec.count(); n != 0 {
+		t.Errorf("sampling should never send anything, got %d requests", n)
+	}
+}
+
+func TestRunSampleSeed(t *testing.T) {
+	ts, rec := newTestServer(http.StatusCreated)
+	defer ts.Close()
+	setEdgegridEnv(t)
+	defer unsetEdgegridEnv()
+	os.Setenv("AKAMAI_HOST", strings.TrimPrefix(ts.URL, "https://"))
+	defer log.SetLevel(log.Level)
+
+	dir, err := ioutil.TempDir("", "purge-sample")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "urls.txt")
+	var in strings.Builder
+	for i := 0; i < 10000; i++ {
+		if i%10 == 0 {
+			fmt.Fprintf(&in, "example.com/%d\n", i)
+			continue
+		}
+		fmt.Fprintf(&in, "https://example.com/%d\n", i)
+	}
+	if err := ioutil.WriteFile(path, []byte(in.String()), 0644); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	sample := func(seed string) string {
+		var out bytes.Buffer
+		if err := run([]string{"-insecure", "-explain", "-sample", "0.01", "-seed", seed, path}, &out); err != nil {
+			t.Fatalf("%s", err)
+		}
+		return out.String()
+	}
+	out := sample("1")
+	if !strings.HasPrefix(out, "sampled ") {
+		t.Fatalf("expected the sample report, got %q", out)
+	}
+	if again := sample("1"); again != out {
+		t.Errorf("-seed should pick the same sample, got %q and %q", out, again)
+	}
+	if n := rec.count(); n != 0 {
+		t.Errorf("sampling should never send anything, got %d requests", n)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	edgegrid "github.com/akamai-open/AkamaiOPEN-edgegrid-golang"
+)
+
+// sectionNames splits -s into edgerc sections, e.g. "prod,stage,clientA"
+func sectionNames(section string) []string {
+	var names []string
+	for _, name := range strings.Split(section, ",") {
+		if name = strings.TrimSpace(name); len(name) > 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// loadSections loads credentials of every section given by -s into config.edgeConfs, validating each.
+// config.edgeConf, method and network are set to ones of the first
+func loadSections(config *Config) error {
+	names := sectionNames(config.section)
+	if len(names) == 0 {
+		return errors.New("you should specify a config section")
+	}
+	if len(names) > 1 {
+		if _, ok := edgeConfigFromEnv(); ok {
+			return errors.New("multiple sections need an edgerc file, unset AKAMAI_* credential environment variables")
+		}
+	}
+
+	section, method, network := config.section, config.method, config.network
+	defer func() { config.section = section }()
+	config.edgeConfs = nil
+	config.sectionDefaults = nil
+	for _, name := range names {
+		config.section = name
+		config.method, config.network = method, network
+		if err := loadEdgeConfig(config); err != nil {
+			return err
+		}
+		if err := Validation(config); err != nil {
+			if len(names) > 1 {
+				return fmt.Errorf("section %s: %s", name, err)
+			}
+			return err
+		}
+		config.edgeConfs = append(config.edgeConfs, config.edgeConf)
+		config.sectionDefaults = append(config.sectionDefaults, edgercDefaults{method: config.method, network: config.network})
+	}
+	config.edgeConf = config.edgeConfs[0]
+	config.method, config.network = config.sectionDefaults[0].method, config.sectionDefaults[0].network
+	return nil
+}
+
+// sectionTargets returns a config per section to purge with. They share the client, outputs and limits
+// of config, but each has its own credentials and tally
+func sectionTargets(config *Config) []*Config {
+	names := sectionNames(config.section)
+	if len(names) <= 1 || len(names) != len(config.edgeConfs) {
+		return []*Config{config}
+	}
+	targets := make([]*Config, len(names))
+	for i, name := range names {
+		target := *config
+		target.section = name
+		target.edgeConf = config.edgeConfs[i]
+		target.edgeConfs = []edgegrid.Config{config.edgeConfs[i]}
+		if len(config.sectionDefaults) == len(names) {
+			target.method, target.network = config.sectionDefaults[i].method, config.sectionDefaults[i].network
+		}
+		target.tally = &Results{}
+		target.budget = config.budget.fresh()
+		target.targetName = "section " + name
+		targets[i] = &target
+	}
+	return targets
+}
+
+// bothMethods are methods purged by -m both, invalidate first
+var bothMethods = []string{"invalidate", "delete"}
+
+// methodTargets expands targets into one per method for -m both, invalidating and deleting concurrently
+func methodTargets(targets []*Config) []*Config {
+	var expanded []*Config
+	for _, t := range targets {
+		if t.method != "both" {
+			expanded = append(expanded, t)
+			continue
+		}
+		for _, method := range bothMethods {
+			target := *t
+			target.method = method
+			target.tally = &Results{}
+			target.budget = t.budget.fresh()
+			target.targetName = "method " + method
+			if len(t.targetName) > 0 {
+				target.targetName = t.targetName + ", " + target.targetName
+			}
+			expanded = append(expanded, &target)
+		}
+	}
+	return expanded
+}
+
+// targetBreakdown totals summaries of targets by method and network, keyed like "delete/production", so
+// that the summary of a run never mixes them up. Sections of the same method and network are totaled
+// together. It is nil when every target purges with the same method and network
+func targetBreakdown(targets []*Config) map[string]Summary {
+	if len(targets) <= 1 {
+		return nil
+	}
+	breakdown := map[string]Summary{}
+	for _, target := range targets {
+		key := target.method + "/" + target.network
+		breakdown[key] = breakdown[key].merge(target.tally.Summary())
+	}
+	if len(breakdown) <= 1 {
+		return nil
+	}
+	return breakdown
+}
+
+// bothNetworks are networks purged by -n both, staging first
+var bothNetworks = []string{"staging", "production"}
+
+// networkTargets expands targets into one per network for -n both, purging staging and production concurrently
+func networkTargets(targets []*Config) []*Config {
+	var expanded []*Config
+	for _, t := range targets {
+		if t.network != "both" {
+			expanded = append(expanded, t)
+			continue
+		}
+		for _, network := range bothNetworks {
+			target := *t
+			target.network = network
+			target.tally = &Results{}
+			target.budget = t.budget.fresh()
+			target.targetName = "network " + network
+			if len(t.targetName) > 0 {
+				target.targetName = t.targetName + ", " + target.targetName
+			}
+			expanded = append(expanded, &target)
+		}
+	}
+	return expanded
+}
+
+// invalidateTargets purges objects from the given files, or in, against every target concurrently.
+// in, or stdin given as a file, is read into memory once when there are multiple targets, so that every
+// target reads all of it
+func invalidateTargets(ctx context.Context, targets []*Config, patterns []string, in io.Reader) error {
+	invalidate := func(config *Config, in io.Reader) error {
+		if len(patterns) == 0 {
+			return Invalidation(ctx, config, in)
+		}
+		return InvalidateFiles(ctx, config, patterns)
+	}
+	// Chunks are numbered per target, which reads the input in order
+	for _, target := range targets {
+		target.chunkSeq = new(int64)
+	}
+	if len(targets) == 1 {
+		return invalidate(targets[0], in)
+	}
+
+	var input []byte
+	if len(patterns) == 0 {
+		var err error
+		if input, err = ioutil.ReadAll(in); err != nil {
+			return err
+		}
+	}
+	var stdin []byte
+	if readsStdin(patterns) {
+		var err error
+		if stdin, err = ioutil.ReadAll(os.Stdin); err != nil {
+			return err
+		}
+	}
+	errs := make([]error, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		target.stdin = stdin
+		wg.Add(1)
+		go func(i int, target *Config) {
+			defer wg.Done()
+			if err := invalidate(target, bytes.NewReader(input)); err != nil {
+				errs[i] = fmt.Errorf("%s: %w", target.targetName, err)
+			}
+		}(i, target)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
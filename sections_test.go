@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	edgegrid "github.com/akamai-open/AkamaiOPEN-edgegrid-golang"
+)
+
+func TestSectionNames(t *testing.T) {
+	tests := map[string][]string{
+		"default":            {"default"},
+		"prod,stage,clientA": {"prod", "stage", "clientA"},
+		" prod , stage ,":    {"prod", "stage"},
+		"":                   nil,
+	}
+	for section, want := range tests {
+		if got := sectionNames(section); !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: expected %v, got %v", section, want, got)
+		}
+	}
+}
+
+func TestLoadSections(t *testing.T) {
+	config := Config{edgerc: multiSectionEdgercFile, section: "prod,stage", method: "invalidate", network: "staging", fileType: "text"}
+	if err := loadSections(&config); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(config.edgeConfs) != 2 {
+		t.Fatalf("expected credentials of 2 sections, got %d", len(config.edgeConfs))
+	}
+	if !strings.HasPrefix(config.edgeConfs[0].ClientToken, "akab-prod") || !strings.HasPrefix(config.edgeConfs[1].ClientToken, "akab-stage") {
+		t.Errorf("credentials should be loaded from each section in order: %+v", config.edgeConfs)
+	}
+	if config.section != "prod,stage" {
+		t.Errorf("-s should be kept, got %q", config.section)
+	}
+
+	config = Config{edgerc: multiSectionEdgercFile, section: "prod,clientA", method: "invalidate", network: "staging", fileType: "text"}
+	if err := loadSections(&config); err == nil || !strings.Contains(err.Error(), "clientA") {
+		t.Errorf("a missing section should be reported by name, got %v", err)
+	}
+
+	// Environment variables can only hold credentials of one account
+	setEdgegridEnv(t)
+	defer unsetEdgegridEnv()
+	config = Config{edgerc: multiSectionEdgercFile, section: "prod,stage", method: "invalidate", network: "staging", fileType: "text"}
+	if err := loadSections(&config); err == nil {
+		t.Errorf("multiple sections with credentials from environment variables should fail")
+	}
+}
+
+func TestInvalidateTargets(t *testing.T) {
+	ts, rec := newTestServer(http.StatusCreated)
+	defer ts.Close()
+
+	config := newTestConfig(ts)
+	config.section = "prod,stage"
+	for _, token := range []string{"akab-prod", "akab-stage"} {
+		edgeConf := config.edgeConf
+		edgeConf.ClientToken = token
+		config.edgeConfs = append(config.edgeConfs, edgeConf)
+	}
+	targets := sectionTargets(config)
+	if len(targets) != 2 {
+		t.Fatalf("expected a target per section, got %d", len(targets))
+	}
+
+	input := "https://example.com/a\nhttps://example.com/b\n"
+	if err := invalidateTargets(context.Background(), targets, nil, strings.NewReader(input)); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	// Every section purges the whole input with its own credentials
+	if rec.count() != 2 {
+		t.Fatalf("expected a request per section, got %d", rec.count())
+	}
+	tokens := map[string]bool{}
+	for _, req := range rec.requests {
+		auth := req.Header.Get("Authorization")
+		tokens[auth[strings.Index(auth, "client_token=")+len("client_token="):strings.Index(auth, ";")]] = true
+	}
+	if !tokens["akab-prod"] || !tokens["akab-stage"] {
+		t.Errorf("expected requests signed with each section's credentials, got %v", tokens)
+	}
+	for _, target := range targets {
+		if summary := target.tally.Summary(); summary.Requests != 1 || summary.PurgedObjects != 2 {
+			t.Errorf("section %s: unexpected summary: %s", target.section, summary)
+		}
+	}
+}
+
+func TestInvalidateTargetsStdin(t *testing.T) {
+	ts, rec := newTestServer(http.StatusCreated)
+	defer ts.Close()
+
+	f, err := ioutil.TempFile("", "purge-stdin")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.WriteString("https://example.com/a\nhttps://example.com/b\nhttps://example.com/c\n"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatalf("%s", err)
+	}
+	stdin := os.Stdin
+	os.Stdin = f
+	defer func() { os.Stdin = stdin }()
+
+	config := newTestConfig(ts)
+	config.network = "both"
+	targets := networkTargets(sectionTargets(config))
+	if err := invalidateTargets(context.Background(), targets, []string{stdinPath}, nil); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	// Targets must not split the lines of stdin between them
+	if rec.count() != 2 {
+		t.Fatalf("expected a request per network, got %d", rec.count())
+	}
+	for _, target := range targets {
+		if summary := target.tally.Summary(); summary.PurgedObjects != 3 {
+			t.Errorf("%s: expected every line of stdin to be purged, got %s", target.targetName, summary)
+		}
+	}
+}
+
+func TestNetworkTargets(t *testing.T) {
+	ts, rec := newTestServer(http.StatusCreated)
+	defer ts.Close()
+
+	config := newTestConfig(ts)
+	config.network = "both"
+	targets := networkTargets(sectionTargets(config))
+	if len(targets) != 2 {
+		t.Fatalf("expected a target per network, got %d", len(targets))
+	}
+	if targets[0].targetName != "network staging" || targets[1].targetName != "network production" {
+		t.Errorf("unexpected target names: %q, %q", targets[0].targetName, targets[1].targetName)
+	}
+
+	input := "https://example.com/a\nhttps://example.com/b\n"
+	if err := invalidateTargets(context.Background(), targets, nil, strings.NewReader(input)); err != nil {
+		t.Fatalf("%s", err)
+	}
+	paths := map[string]bool{}
+	for _, req := range rec.requests {
+		paths[req.URL.Path] = true
+	}
+	if !paths["/ccu/v3/invalidate/url/staging"] || !paths["/ccu/v3/invalidate/url/production"] {
+		t.Errorf("expected a request to each network, got %v", paths)
+	}
+	for _, target := range targets {
+		if summary := target.tally.Summary(); summary.Requests != 1 || summary.PurgedObjects != 2 {
+			t.Errorf("%s: unexpected summary: %s", target.targetName, summary)
+		}
+	}
+
+	// Sections and networks multiply
+	config.section = "prod,stage"
+	config.edgeConfs = []edgegrid.Config{config.edgeConf, config.edgeConf}
+	if targets := networkTargets(sectionTargets(config)); len(targets) != 4 || targets[3].targetName != "section stage, network production" {
+		t.Errorf("expected a target per section and network, got %d", len(targets))
+	}
+
+	// A single network is purged as is
+	config = newTestConfig(ts)
+	if targets := networkTargets([]*Config{config}); len(targets) != 1 || targets[0] != config {
+		t.Errorf("a single network should not be expanded")
+	}
+}
+
+func TestMethodTargetsBreakdown(t *testing.T) {
+	ts, rec := newTestServer(http.StatusCreated)
+	defer ts.Close()
+
+	config := newTestConfig(ts)
+	config.method, config.network = "both", "both"
+	targets := networkTargets(methodTargets(sectionTargets(config)))
+	if len(targets) != 4 || targets[1].targetName != "method invalidate, network production" || targets[2].targetName != "method delete, network staging" {
+		t.Fatalf("expected a target per method and network, got %d", len(targets))
+	}
+	input := "https://example.com/a\nhttps://example.com/b\n"
+	if err := invalidateTargets(context.Background(), targets, nil, strings.NewReader(input)); err != nil {
+		t.Fatalf("%s", err)
+	}
+	paths := map[string]bool{}
+	for _, req := range rec.requests {
+		paths[req.URL.Path] = true
+	}
+	if !paths["/ccu/v3/invalidate/url/staging"] || !paths["/ccu/v3/delete/url/production"] {
+		t.Errorf("expected a request to each method and network, got %v", paths)
+	}
+	breakdown := targetBreakdown(targets)
+	if len(breakdown) != 4 {
+		t.Fatalf("expected a summary per method and network, got %v", breakdown)
+	}
+	for _, key := range []string{"invalidate/staging", "invalidate/production", "delete/staging", "delete/production"} {
+		if summary := breakdown[key]; summary.Requests != 1 || summary.PurgedObjects != 2 {
+			t.Errorf("%s: unexpected summary: %s", key, summary)
+		}
+	}
+
+	// Sections of the same method and network are totaled together
+	config = newTestConfig(ts)
+	config.method, config.network = "both", "production"
+	config.section = "prod,stage"
+	config.edgeConfs = []edgegrid.Config{config.edgeConf, config.edgeConf}
+	targets = methodTargets(sectionTargets(config))
+	if len(targets) != 4 || targets[3].targetName != "section stage, method delete" {
+		t.Fatalf("expected a target per section and method, got %d", len(targets))
+	}
+	if err := invalidateTargets(context.Background(), targets, nil, strings.NewReader(input)); err != nil {
+		t.Fatalf("%s", err)
+	}
+	breakdown = targetBreakdown(targets)
+	if len(breakdown) != 2 || breakdown["invalidate/production"].Requests != 2 || breakdown["delete/production"].PurgedObjects != 4 {
+		t.Errorf("unexpected breakdown: %v", breakdown)
+	}
+
+	// A single method and network needs no breakdown
+	if breakdown := targetBreakdown([]*Config{newTestConfig(ts)}); breakdown != nil {
+		t.Errorf("expected no breakdown, got %v", breakdown)
+	}
+}
+
+func TestLoadSectionsEdgercDefaults(t *testing.T) {
+	// Without -m and -n, each section purges with its own method and network
+	config := &Config{edgerc: defaultsEdgercFile, section: "prod,stage", method: "invalidate", network: "staging", fileType: "text", sectionMethod: true, sectionNetwork: true}
+	if err := loadSections(config); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if config.method != "delete" || config.network != "production" {
+		t.Errorf("expected the method and network of the first section, got %s on %s", config.method, config.network)
+	}
+	targets := sectionTargets(config)
+	if targets[0].method != "delete" || targets[0].network != "production" {
+		t.Errorf("prod should purge with its method and network, got %s on %s", targets[0].method, targets[0].network)
+	}
+	if targets[1].method != "invalidate" || targets[1].network != "staging" {
+		t.Errorf("stage without the keys should keep the flag defaults, got %s on %s", targets[1].method, targets[1].network)
+	}
+
+	// Explicit flags win over the edgerc
+	config = &Config{edgerc: defaultsEdgercFile, section: "prod", method: "invalidate", network: "production", fileType: "text", sectionNetwork: true}
+	if err := loadSections(config); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if config.method != "invalidate" || config.network != "production" {
+		t.Errorf("-m should win over the edgerc, got %s on %s", config.method, config.network)
+	}
+	// -strict refuses an edgerc readable by others, which a checkout may leave it
+	dir, err := ioutil.TempDir("", "purge-edgerc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data, err := ioutil.ReadFile(defaultsEdgercFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edgerc := filepath.Join(dir, "edgerc")
+	if err := ioutil.WriteFile(edgerc, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	config = &Config{edgerc: edgerc, section: "prod", method: "invalidate", network: "staging", fileType: "text", strict: true, sectionMethod: true}
+	if err := loadSections(config); !errors.Is(err, ErrDeleteOnStaging) {
+		t.Errorf("-n staging should win over the edgerc, and fail with delete of the section under -strict, got %v", err)
+	}
+}
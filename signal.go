@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// cancelOnInterrupt calls cancel on the first SIGINT or SIGTERM. Signal handling reverts to the default
+// afterwards, so a second one terminates the process immediately. Call stop to uninstall the handler.
+func cancelOnInterrupt(cancel context.CancelFunc) (stop func()) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-sigs:
+			signal.Stop(sigs)
+			log.Warn("interrupted, waiting for in-flight requests to finish. interrupt again to exit immediately")
+			cancel()
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// stateFile is the -state file, objects purged by the last run
+type stateFile struct {
+	PurgedAt   time.Time `json:"purged_at"`
+	ObjectType string    `json:"object_type"`
+	Hostname   string    `json:"hostname,omitempty"`
+	Objects    []string  `json:"objects"`
+}
+
+// purgeState records objects purged by a run into the -state file. Under -diff, objects purged by the last
+// run are skipped, and kept in the state so that the next run skips them too. Objects of the last run
+// missing from the input drop out of it. A nil *purgeState records nothing
+type purgeState struct {
+	mu         sync.Mutex
+	path       string
+	diff       bool
+	objectType string
+	hostname   string
+	previous   map[string]bool // of the last run, empty on the first one
+	current    map[string]bool // purged or skipped by this run
+	unchanged  int             // objects skipped by -diff
+}
+
+// loadState reads the -state file of the last run. A missing file is the first run, purging everything.
+// The last run is ignored when it purged another type of objects or paths under another host
+func loadState(path, objectType, hostname string, diff bool) (*purgeState, error) {
+	path, err := homedir.Expand(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &purgeState{path: path, diff: diff, objectType: objectType, hostname: hostname,
+		previous: map[string]bool{}, current: map[string]bool{}}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var last stateFile
+	if err := json.Unmarshal(data, &last); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if last.ObjectType == objectType && last.Hostname == hostname {
+		for _, object := range last.Objects {
+			s.previous[object] = true
+		}
+	}
+	return s, nil
+}
+
+// skip reports whether object should be skipped, i.e. -diff is given and the last run purged it
+func (s *purgeState) skip(object string) bool {
+	if s == nil || !s.diff {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.previous[object] {
+		return false
+	}
+	s.current[object] = true
+	s.unchanged++
+	return true
+}
+
+// purged records objects of a request body accepted by Fast Purge
+func (s *purgeState) purged(body []byte) {
+	if s == nil {
+		return
+	}
+	objects := bodyObjects(body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, object := range objects {
+		s.current[object] = true
+	}
+}
+
+// unchangedObjects returns the number of objects skipped by -diff
+func (s *purgeState) unchangedObjects() int {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.unchanged
+}
+
+// save writes objects of this run to the -state file, replacing it atomically so that an interrupted
+// write never loses the last state
+func (s *purgeState) save(now time.Time) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	state := stateFile{PurgedAt: now, ObjectType: s.objectType, Hostname: s.hostname}
+	for object := range s.current {
+		state.Objects = append(state.Objects, object)
+	}
+	s.mu.Unlock()
+	// Sorted for diffing states of runs
+	sort.Strings(state.Objects)
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, append(data, '\n'))
+}
+
+// writeFileAtomic replaces path with data through a temporary file, so that an interrupted run never
+// leaves it half written
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
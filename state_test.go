@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPurgeStateDiff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	ts, rec := newTestServer(http.StatusCreated)
+	defer ts.Close()
+	purge := func(in string) (requests []string, unchanged int) {
+		t.Helper()
+		before := rec.count()
+		config := newTestConfig(ts)
+		if config.state, err = loadState(path, "url", "", true); err != nil {
+			t.Fatal(err)
+		}
+		if err := Invalidation(context.Background(), config, strings.NewReader(in)); err != nil {
+			t.Fatal(err)
+		}
+		if err := config.state.save(time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		for _, body := range rec.bodies[before:] {
+			requests = append(requests, string(body))
+		}
+		return requests, config.state.unchangedObjects()
+	}
+
+	// The first run has no state, purging everything
+	requests, _ := purge("https://example.com/a\nhttps://example.com/b\n")
+	if want := []string{`{"objects":["https://example.com/a","https://example.com/b"]}`}; !reflect.DeepEqual(requests, want) {
+		t.Errorf("the first run should purge everything, expected %q, got %q", want, requests)
+	}
+
+	// Nothing changed, nothing is sent
+	requests, unchanged := purge("https://example.com/a\nhttps://example.com/b\n")
+	if len(requests) != 0 || unchanged != 2 {
+		t.Errorf("an unchanged run should send nothing, got %q skipping %d", requests, unchanged)
+	}
+
+	// Only the added object is sent, the removed one drops out of the state
+	requests, unchanged = purge("https://example.com/b\nhttps://example.com/c\n")
+	if want := []string{`{"objects":["https://example.com/c"]}`}; !reflect.DeepEqual(requests, want) || unchanged != 1 {
+		t.Errorf("expected only the added object %q, got %q skipping %d", want, requests, unchanged)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state stateFile
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"https://example.com/b", "https://example.com/c"}; !reflect.DeepEqual(state.Objects, want) || state.PurgedAt.IsZero() {
+		t.Errorf("expected the state of the input %q with a timestamp, got %+v", want, state)
+	}
+
+	// A removed object added back is purged again
+	requests, _ = purge("https://example.com/a\nhttps://example.com/c\n")
+	if want := []string{`{"objects":["https://example.com/a"]}`}; !reflect.DeepEqual(requests, want) {
+		t.Errorf("expected the object added back %q, got %q", want, requests)
+	}
+}
+
+func TestPurgeStateFailed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	ts, _ := newTestServer(http.StatusBadRequest)
+	defer ts.Close()
+	config := newTestConfig(ts)
+	if config.state, err = loadState(path, "cpcode", "", false); err != nil {
+		t.Fatal(err)
+	}
+	config.objectType = "cpcode"
+	if err := Invalidation(context.Background(), config, strings.NewReader("12345\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.state.save(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	// Failed objects aren't recorded, so that -diff of the next run retries them
+	state, err := loadState(path, "cpcode", "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.skip("12345") {
+		t.Errorf("a failed object shouldn't be recorded as purged")
+	}
+
+	// The state of another type of objects is ignored
+	config.state.purged([]byte(`{"objects":[12345]}`))
+	if err := config.state.save(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if state, _ := loadState(path, "cpcode", "", true); !state.skip("12345") {
+		t.Errorf("a purged CP code should be skipped")
+	}
+	if state, _ := loadState(path, "tag", "", true); state.skip("12345") {
+		t.Errorf("the state of CP codes shouldn't skip tags")
+	}
+}
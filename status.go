@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// StatusResponse is a response of the purge status API
+type StatusResponse struct {
+	HTTPStatus     int    `json:"httpStatus"`
+	PurgeID        string `json:"purgeId"`
+	PurgeStatus    string `json:"purgeStatus"`
+	SubmittedBy    string `json:"submittedBy"`
+	SubmissionTime string `json:"submissionTime"`
+	CompletionTime string `json:"completionTime"`
+	Title          string `json:"title"`
+	Detail         string `json:"detail"`
+	SupportID      string `json:"supportId"`
+}
+
+func (status StatusResponse) String() string {
+	s := fmt.Sprintf("purgeId: %s, status: %s, submitted: %s", status.PurgeID, status.PurgeStatus, status.SubmissionTime)
+	if len(status.SubmittedBy) > 0 {
+		s += " by " + status.SubmittedBy
+	}
+	if len(status.CompletionTime) > 0 {
+		s += ", completed: " + status.CompletionTime
+	}
+	return s
+}
+
+// buildStatusURL returns the URL of the purge status API, which Fast Purge kept from CCU v2
+func buildStatusURL(config *Config, purgeID string) *url.URL {
+	return &url.URL{
+		Scheme: "https",
+		Host:   config.edgeConf.Host,
+		Path:   path.Join("/ccu/v2/purges", purgeID),
+	}
+}
+
+// queryStatus fetches the status of the purge request purgeID
+func queryStatus(ctx context.Context, config *Config, purgeID string) (status StatusResponse, err error) {
+	if len(purgeID) == 0 || strings.ContainsAny(purgeID, "/?#") {
+		return status, fmt.Errorf("%q is not a valid purge ID", purgeID)
+	}
+	req, err := http.NewRequest(http.MethodGet, buildStatusURL(config, purgeID).String(), nil)
+	if err != nil {
+		return status, err
+	}
+	req.Header.Set("User-Agent", config.userAgentOrDefault())
+	req = config.sign(req.WithContext(ctx))
+
+	resp, err := config.httpClient().Do(req)
+	if err != nil {
+		return status, withTLSHint(err)
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return status, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		// Error responses are problem details with title, detail and supportId
+		json.Unmarshal(respBody, &status)
+		return status, fmt.Errorf("failed to query status of %s: %d %s: %s (supportId: %s)",
+			purgeID, resp.StatusCode, status.Title, status.Detail, status.SupportID)
+	}
+	if err := json.Unmarshal(respBody, &status); err != nil {
+		return status, fmt.Errorf("failed to parse status of %s: %s", purgeID, err)
+	}
+	return status, nil
+}
+
+// readPurgeIDs returns purge IDs of accepted requests in results of -output, in order and without
+// duplicates, to look them up after the run exited
+func readPurgeIDs(path string) ([]string, error) {
+	path, err := homedir.Expand(path)
+	if err != nil {
+		return nil, err
+	}
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	var ids []string
+	seen := map[string]bool{}
+	dec := json.NewDecoder(fp)
+	for n := 1; ; n++ {
+		var result PurgeResult
+		if err := dec.Decode(&result); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("%s: result #%d: %s", path, n, err)
+		}
+		if len(result.PurgeID) > 0 && !seen[result.PurgeID] {
+			seen[result.PurgeID] = true
+			ids = append(ids, result.PurgeID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("%s: no purge ID is found, results of -output are expected", path)
+	}
+	return ids, nil
+}
+
+// newStatusFlagSet defines command line flags of the status subcommand bound to config
+func newStatusFlagSet(config *Config, name string, from *string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	addCommonFlags(fs, config)
+	fs.StringVar(from, "from", "", "specify a results file of -output to query every purge ID in")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags] <purgeId>\n       %s [flags] -from results.jsonl\n\nFlags:\n", name, name)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// runStatus prints the status of the purge request given by purgeId in args, or of every one found in
+// results of -from
+func runStatus(name string, args []string, stdout io.Writer) error {
+	var config Config
+	var from string
+	fs := newStatusFlagSet(&config, name, &from)
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return configError(err)
+	}
+
+	if config.showVersion {
+		fmt.Fprintln(stdout, versionString())
+		return nil
+	}
+	ids := fs.Args()
+	switch {
+	case len(from) > 0 && len(ids) > 0:
+		return configError(errors.New("you should specify purge IDs or -from, not both"))
+	case len(from) > 0:
+		var err error
+		if ids, err = readPurgeIDs(from); err != nil {
+			return configError(err)
+		}
+	case len(ids) != 1:
+		return configError(errors.New("you should specify a purge ID"))
+	}
+
+	if err := setup(&config, fs); err != nil {
+		return configError(err)
+	}
+	defer config.closeLog()
+	if err := loadEdgeConfig(&config); err != nil {
+		return configError(err)
+	}
+	if err := validateCredentials(&config); err != nil {
+		return configError(err)
+	}
+	client, err := newHTTPClient(&config)
+	if err != nil {
+		return configError(err)
+	}
+	config.client = client
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopSignal := cancelOnInterrupt(cancel)
+	defer stopSignal()
+
+	// A failed lookup doesn't stop the others, the first error fails the command
+	var failed error
+	for _, id := range ids {
+		status, err := queryStatus(ctx, &config, id)
+		if err != nil {
+			if ctx.Err() != nil {
+				return errInterrupted
+			}
+			if len(ids) == 1 {
+				return err
+			}
+			log.Error(err)
+			if failed == nil {
+				failed = err
+			}
+			continue
+		}
+		fmt.Fprintln(stdout, "[Status]", status)
+	}
+	return failed
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestQueryStatus(t *testing.T) {
+	var gotPath, gotMethod, gotAuth string
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		gotPath, gotMethod, gotAuth = req.URL.Path, req.Method, req.Header.Get("Authorization")
+		w.Write([]byte(`{"httpStatus":200,"purgeId":"` + testPurgeID + `","purgeStatus":"Done","submittedBy":"user","submissionTime":"2020-01-01T00:00:00Z","completionTime":"2020-01-01T00:00:05Z"}`))
+	}))
+	defer ts.Close()
+
+	status, err := queryStatus(context.Background(), newTestConfig(ts), testPurgeID)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if gotMethod != http.MethodGet || gotPath != "/ccu/v2/purges/"+testPurgeID {
+		t.Errorf("expected GET /ccu/v2/purges/%s, got %s %s", testPurgeID, gotMethod, gotPath)
+	}
+	if !strings.HasPrefix(gotAuth, "EG1-HMAC-SHA256 ") {
+		t.Errorf("expected an edgegrid Authorization header, got %q", gotAuth)
+	}
+	if status.PurgeStatus != "Done" || status.PurgeID != testPurgeID {
+		t.Errorf("unexpected status: %+v", status)
+	}
+	want := "purgeId: " + testPurgeID + ", status: Done, submitted: 2020-01-01T00:00:00Z by user, completed: 2020-01-01T00:00:05Z"
+	if got := status.String(); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestQueryStatusError(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"httpStatus":404,"title":"Not Found","detail":"no such purge","supportId":"` + testSupportID + `"}`))
+	}))
+	defer ts.Close()
+
+	_, err := queryStatus(context.Background(), newTestConfig(ts), testPurgeID)
+	if err == nil || !strings.Contains(err.Error(), testSupportID) {
+		t.Errorf("expected an error with the support ID, got %v", err)
+	}
+	for _, id := range []string{"", "../../v3/invalidate/url/production"} {
+		if _, err := queryStatus(context.Background(), newTestConfig(ts), id); err == nil {
+			t.Errorf("%q should be rejected as a purge ID", id)
+		}
+	}
+}
+
+func TestRunStatusRequiresPurgeID(t *testing.T) {
+	setEdgegridEnv(t)
+	defer unsetEdgegridEnv()
+	defer log.SetLevel(log.Level)
+
+	for _, args := range [][]string{{"status"}, {"status", "a", "b"}} {
+		if got := exitCode(run(args, &bytes.Buffer{})); got != exitConfig {
+			t.Errorf("%v: expected exit code %d, got %d", args, exitConfig, got)
+		}
+	}
+}
+
+func TestRunStatusFromResults(t *testing.T) {
+	const donePurgeID = "e535071c-26b2-11e7-94d7-276f2f54d939"
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch path.Base(req.URL.Path) {
+		case testPurgeID:
+			w.Write([]byte(`{"httpStatus":200,"purgeId":"` + testPurgeID + `","purgeStatus":"In-Progress","submissionTime":"2020-01-01T00:00:00Z"}`))
+		case donePurgeID:
+			w.Write([]byte(`{"httpStatus":200,"purgeId":"` + donePurgeID + `","purgeStatus":"Done","submissionTime":"2020-01-01T00:00:00Z","completionTime":"2020-01-01T00:00:05Z"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	setEdgegridEnv(t)
+	defer unsetEdgegridEnv()
+	os.Setenv("AKAMAI_HOST", strings.TrimPrefix(ts.URL, "https://"))
+	defer log.SetLevel(log.Level)
+
+	dir, err := ioutil.TempDir("", "purge-status")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+	results := filepath.Join(dir, "results.jsonl")
+	lines := `{"request_id":"a","objects":1,"status_code":201,"purge_id":"` + testPurgeID + `"}
+{"request_id":"b","objects":1,"status_code":503,"error":"failed"}
+{"request_id":"c","objects":1,"status_code":201,"purge_id":"` + donePurgeID + `"}
+{"request_id":"d","objects":1,"status_code":201,"purge_id":"` + testPurgeID + `"}
+`
+	if err := ioutil.WriteFile(results, []byte(lines), 0644); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	var out bytes.Buffer
+	if err := run([]string{"status", "-insecure", "-from", results}, &out); err != nil {
+		t.Fatalf("%s", err)
+	}
+	want := "[Status] purgeId: " + testPurgeID + ", status: In-Progress, submitted: 2020-01-01T00:00:00Z\n" +
+		"[Status] purgeId: " + donePurgeID + ", status: Done, submitted: 2020-01-01T00:00:00Z, completed: 2020-01-01T00:00:05Z\n"
+	if got := out.String(); got != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, got)
+	}
+
+	// A failed lookup fails the command after the others
+	unknown := `{"request_id":"e","objects":1,"status_code":201,"purge_id":"unknown"}` + "\n"
+	if err := ioutil.WriteFile(results, []byte(unknown+lines), 0644); err != nil {
+		t.Fatalf("%s", err)
+	}
+	out.Reset()
+	if got := exitCode(run([]string{"status", "-insecure", "-from", results}, &out)); got == exitOK {
+		t.Errorf("an unknown purge ID should fail")
+	}
+	if got := out.String(); got != want {
+		t.Errorf("known purge IDs should still be printed, expected\n%s\ngot\n%s", want, got)
+	}
+	if got := exitCode(run([]string{"status", "-insecure", "-from", results, donePurgeID}, &out)); got != exitConfig {
+		t.Errorf("purge IDs and -from together should be refused, got exit code %d", got)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// loadBodyTemplate reads the JSON object of -body-template, whose fields are added to every request
+// body. "objects" is what the client fills in, so the template can't have it
+func loadBodyTemplate(path string) (map[string]json.RawMessage, error) {
+	path, err := homedir.Expand(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	template, err := parseBodyTemplate(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return template, nil
+}
+
+func parseBodyTemplate(data []byte) (map[string]json.RawMessage, error) {
+	var template map[string]json.RawMessage
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if err := dec.Decode(&template); err != nil || template == nil {
+		return nil, errors.New("a body template should be a JSON object like {\"hostname\":\"www.example.com\"}")
+	}
+	if dec.More() {
+		return nil, errors.New("a body template should be a single JSON object")
+	}
+	if _, ok := template["objects"]; ok {
+		return nil, errors.New("a body template can't have \"objects\", they are filled in from the input")
+	}
+	return template, nil
+}
+
+// applyBodyTemplate sets "hostname" of the template as -hostname, which decides how objects are validated,
+// and returns the other fields, forwarded as extra fields of bodies
+func applyBodyTemplate(config *Config, template map[string]json.RawMessage) (map[string]json.RawMessage, error) {
+	raw, ok := template["hostname"]
+	if !ok {
+		return template, nil
+	}
+	if len(config.hostname) > 0 {
+		return nil, errors.New("you should specify a hostname by -hostname or -body-template, not both")
+	}
+	if err := json.Unmarshal(raw, &config.hostname); err != nil {
+		return nil, fmt.Errorf("\"hostname\" of a body template should be a string, but got %s", raw)
+	}
+	if err := validateHostname(config); err != nil {
+		return nil, err
+	}
+	extra := map[string]json.RawMessage{}
+	for k, v := range template {
+		if k != "hostname" {
+			extra[k] = v
+		}
+	}
+	return extra, nil
+}
+
+// templateOverHead is how many bytes fields of the template add to a body
+func templateOverHead(extra map[string]json.RawMessage) int {
+	if len(extra) == 0 {
+		return 0
+	}
+	body, err := Body{Extra: extra}.MarshalJSON()
+	if err != nil {
+		return 0
+	}
+	return len(body) - jsonOverHead
+}
+
+// withTemplate adds fields of the template a JSON body doesn't have, the body's own win
+func withTemplate(body Body, extra map[string]json.RawMessage) Body {
+	for k, v := range extra {
+		if _, ok := body.Extra[k]; ok {
+			continue
+		}
+		if body.Extra == nil {
+			body.Extra = map[string]json.RawMessage{}
+		}
+		body.Extra[k] = v
+	}
+	return body
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseBodyTemplate(t *testing.T) {
+	for _, data := range []string{``, `not json`, `["a"]`, `{"a":1}{"b":2}`, `{"objects":[]}`} {
+		if _, err := parseBodyTemplate([]byte(data)); err == nil {
+			t.Errorf("%q should be rejected", data)
+		}
+	}
+	template, err := parseBodyTemplate([]byte(`{"hostname":"www.example.com","options":{"a":1}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(template) != 2 {
+		t.Errorf("expected 2 fields, got %v", template)
+	}
+}
+
+func TestApplyBodyTemplate(t *testing.T) {
+	template := map[string]json.RawMessage{"hostname": json.RawMessage(`"www.example.com"`), "custom": json.RawMessage(`true`)}
+	config := &Config{fileType: "text"}
+	extra, err := applyBodyTemplate(config, template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.hostname != "www.example.com" || len(extra) != 1 || string(extra["custom"]) != "true" {
+		t.Errorf("expected the hostname set apart from extra fields, got %q and %v", config.hostname, extra)
+	}
+
+	if _, err := applyBodyTemplate(&Config{fileType: "text", hostname: "a.example.com"}, template); err == nil {
+		t.Error("a hostname of both -hostname and the template should be rejected")
+	}
+	if _, err := applyBodyTemplate(&Config{fileType: "json"}, template); err == nil {
+		t.Error("a hostname of the template should be rejected for JSON bodies")
+	}
+}
+
+func TestInvalidateByURLsBodyTemplate(t *testing.T) {
+	ts, rec := newTestServer(http.StatusCreated, http.StatusCreated, http.StatusCreated)
+	defer ts.Close()
+
+	config := newTestConfig(ts)
+	config.maxBody = minBodySize
+	config.bodyTemplate = map[string]json.RawMessage{"custom": json.RawMessage(`{"key": "value"}`), "flag": json.RawMessage(`true`)}
+	object := "http://example.com/" + strings.Repeat("a", minBodySize/2-40)
+	in := strings.Join([]string{object + "1", object + "2", object + "3"}, "\n")
+	if err := Invalidation(context.Background(), config, strings.NewReader(in)); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := rec.count(); n != 3 {
+		t.Fatalf("the template should count in the body size, expected 3 requests, got %d", n)
+	}
+	var bodies []string
+	for _, body := range rec.bodies {
+		if len(body) > config.maxBody {
+			t.Errorf("a body of %d bytes exceeds %d", len(body), config.maxBody)
+		}
+		bodies = append(bodies, string(body))
+	}
+	sort.Strings(bodies)
+	for i, body := range bodies {
+		want := `{"objects":["` + object + string(rune('1'+i)) + `"],"custom":{"key":"value"},"flag":true}`
+		if body != want {
+			t.Errorf("expected %s, got %s", want, body)
+		}
+	}
+}
+
+func TestInvalidateByBodiesBodyTemplate(t *testing.T) {
+	ts, rec := newTestServer(http.StatusCreated)
+	defer ts.Close()
+
+	config := newTestConfig(ts)
+	config.bodyTemplate = map[string]json.RawMessage{"custom": json.RawMessage(`"template"`), "flag": json.RawMessage(`true`)}
+	var wg sync.WaitGroup
+	input := `{"objects":["http://example.com/a"],"custom":"body"}`
+	if err := InvalidateByBodies(context.Background(), config, strings.NewReader(input), &wg); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	want := `{"objects":["http://example.com/a"],"custom":"body","flag":true}`
+	if got := rec.joinedBodies(); got != want {
+		t.Errorf("expected fields of the body to win over the template as %s, got %s", want, got)
+	}
+}
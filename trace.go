@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// requestTrace times stages of an attempt by httptrace for -trace: DNS lookup, connect and TLS handshake of
+// a new connection, and the time to the first response byte after the request was written. Hooks may be
+// called from other goroutines of the transport, so they lock
+type requestTrace struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	started map[string]time.Time
+	took    map[string]time.Duration
+	reused  bool
+}
+
+func newRequestTrace(now func() time.Time) *requestTrace {
+	return &requestTrace{now: now, started: map[string]time.Time{}, took: map[string]time.Duration{}}
+}
+
+func (t *requestTrace) start(stage string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.started[stage] = t.now()
+}
+
+func (t *requestTrace) done(stage string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if started, ok := t.started[stage]; ok {
+		t.took[stage] = t.now().Sub(started)
+	}
+}
+
+func (t *requestTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { t.start("dns") },
+		DNSDone:           func(httptrace.DNSDoneInfo) { t.done("dns") },
+		ConnectStart:      func(network, addr string) { t.start("connect") },
+		ConnectDone:       func(network, addr string, err error) { t.done("connect") },
+		TLSHandshakeStart: func() { t.start("tls") },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { t.done("tls") },
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.reused = info.Reused
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { t.start("ttfb") },
+		GotFirstResponseByte: func() { t.done("ttfb") },
+	}
+}
+
+// fields returns timings of stages which happened, a reused connection has no DNS, connect or TLS
+func (t *requestTrace) fields() logrus.Fields {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fields := logrus.Fields{"reused": t.reused}
+	for stage, took := range t.took {
+		fields[stage] = took
+	}
+	return fields
+}
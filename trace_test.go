@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"testing"
+	"time"
+)
+
+func TestRequestTrace(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	trace := newRequestTrace(clock.Now)
+	hooks := trace.clientTrace()
+	step := func(d time.Duration) { clock.Sleep(context.Background(), d) }
+
+	hooks.DNSStart(httptrace.DNSStartInfo{Host: "example.com"})
+	step(10 * time.Millisecond)
+	hooks.DNSDone(httptrace.DNSDoneInfo{})
+	hooks.ConnectStart("tcp", "192.0.2.1:443")
+	step(20 * time.Millisecond)
+	hooks.ConnectDone("tcp", "192.0.2.1:443", nil)
+	hooks.TLSHandshakeStart()
+	step(30 * time.Millisecond)
+	hooks.TLSHandshakeDone(tls.ConnectionState{}, nil)
+	hooks.GotConn(httptrace.GotConnInfo{})
+	hooks.WroteRequest(httptrace.WroteRequestInfo{})
+	step(40 * time.Millisecond)
+	hooks.GotFirstResponseByte()
+
+	fields := trace.fields()
+	want := map[string]interface{}{
+		"dns":     10 * time.Millisecond,
+		"connect": 20 * time.Millisecond,
+		"tls":     30 * time.Millisecond,
+		"ttfb":    40 * time.Millisecond,
+		"reused":  false,
+	}
+	for k, v := range want {
+		if fields[k] != v {
+			t.Errorf("expected %s %v, got %v", k, v, fields[k])
+		}
+	}
+}
+
+func TestInvalidationRequestTrace(t *testing.T) {
+	ts, _ := newTestServer(http.StatusCreated, http.StatusCreated)
+	defer ts.Close()
+	hook, restore := captureLog()
+	defer restore()
+
+	config := newTestConfig(ts)
+	config.trace = true
+	sendTestRequest(config)
+	sendTestRequest(config)
+
+	var traces []map[string]interface{}
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "[Trace]" {
+			traces = append(traces, entry.Data)
+		}
+	}
+	if len(traces) != 2 {
+		t.Fatalf("expected a trace per request, got %d", len(traces))
+	}
+	first, second := traces[0], traces[1]
+	for _, field := range []string{"request_id", "connect", "tls", "ttfb"} {
+		if _, ok := first[field]; !ok {
+			t.Errorf("the trace of a new connection should have %s, got %v", field, first)
+		}
+	}
+	if first["reused"] != false || second["reused"] != true {
+		t.Errorf("the second request should reuse the connection, got %v and %v", first["reused"], second["reused"])
+	}
+	if _, ok := second["tls"]; ok {
+		t.Errorf("a reused connection has no handshake to trace, got %v", second)
+	}
+}
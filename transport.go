@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+const (
+	defaultIdleTimeout   = 90 * time.Second
+	defaultMaxIdleConns  = 16
+	defaultMinTLSVersion = "1.2"
+	// tlsSessionCacheSize is of sessions resumed by connections to hosts, one per host of -s is enough
+	tlsSessionCacheSize = 64
+)
+
+// minTLSVersions are versions -min-tls-version takes, older ones are broken
+var minTLSVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// newHTTPClient builds the client shared by all requests of a run, applying -ca-cert, -insecure, -http2 and
+// -min-tls-version.
+// Idle connections are kept for -idle-timeout up to -max-idle-conns per host, and TLS sessions are
+// resumed, so that continuous purges don't pay a full handshake per connection
+func newHTTPClient(config *Config) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A custom TLS config disables HTTP/2 unless attempted explicitly, a non-nil TLSNextProto disables it for good
+	transport.ForceAttemptHTTP2 = config.http2
+	if !config.http2 {
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	minVersion := defaultMinTLSVersion
+	if len(config.minTLSVersion) > 0 {
+		minVersion = config.minTLSVersion
+	}
+	version, ok := minTLSVersions[minVersion]
+	if !ok {
+		return nil, fmt.Errorf("you should specify a minimum TLS version is \"1.2\" or \"1.3\", got %q", minVersion)
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:         version,
+		ClientSessionCache: tls.NewLRUClientSessionCache(tlsSessionCacheSize),
+	}
+
+	if len(config.caCert) > 0 {
+		caPath, err := homedir.Expand(config.caCert)
+		if err != nil {
+			return nil, err
+		}
+		pem, err := ioutil.ReadFile(caPath)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s does not have any PEM encoded certificate", config.caCert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if config.insecure {
+		log.Warn("-insecure is set, TLS certificates are NOT verified")
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	transport.TLSClientConfig = tlsConfig
+	// Configs built by tests leave them 0, which are the defaults of flags
+	transport.IdleConnTimeout = defaultIdleTimeout
+	if config.idleTimeout > 0 {
+		transport.IdleConnTimeout = config.idleTimeout
+	}
+	transport.MaxIdleConnsPerHost = defaultMaxIdleConns
+	if config.maxIdleConns > 0 {
+		transport.MaxIdleConnsPerHost = config.maxIdleConns
+	}
+	// Keep connections of -warmup in the pool too
+	if config.warmup > transport.MaxIdleConnsPerHost {
+		transport.MaxIdleConnsPerHost = config.warmup
+	}
+	if transport.MaxIdleConns > 0 && transport.MaxIdleConns < transport.MaxIdleConnsPerHost {
+		transport.MaxIdleConns = transport.MaxIdleConnsPerHost
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// connStats counts connections requests got and TLS handshakes of new ones, traced at debug level to
+// tell whether keep-alive and session resumption work. A nil *connStats counts nothing
+type connStats struct {
+	reused     int64
+	created    int64
+	handshakes int64
+	resumed    int64
+}
+
+func (s *connStats) gotConn(reused bool) {
+	if s == nil {
+		return
+	}
+	if reused {
+		atomic.AddInt64(&s.reused, 1)
+	} else {
+		atomic.AddInt64(&s.created, 1)
+	}
+}
+
+func (s *connStats) handshake(state tls.ConnectionState, err error) {
+	if s == nil || err != nil {
+		return
+	}
+	atomic.AddInt64(&s.handshakes, 1)
+	if state.DidResume {
+		atomic.AddInt64(&s.resumed, 1)
+	}
+}
+
+func (s *connStats) String() string {
+	return fmt.Sprintf("connections: %d(reused: %d, new: %d), tls handshakes: %d(resumed: %d)",
+		atomic.LoadInt64(&s.reused)+atomic.LoadInt64(&s.created), atomic.LoadInt64(&s.reused), atomic.LoadInt64(&s.created),
+		atomic.LoadInt64(&s.handshakes), atomic.LoadInt64(&s.resumed))
+}
+
+// withTLSHint adds a hint about -ca-cert and -insecure to certificate verification errors, and about
+// -min-tls-version to handshakes failed for the version
+func withTLSHint(err error) error {
+	if err != nil && strings.Contains(err.Error(), "protocol version") {
+		return fmt.Errorf("%w (the host doesn't support the minimum TLS version of -min-tls-version)", err)
+	}
+	var unknownAuthority x509.UnknownAuthorityError
+	var invalid x509.CertificateInvalidError
+	var hostname x509.HostnameError
+	if errors.As(err, &unknownAuthority) || errors.As(err, &invalid) || errors.As(err, &hostname) {
+		return fmt.Errorf("%w (behind a TLS-intercepting proxy, specify its CA certificate with -ca-cert, or -insecure to skip verification in test environments)", err)
+	}
+	return err
+}
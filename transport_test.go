@@ -0,0 +1,231 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestNewHTTPClientCACert(t *testing.T) {
+	ts, _ := newTestServer(http.StatusCreated)
+	defer ts.Close()
+
+	// The test server's certificate is self-signed, so it is rejected with a hint by default
+	client, err := newHTTPClient(&Config{})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	_, err = client.Get(ts.URL)
+	if err == nil {
+		t.Fatalf("something went wrong, untrusted certificate should be failed but succeeded")
+	}
+	if err = withTLSHint(err); !strings.Contains(err.Error(), "-ca-cert") {
+		t.Errorf("TLS error should hint -ca-cert: %s", err)
+	}
+
+	dir, err := ioutil.TempDir("", "purge-ca")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+	caCert := filepath.Join(dir, "ca.pem")
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	if err := ioutil.WriteFile(caCert, pemBytes, 0644); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	for _, config := range []*Config{{caCert: caCert}, {insecure: true}} {
+		client, err := newHTTPClient(config)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		resp, err := client.Get(ts.URL)
+		if err != nil {
+			t.Errorf("ca-cert %q, insecure %v: %s", config.caCert, config.insecure, err)
+			continue
+		}
+		resp.Body.Close()
+	}
+
+	notPEM := filepath.Join(dir, "not.pem")
+	ioutil.WriteFile(notPEM, []byte("not a certificate"), 0644)
+	if _, err := newHTTPClient(&Config{caCert: notPEM}); err == nil {
+		t.Errorf("something went wrong, a file without certificates should be failed but succeeded")
+	}
+}
+
+func TestWithTLSHintOtherErrors(t *testing.T) {
+	err := os.ErrNotExist
+	if withTLSHint(err) != err {
+		t.Errorf("errors other than certificate verification should be returned as they are")
+	}
+}
+
+func TestNewHTTPClientHTTP2(t *testing.T) {
+	ts := httptest.NewUnstartedServer(&purgeRecorder{statuses: []int{http.StatusCreated}})
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	for http2, want := range map[bool]string{true: "HTTP/2.0", false: "HTTP/1.1"} {
+		config := newTestConfig(ts)
+		config.http2 = http2
+		config.insecure = true
+		client, err := newHTTPClient(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.client = client
+
+		hook, restore := captureLog()
+		sendTestRequest(config)
+		sendTestRequest(config)
+		restore()
+
+		var responses []*logrus.Entry
+		for _, entry := range hook.AllEntries() {
+			if entry.Message == "[Response]" {
+				responses = append(responses, entry)
+			}
+		}
+		if len(responses) != 2 {
+			t.Fatalf("-http2=%v: expected a response log per request, got %d", http2, len(responses))
+		}
+		if proto := responses[0].Data["proto"]; proto != want {
+			t.Errorf("-http2=%v: expected %s negotiated, got %v", http2, want, proto)
+		}
+		if reused := responses[1].Data["reused"]; reused != true {
+			t.Errorf("-http2=%v: the second request should reuse the connection, got %v", http2, reused)
+		}
+	}
+}
+
+func TestNewHTTPClientTLSSessionResumption(t *testing.T) {
+	// Counts full handshakes of connections, resumed ones skip them
+	var mu sync.Mutex
+	full := 0
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !req.TLS.DidResume {
+			full++
+		}
+	}))
+	defer ts.Close()
+
+	handshakes := func(cache bool) int {
+		mu.Lock()
+		full = 0
+		mu.Unlock()
+		client, err := newHTTPClient(&Config{insecure: true})
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		transport := client.Transport.(*http.Transport)
+		// A connection per request stands in for connections closed while idle under sustained load
+		transport.DisableKeepAlives = true
+		if !cache {
+			transport.TLSClientConfig.ClientSessionCache = nil
+		}
+		stats := &connStats{}
+		for i := 0; i < 5; i++ {
+			req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+			req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{TLSHandshakeDone: stats.handshake}))
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("%s", err)
+			}
+			ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+		if cache && !strings.Contains(stats.String(), "tls handshakes: 5(resumed: 4)") {
+			t.Errorf("expected resumed handshakes counted, got %s", stats)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return full
+	}
+	if n := handshakes(false); n != 5 {
+		t.Errorf("expected a full handshake per connection without the session cache, got %d", n)
+	}
+	if n := handshakes(true); n != 1 {
+		t.Errorf("expected sessions resumed after the first handshake, got %d full handshakes", n)
+	}
+}
+
+func TestNewHTTPClientIdleConns(t *testing.T) {
+	client, err := newHTTPClient(&Config{})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	transport := client.Transport.(*http.Transport)
+	if transport.IdleConnTimeout != defaultIdleTimeout || transport.MaxIdleConnsPerHost != defaultMaxIdleConns {
+		t.Errorf("expected defaults of -idle-timeout and -max-idle-conns, got %s and %d", transport.IdleConnTimeout, transport.MaxIdleConnsPerHost)
+	}
+	client, err = newHTTPClient(&Config{idleTimeout: 5 * time.Minute, maxIdleConns: 200})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	transport = client.Transport.(*http.Transport)
+	if transport.IdleConnTimeout != 5*time.Minute || transport.MaxIdleConnsPerHost != 200 || transport.MaxIdleConns < 200 {
+		t.Errorf("expected -idle-timeout 5m and -max-idle-conns 200, got %s, %d and %d in total", transport.IdleConnTimeout, transport.MaxIdleConnsPerHost, transport.MaxIdleConns)
+	}
+}
+
+func TestNewHTTPClientMinTLSVersion(t *testing.T) {
+	for _, tt := range []struct {
+		serverMax  uint16
+		minVersion string
+		ok         bool
+	}{
+		{tls.VersionTLS11, "", false},
+		{tls.VersionTLS11, "1.2", false},
+		{tls.VersionTLS12, "1.2", true},
+		{tls.VersionTLS12, "1.3", false},
+		{tls.VersionTLS13, "1.3", true},
+	} {
+		ts := httptest.NewUnstartedServer(&purgeRecorder{statuses: []int{http.StatusCreated}})
+		ts.TLS = &tls.Config{MinVersion: tls.VersionTLS10, MaxVersion: tt.serverMax}
+		ts.StartTLS()
+		client, err := newHTTPClient(&Config{insecure: true, minTLSVersion: tt.minVersion})
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		resp, err := client.Get(ts.URL)
+		ts.Close()
+		if tt.ok {
+			if err != nil {
+				t.Errorf("-min-tls-version %q should connect to a host of up to %s: %s", tt.minVersion, tlsVersionName(tt.serverMax), err)
+				continue
+			}
+			if resp.TLS.Version < minTLSVersions[tt.minVersion] {
+				t.Errorf("expected at least TLS %s, got %s", tt.minVersion, tlsVersionName(resp.TLS.Version))
+			}
+			resp.Body.Close()
+			continue
+		}
+		if err == nil {
+			resp.Body.Close()
+			t.Errorf("-min-tls-version %q should reject a host of up to %s", tt.minVersion, tlsVersionName(tt.serverMax))
+			continue
+		}
+		if err = withTLSHint(err); !strings.Contains(err.Error(), "-min-tls-version") {
+			t.Errorf("the rejected handshake should hint -min-tls-version: %s", err)
+		}
+	}
+
+	if _, err := newHTTPClient(&Config{minTLSVersion: "1.1"}); err == nil {
+		t.Error("-min-tls-version 1.1 should be rejected")
+	}
+}
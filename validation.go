@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Kinds of Validation errors, to tell them apart with errors.Is
+var (
+	ErrMissingHost         = errors.New("missing host")
+	ErrMissingClientToken  = errors.New("missing client token")
+	ErrMissingClientSecret = errors.New("missing client secret")
+	ErrMissingAccessToken  = errors.New("missing access token")
+	ErrInvalidHost         = errors.New("invalid host")
+	ErrInsecureEdgerc      = errors.New("insecure edgerc")
+	ErrInvalidMethod       = errors.New("invalid method")
+	ErrInvalidNetwork      = errors.New("invalid network")
+	ErrDeleteOnStaging     = errors.New("delete on staging")
+	ErrInvalidFileType     = errors.New("invalid file type")
+	ErrInvalidOption       = errors.New("invalid option") // any other flag out of range
+)
+
+// ValidationError is an invalid value of Field, a flag like "-n" or an edgerc parameter like "host".
+// Its message is for humans, Err is one of the kinds above
+type ValidationError struct {
+	Field string
+	Err   error
+	msg   string
+}
+
+func (e *ValidationError) Error() string {
+	return e.msg
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// invalid returns a ValidationError of field with a message formatted as fmt.Sprintf does
+func invalid(field string, kind error, format string, args ...interface{}) error {
+	return &ValidationError{Field: field, Err: kind, msg: fmt.Sprintf(format, args...)}
+}
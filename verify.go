@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// verifyConcurrency is the number of HEAD requests of -verify in flight
+const verifyConcurrency = 8
+
+// edgeVerifier collects URLs of accepted purges for -verify, which checks at the edge that they are no
+// longer cached. It is shared by all targets. A nil *edgeVerifier collects nothing
+type edgeVerifier struct {
+	mu       sync.Mutex
+	hostname string // of -hostname, objects are paths then
+	seen     map[string]bool
+	urls     []string
+	wait     time.Duration // the longest estimatedSeconds of accepted purges
+}
+
+func newEdgeVerifier(hostname string) *edgeVerifier {
+	return &edgeVerifier{hostname: hostname, seen: map[string]bool{}}
+}
+
+// purged records URLs of a body accepted by Fast Purge, estimated to take effect in estimatedSeconds
+func (v *edgeVerifier) purged(body []byte, estimatedSeconds int) {
+	if v == nil {
+		return
+	}
+	objects := bodyObjects(body)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if wait := time.Duration(estimatedSeconds) * time.Second; wait > v.wait {
+		v.wait = wait
+	}
+	for _, object := range objects {
+		if len(v.hostname) > 0 {
+			object = "https://" + v.hostname + object
+		}
+		if !v.seen[object] {
+			v.seen[object] = true
+			v.urls = append(v.urls, object)
+		}
+	}
+}
+
+// edgeCacheStatus tells from X-Cache of an Akamai edge whether a response came from its cache,
+// e.g. "TCP_HIT from a23-1-2-3.deploy.akamaitechnologies.com". REFRESH_HIT was revalidated with the
+// origin, so it counts as refreshed. ok is false without X-Cache, the edge isn't telling
+func edgeCacheStatus(header http.Header) (cached bool, ok bool) {
+	xCache := strings.ToUpper(header.Get("X-Cache"))
+	switch {
+	case len(xCache) == 0:
+		return false, false
+	case strings.Contains(xCache, "MISS") || strings.Contains(xCache, "REFRESH"):
+		return false, true
+	}
+	return strings.Contains(xCache, "HIT"), true
+}
+
+// verifyReport is the outcome of -verify
+type verifyReport struct {
+	refreshed int
+	cached    []string // URLs still served from cache
+	unknown   []string // URLs whose HEAD failed or which had no X-Cache
+}
+
+func (r verifyReport) String() string {
+	return fmt.Sprintf("urls: %d(refreshed: %d, still cached: %d, unknown: %d)",
+		r.refreshed+len(r.cached)+len(r.unknown), r.refreshed, len(r.cached), len(r.unknown))
+}
+
+// verify sends HEAD requests asking the edge for X-Cache to every URL recorded, after waiting for
+// estimatedSeconds under -verify-wait. It is best effort, failures are only reported
+func (v *edgeVerifier) verify(ctx context.Context, config *Config) verifyReport {
+	if v == nil {
+		return verifyReport{}
+	}
+	v.mu.Lock()
+	urls, wait := append([]string(nil), v.urls...), v.wait
+	v.mu.Unlock()
+	if len(urls) == 0 {
+		return verifyReport{}
+	}
+	if config.verifyWait && wait > 0 {
+		log.Infof("[Verify] waiting %s estimated for purges to take effect", wait)
+		if err := config.clockOrDefault().Sleep(ctx, wait); err != nil {
+			return verifyReport{unknown: urls}
+		}
+	}
+
+	client := config.httpClient()
+	cached := make([]bool, len(urls))
+	known := make([]bool, len(urls))
+	sem := make(chan struct{}, verifyConcurrency)
+	var wg sync.WaitGroup
+	for i, url := range urls {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, url string) {
+			defer func() { <-sem; wg.Done() }()
+			cached[i], known[i] = checkEdgeCache(ctx, client, config, url)
+		}(i, url)
+	}
+	wg.Wait()
+
+	var report verifyReport
+	for i, url := range urls {
+		switch {
+		case !known[i]:
+			report.unknown = append(report.unknown, url)
+		case cached[i]:
+			log.WithField("url", url).Warn("[Verify] still cached at the edge")
+			report.cached = append(report.cached, url)
+		default:
+			report.refreshed++
+		}
+	}
+	return report
+}
+
+// checkEdgeCache sends a HEAD request to url with the Akamai Pragma header turning X-Cache on
+func checkEdgeCache(ctx context.Context, client doer, config *Config, url string) (cached bool, ok bool) {
+	req, err := http.NewRequest(http.MethodHead, url, nil)
+	if err != nil {
+		log.WithField("url", url).WithError(err).Warn("[Verify] can't check")
+		return false, false
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Pragma", "akamai-x-cache-on")
+	req.Header.Set("User-Agent", config.userAgentOrDefault())
+	resp, err := client.Do(req)
+	if err != nil {
+		log.WithField("url", url).WithError(err).Warn("[Verify] can't check")
+		return false, false
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	cached, ok = edgeCacheStatus(resp.Header)
+	if !ok {
+		log.WithField("url", url).WithField("status", resp.StatusCode).Warn("[Verify] no X-Cache in the response, the host may not be on Akamai")
+	}
+	return cached, ok
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEdgeCacheStatus(t *testing.T) {
+	tests := []struct {
+		xCache     string
+		cached, ok bool
+	}{
+		{"TCP_MISS from a23-1-2-3.deploy.akamaitechnologies.com (AkamaiGHost/10.0)", false, true},
+		{"TCP_HIT from a23-1-2-3.deploy.akamaitechnologies.com", true, true},
+		{"TCP_MEM_HIT from a23-1-2-3.deploy.akamaitechnologies.com", true, true},
+		{"TCP_REFRESH_HIT from a23-1-2-3.deploy.akamaitechnologies.com", false, true},
+		{"", false, false},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		if len(tt.xCache) > 0 {
+			header.Set("X-Cache", tt.xCache)
+		}
+		if cached, ok := edgeCacheStatus(header); cached != tt.cached || ok != tt.ok {
+			t.Errorf("%q: expected %v, %v, got %v, %v", tt.xCache, tt.cached, tt.ok, cached, ok)
+		}
+	}
+}
+
+func TestInvalidationRequestVerify(t *testing.T) {
+	var mu sync.Mutex
+	var pragmas []string
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"httpStatus":201,"estimatedSeconds":5,"purgeId":"e535071c-26b2-11e7-94d7-276f2f54d938"}`))
+			return
+		}
+		mu.Lock()
+		pragmas = append(pragmas, r.Header.Get("Pragma"))
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/fresh":
+			w.Header().Set("X-Cache", "TCP_MISS from a23-1-2-3.deploy.akamaitechnologies.com")
+		case "/stale":
+			w.Header().Set("X-Cache", "TCP_HIT from a23-1-2-3.deploy.akamaitechnologies.com")
+		}
+	}))
+	defer ts.Close()
+
+	config := newTestConfig(ts)
+	config.verifier = newEdgeVerifier("")
+	config.verifyWait = true
+	clock := &fakeClock{}
+	config.clock = clock
+	body := `{"objects":["` + ts.URL + `/fresh","` + ts.URL + `/stale","` + ts.URL + `/none","` + ts.URL + `/fresh"]}`
+	var wg sync.WaitGroup
+	wg.Add(1)
+	invalidationRequest(context.Background(), config, []byte(body), &wg)
+
+	report := config.verifier.verify(context.Background(), config)
+	if report.refreshed != 1 {
+		t.Errorf("expected 1 refreshed URL, got %d", report.refreshed)
+	}
+	if len(report.cached) != 1 || report.cached[0] != ts.URL+"/stale" {
+		t.Errorf("expected /stale still cached, got %q", report.cached)
+	}
+	if len(report.unknown) != 1 || report.unknown[0] != ts.URL+"/none" {
+		t.Errorf("expected /none unknown without X-Cache, got %q", report.unknown)
+	}
+	if got := report.String(); got != "urls: 3(refreshed: 1, still cached: 1, unknown: 1)" {
+		t.Errorf("unexpected report: %s", got)
+	}
+	if sleeps := clock.sleeps(); len(sleeps) != 1 || sleeps[0] != 5*time.Second {
+		t.Errorf("-verify-wait should wait estimatedSeconds, got %v", sleeps)
+	}
+	for _, pragma := range pragmas {
+		if pragma != "akamai-x-cache-on" {
+			t.Errorf("HEAD requests should ask for X-Cache, got Pragma %q", pragma)
+		}
+	}
+
+	// Paths of -hostname are verified as URLs of it
+	v := newEdgeVerifier(strings.TrimPrefix(ts.URL, "https://"))
+	v.purged([]byte(`{"hostname":"example.com","objects":["/fresh"]}`), 0)
+	if report := v.verify(context.Background(), newTestConfig(ts)); report.refreshed != 1 {
+		t.Errorf("expected the path refreshed, got %s", report)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import "fmt"
+
+// Build metadata, populated with -ldflags "-X main.version=... -X main.commit=... -X main.date=..."
+var (
+	version = "dev"
+	commit  = "unknown"
+	date    = "unknown"
+)
+
+func versionString() string {
+	return fmt.Sprintf("akamai-fast-purge-client %s (commit: %s, built: %s)", version, commit, date)
+}
+
+// defaultUserAgent tells requests of this tool apart in Akamai-side logs
+func defaultUserAgent() string {
+	return "akamai-fast-purge-client/" + version
+}
+
+// userAgentOrDefault returns -user-agent, the tool name and version unless it is given
+func (config *Config) userAgentOrDefault() string {
+	if len(config.userAgent) == 0 {
+		return defaultUserAgent()
+	}
+	return config.userAgent
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestVersionFlag(t *testing.T) {
+	// -version is handled before edgerc loading, so a missing edgerc must not matter
+	var stdout bytes.Buffer
+	if err := run([]string{"-version", "-c", invalidInvalidationRequestFile}, &stdout); err != nil {
+		t.Fatalf("-version should exit successfully: %s", err)
+	}
+	if out := stdout.String(); !strings.HasPrefix(out, "akamai-fast-purge-client "+version) || !strings.Contains(out, commit) {
+		t.Errorf("unexpected version output: %q", out)
+	}
+}
+
+func TestUserAgent(t *testing.T) {
+	ts, rec := newTestServer(http.StatusCreated)
+	defer ts.Close()
+
+	config := newTestConfig(ts)
+	sendTestRequest(config)
+	config.userAgent = "deploy-bot/1.2"
+	sendTestRequest(config)
+
+	want := []string{"akamai-fast-purge-client/" + version, "deploy-bot/1.2"}
+	for i, req := range rec.requests {
+		if got := req.Header.Get("User-Agent"); got != want[i] {
+			t.Errorf("request %d: expected User-Agent %q, got %q", i, want[i], got)
+		}
+	}
+	if len(rec.requests) != len(want) {
+		t.Errorf("expected %d requests, got %d", len(want), len(rec.requests))
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sync"
+)
+
+// warmUp opens up to n connections to the purge host of config before the first purge request, so that
+// the initial burst doesn't wait for TLS handshakes. Connections are opened by concurrent HEAD requests,
+// unauthenticated as nothing is read from them, and are left idle in the pool of the shared transport.
+// It is best effort, failures are only logged. It returns the number of connections opened
+func warmUp(ctx context.Context, config *Config, n int) int {
+	client := config.httpClient()
+	if transport, ok := transportOf(client); ok && transport.MaxConnsPerHost > 0 && n > transport.MaxConnsPerHost {
+		n = transport.MaxConnsPerHost
+	}
+	target := (&url.URL{Scheme: "https", Host: config.edgeConf.Host, Path: "/"}).String()
+	clock := config.clockOrDefault()
+	start := clock.Now()
+
+	var mu sync.Mutex
+	opened := 0
+	// Requests are released together, so that none of them finds a connection of another idle
+	ready := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			<-ready
+			req, err := http.NewRequest(http.MethodHead, target, nil)
+			if err != nil {
+				log.WithError(err).Warn("[Warm-up] failed")
+				return
+			}
+			req.Header.Set("User-Agent", config.userAgentOrDefault())
+			req = req.WithContext(httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+				GotConn: func(info httptrace.GotConnInfo) {
+					if !info.Reused {
+						mu.Lock()
+						opened++
+						mu.Unlock()
+					}
+				},
+			}))
+			resp, err := client.Do(req)
+			if err != nil {
+				log.WithError(withTLSHint(err)).Warn("[Warm-up] failed")
+				return
+			}
+			// A connection is reused only once its body is read
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}()
+	}
+	close(ready)
+	wg.Wait()
+	log.WithField("host", config.edgeConf.Host).WithField("took", clock.Now().Sub(start)).Infof("[Warm-up] opened %d connections", opened)
+	return opened
+}
+
+// transportOf returns the transport of client when it is an *http.Client over an *http.Transport
+func transportOf(client doer) (*http.Transport, bool) {
+	c, ok := client.(*http.Client)
+	if !ok {
+		return nil, false
+	}
+	transport, ok := c.Transport.(*http.Transport)
+	return transport, ok
+}
+
+// warmUpTargets warms up connections to the purge host of every target, once per host
+func warmUpTargets(ctx context.Context, targets []*Config, n int) {
+	seen := map[string]bool{}
+	for _, target := range targets {
+		if seen[target.edgeConf.Host] {
+			continue
+		}
+		seen[target.edgeConf.Host] = true
+		warmUp(ctx, target, n)
+	}
+}
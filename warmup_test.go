@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRunWarmup(t *testing.T) {
+	var mu sync.Mutex
+	var methods []string
+	connections := 0
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		methods = append(methods, req.Method)
+		mu.Unlock()
+		if req.Method == http.MethodPost {
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"httpStatus":201,"purgeId":"` + testPurgeID + `"}`))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	ts.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			mu.Lock()
+			connections++
+			mu.Unlock()
+		}
+	}
+	ts.StartTLS()
+	defer ts.Close()
+	setEdgegridEnv(t)
+	defer unsetEdgegridEnv()
+	os.Setenv("AKAMAI_HOST", strings.TrimPrefix(ts.URL, "https://"))
+	defer log.SetLevel(log.Level)
+
+	if err := run([]string{"-insecure", "-http2=false", "-warmup", "3", "-url", "https://example.com/"}, &bytes.Buffer{}); err != nil {
+		t.Fatalf("%s", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(methods) != 4 || methods[3] != http.MethodPost {
+		t.Fatalf("expected 3 warm-up requests before the purge, got %v", methods)
+	}
+	for _, method := range methods[:3] {
+		if method != http.MethodHead {
+			t.Errorf("warm-up requests should be HEAD, got %v", methods)
+		}
+	}
+	if connections != 3 {
+		t.Errorf("expected 3 connections warmed up and the purge on one of them, got %d", connections)
+	}
+}
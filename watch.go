@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// repeatTargets purges files of patterns against targets every interval until ctx is done, re-opening
+// the files every cycle to pick up edits. A summary of each cycle is written to out, and the tally of
+// each target totals every cycle afterwards. Errors of a cycle are only logged, the next one may succeed
+func repeatTargets(ctx context.Context, targets []*Config, patterns []string, interval time.Duration, out io.Writer) error {
+	totals := make([]Summary, len(targets))
+	defer func() {
+		for i, target := range targets {
+			target.tally = &Results{summary: totals[i]}
+		}
+	}()
+	clock := targets[0].clockOrDefault()
+	for cycle := 1; ; cycle++ {
+		for _, target := range targets {
+			target.tally = &Results{}
+			target.budget = target.budget.fresh()
+		}
+		err := invalidateTargets(ctx, targets, patterns, nil)
+		var summary Summary
+		for i, target := range targets {
+			totals[i] = totals[i].merge(target.tally.Summary())
+			summary = summary.merge(target.tally.Summary())
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		if err != nil {
+			log.Errorf("cycle %d: %s", cycle, err)
+		}
+		fmt.Fprintf(out, "[Cycle %d] %s\n", cycle, summary)
+		if err := clock.Sleep(ctx, interval); err != nil {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRepeatTargets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "interval")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	list := filepath.Join(dir, "urls.txt")
+	if err := ioutil.WriteFile(list, []byte("https://www.example.com/first\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ts, rec := newTestServer(http.StatusCreated)
+	defer ts.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	config := newTestConfig(ts)
+	var mu sync.Mutex
+	cycles := 0
+	config.onResult = func(PurgeResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		cycles++
+		switch cycles {
+		case 1:
+			// Edits are picked up by the next cycle
+			if err := ioutil.WriteFile(list, []byte("https://www.example.com/second\n"), 0644); err != nil {
+				t.Error(err)
+			}
+		case 2:
+			cancel()
+		}
+	}
+
+	var out bytes.Buffer
+	targets := []*Config{config}
+	if err := repeatTargets(ctx, targets, []string{list}, 10*time.Millisecond, &out); err != nil {
+		t.Fatal(err)
+	}
+	if n := rec.count(); n != 2 {
+		t.Fatalf("expected a request per cycle until cancelled, got %d", n)
+	}
+	for i, want := range []string{"/first", "/second"} {
+		if !bytes.Contains(rec.bodies[i], []byte(want)) {
+			t.Errorf("cycle %d should purge the file as it is then, got %s", i+1, rec.bodies[i])
+		}
+	}
+	if !strings.Contains(out.String(), "[Cycle 1] requests: 1") {
+		t.Errorf("expected a summary of the first cycle, got %q", out.String())
+	}
+	if summary := config.tally.Summary(); summary.Requests != 2 || summary.Succeeded != 2 {
+		t.Errorf("the tally should total every cycle: %s", summary)
+	}
+}